@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+//配置文件支持的扩展名,按顺序查找
+var cfgFileExtList = []string{".json",".yaml",".yml",".toml"}
+
+//${ENV_VAR}或${ENV_VAR:default}
+var envVarRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:([^}]*))?\}`)
+
+//在目录dir中查找name.json、name.yaml、name.yml或name.toml,同时存在多个时返回错误
+func findCfgFile(dir string,name string) (string,error) {
+	var filePathList []string
+	for _,ext := range cfgFileExtList {
+		filePath := dir+"/"+name+ext
+		if _,err := os.Stat(filePath);err == nil {
+			filePathList = append(filePathList,filePath)
+		}
+	}
+
+	if len(filePathList) == 0 {
+		return dir+"/"+name+".json",fmt.Errorf("cannot find %s/%s%s",dir,name,"{"+strings.Join(cfgFileExtList,",")+"}")
+	}
+	if len(filePathList) > 1 {
+		return filePathList[0],fmt.Errorf("config file %s are conflicting",strings.Join(filePathList,","))
+	}
+
+	return filePathList[0],nil
+}
+
+//读取配置文件,替换环境变量后按扩展名解析,再按json规则转换到out
+func readCfgFile(filePath string,out interface{}) error {
+	d,err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	d,err = expandEnv(d)
+	if err != nil {
+		return err
+	}
+
+	var v interface{}
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".yaml",".yml":
+		err = yaml.Unmarshal(d,&v)
+	case ".toml":
+		m := map[string]interface{}{}
+		err = toml.Unmarshal(d,&m)
+		v = m
+	default:
+		return json.Unmarshal(d,out)
+	}
+	if err != nil {
+		return err
+	}
+
+	//统一转换成json,字段名匹配与数值类型与json配置一致
+	d,err = json.Marshal(normalizeCfgValue(v))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(d,out)
+}
+
+//替换${ENV_VAR:default},环境变量不存在时使用default,没有default时返回错误
+func expandEnv(d []byte) ([]byte,error) {
+	var errList []string
+	d = envVarRegexp.ReplaceAllFunc(d,func(s []byte) []byte {
+		match := envVarRegexp.FindSubmatch(s)
+		if value,ok := os.LookupEnv(string(match[1]));ok == true {
+			return []byte(value)
+		}
+		if len(match[2]) > 0 {
+			return match[3]
+		}
+
+		errList = append(errList,string(match[1]))
+		return s
+	})
+
+	if len(errList) > 0 {
+		return nil,fmt.Errorf("environment variable %s is not set",strings.Join(errList,","))
+	}
+
+	return d,nil
+}
+
+//yaml中key可能不是字符串
+func normalizeCfgValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k,elem := range value {
+			value[k] = normalizeCfgValue(elem)
+		}
+		return value
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{},len(value))
+		for k,elem := range value {
+			m[fmt.Sprint(k)] = normalizeCfgValue(elem)
+		}
+		return m
+	case []interface{}:
+		for i,elem := range value {
+			value[i] = normalizeCfgValue(elem)
+		}
+		return value
+	case []map[string]interface{}:
+		list := make([]interface{},len(value))
+		for i,elem := range value {
+			list[i] = normalizeCfgValue(elem)
+		}
+		return list
+	}
+
+	return v
+}
+
+//启动时通过--set ServiceName.Key=value覆盖的服务配置
+type serviceCfgOverride struct {
+	serviceName string
+	keyPath []string
+	value interface{}
+}
+
+//解析--set参数,格式为ServiceName.Key=value,Key可以使用.访问下级字段
+//value按json解析,解析失败时作为字符串,如--set TcpService.MaxConnNum=5000 --set TcpService.ListenAddr=0.0.0.0:9030
+func (slf *Cluster) AddServiceCfgOverride(setting string) error {
+	idx := strings.Index(setting,"=")
+	if idx <= 0 {
+		return fmt.Errorf("invalid option --set %s, expect ServiceName.Key=value",setting)
+	}
+
+	keyPath := strings.Split(setting[:idx],".")
+	if len(keyPath) < 2 {
+		return fmt.Errorf("invalid option --set %s, expect ServiceName.Key=value",setting)
+	}
+	for _,key := range keyPath {
+		if key == "" {
+			return fmt.Errorf("invalid option --set %s, expect ServiceName.Key=value",setting)
+		}
+	}
+
+	var value interface{}
+	if json.Unmarshal([]byte(setting[idx+1:]),&value) != nil {
+		value = setting[idx+1:]
+	}
+
+	slf.serviceCfgOverride = append(slf.serviceCfgOverride,serviceCfgOverride{serviceName:keyPath[0],keyPath:keyPath[1:],value:value})
+	return nil
+}
+
+//将--set覆盖到本结点生效的服务配置上,NodeService中有该服务时覆盖NodeService,否则覆盖Service
+func (slf *Cluster) applyServiceCfgOverride(nodeId int,serviceCfg map[string]interface{},mapNodeServiceCfg map[int]map[string]interface{}) {
+	for _,override := range slf.serviceCfgOverride {
+		cfgMap := serviceCfg
+		if nodeServiceCfg,ok := mapNodeServiceCfg[nodeId];ok == true {
+			if _,ok = nodeServiceCfg[override.serviceName];ok == true {
+				cfgMap = nodeServiceCfg
+			}
+		}
+
+		m,ok := cfgMap[override.serviceName].(map[string]interface{})
+		if ok == false {
+			m = map[string]interface{}{}
+			cfgMap[override.serviceName] = m
+		}
+		for _,key := range override.keyPath[:len(override.keyPath)-1] {
+			child,ok := m[key].(map[string]interface{})
+			if ok == false {
+				child = map[string]interface{}{}
+				m[key] = child
+			}
+			m = child
+		}
+		m[override.keyPath[len(override.keyPath)-1]] = override.value
+	}
+}
@@ -0,0 +1,432 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/rpc"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//AdminToken的最大长度
+const maxAdminTokenLen = 256
+
+//检查时记录的监听地址
+type listenAddr struct {
+	desc string //如NodeId 1 TcpService
+	host string
+	port string
+}
+
+type cfgChecker struct {
+	errList []error
+	mapNodeSubNet map[int]string         //map[NodeId]子网名称
+	mapHostListen map[string][]listenAddr //map[结点rpc地址的host]该机器上的所有监听地址
+}
+
+func (slf *cfgChecker) addError(format string,a ...interface{}) {
+	slf.errList = append(slf.errList,fmt.Errorf(format,a...))
+}
+
+//使用与InitCfg相同的解析方式检查config/cluster下所有子网的配置,返回发现的所有问题
+//isSetup用于判断ServiceList中的服务是否已通过node.Setup安装,为nil时不检查
+func (slf *Cluster) CheckConfig(isSetup func(serviceName string) bool) []error {
+	checker := cfgChecker{mapNodeSubNet:map[int]string{},mapHostListen:map[string][]listenAddr{}}
+	clusterCfgPath := strings.TrimRight(configdir,"/")  +"/cluster"
+	fileInfoList,err := ioutil.ReadDir(clusterCfgPath)
+	if err != nil {
+		checker.addError("Read dir %s is fail :%+v",clusterCfgPath,err)
+		return checker.errList
+	}
+
+	mapSubNetInfo := map[string]SubNet{}
+	for _,f := range fileInfoList {
+		if f.IsDir() == false {
+			continue
+		}
+
+		subnetPath := strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name()
+		filePath,err := findCfgFile(subnetPath,"cluster")
+		if err != nil {
+			checker.addError("%+v",err)
+			continue
+		}
+		subnet,ok := checker.checkClusterCfg(slf,filePath,f.Name(),isSetup)
+		if ok == true {
+			mapSubNetInfo[f.Name()] = subnet
+		}
+	}
+	if len(mapSubNetInfo) == 0 && len(checker.errList) == 0 {
+		checker.addError("cannot find any subnet in %s",clusterCfgPath)
+	}
+
+	for _,f := range fileInfoList {
+		subnet,ok := mapSubNetInfo[f.Name()]
+		if ok == false {
+			continue
+		}
+
+		subnetPath := strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name()
+		clusterFilePath,_ := findCfgFile(subnetPath,"cluster")
+		checker.checkExposeService(clusterFilePath,subnet,mapSubNetInfo)
+		serviceFilePath,err := findCfgFile(subnetPath,"service")
+		if err != nil {
+			checker.addError("%+v",err)
+			continue
+		}
+		checker.checkServiceCfg(serviceFilePath,subnet)
+	}
+
+	checker.checkListenAddr()
+	return checker.errList
+}
+
+func (slf *cfgChecker) checkClusterCfg(cls *Cluster,filePath string,subnetName string,isSetup func(serviceName string) bool) (SubNet,bool) {
+	subnet,err := cls.ReadClusterConfig(filePath)
+	if err != nil {
+		slf.addError("read file %s is error:%+v",filePath,err)
+		return SubNet{},false
+	}
+	subnet.SubNetName = subnetName
+	slf.checkUnknownKeys(filePath,reflect.TypeOf(SubNet{}))
+
+	if len(subnet.NodeList) == 0 {
+		slf.addError("%s NodeList is empty",filePath)
+	}
+	if subnet.RpcTLS.IsEnabled() {
+		if _,_,err = subnet.RpcTLS.load();err != nil {
+			slf.addError("%s %+v",filePath,err)
+		}
+	}
+
+	mapNodeId := map[int]bool{}
+	for i,nodeInfo := range subnet.NodeList {
+		nodeDesc := fmt.Sprintf("%s NodeList[%d]",filePath,i)
+		if nodeInfo.NodeId <= 0 {
+			slf.addError("%s NodeId %d is invalid",nodeDesc,nodeInfo.NodeId)
+		}else if otherSubNet,ok := slf.mapNodeSubNet[nodeInfo.NodeId];ok == true {
+			if otherSubNet == subnetName {
+				slf.addError("%s NodeId %d is duplicated in subnet %s",nodeDesc,nodeInfo.NodeId,subnetName)
+			}else{
+				slf.addError("%s NodeId %d is duplicated in subnet %s and %s",nodeDesc,nodeInfo.NodeId,otherSubNet,subnetName)
+			}
+		}else{
+			slf.mapNodeSubNet[nodeInfo.NodeId] = subnetName
+		}
+		mapNodeId[nodeInfo.NodeId] = true
+
+		host,port,err := net.SplitHostPort(nodeInfo.ListenAddr)
+		if err != nil {
+			slf.addError("%s ListenAddr %q is invalid:%+v",nodeDesc,nodeInfo.ListenAddr,err)
+		}else{
+			host = normalizeHost(host)
+			slf.mapHostListen[host] = append(slf.mapHostListen[host],listenAddr{desc:fmt.Sprintf("NodeId %d rpc",nodeInfo.NodeId),host:host,port:port})
+		}
+
+		if _,err = rpc.GetCompressType(nodeInfo.Compress);err != nil {
+			slf.addError("%s %s",nodeDesc,err.Error())
+		}
+		if nodeInfo.RpcVersion < 0 || nodeInfo.RpcVersion > rpc.RpcVersion {
+			slf.addError("%s RpcVersion %d is invalid,expect 0 to %d",nodeDesc,nodeInfo.RpcVersion,rpc.RpcVersion)
+		}
+
+		mapService := map[string]bool{}
+		for _,s := range nodeInfo.ServiceList {
+			serviceName := strings.TrimPrefix(s,"_")
+			if mapService[serviceName] == true {
+				slf.addError("%s service %s is duplicated",nodeDesc,serviceName)
+				continue
+			}
+			mapService[serviceName] = true
+
+			if isSetup != nil && serviceName != ClusterServiceName && isSetup(serviceName) == false {
+				slf.addError("%s service %s is not setup by node.Setup",nodeDesc,serviceName)
+			}
+		}
+	}
+
+	if subnet.RegistryNodeId != 0 && mapNodeId[subnet.RegistryNodeId] == false {
+		slf.addError("%s RegistryNodeId %d not in subnet %s",filePath,subnet.RegistryNodeId,subnetName)
+	}
+	//口令在握手中发送,握手消息的长度是固定的
+	if len(subnet.AdminToken) > maxAdminTokenLen {
+		slf.addError("%s AdminToken length %d exceeds %d",filePath,len(subnet.AdminToken),maxAdminTokenLen)
+	}
+
+	return *subnet,true
+}
+
+func (slf *cfgChecker) checkExposeService(filePath string,subnet SubNet,mapSubNetInfo map[string]SubNet) {
+	serviceNameList := make([]string,0,len(subnet.ExposeService))
+	for serviceName,_ := range subnet.ExposeService {
+		serviceNameList = append(serviceNameList,serviceName)
+	}
+	sort.Strings(serviceNameList)
+
+	for _,serviceName := range serviceNameList {
+		if len(getSubNetServiceNode(subnet,serviceName)) == 0 {
+			slf.addError("%s ExposeService %s is not in any node of subnet %s",filePath,serviceName,subnet.SubNetName)
+		}
+		for _,subnetName := range subnet.ExposeService[serviceName] {
+			if _,ok := mapSubNetInfo[subnetName];ok == false {
+				slf.addError("%s ExposeService %s to unknown subnet %s",filePath,serviceName,subnetName)
+			}
+		}
+	}
+}
+
+func (slf *cfgChecker) checkServiceCfg(filePath string,subnet SubNet) {
+	c := map[string]interface{}{}
+	err := readCfgFile(filePath,&c)
+	if err != nil {
+		slf.addError("read file %s is error:%+v",filePath,err)
+		return
+	}
+
+	serviceConfig,errList := parseServiceConfig(c)
+	for _,err := range errList {
+		slf.addError("%s %+v",filePath,err)
+	}
+
+	for _,key := range sortedKeys(c) {
+		if key != "Service" && key != "NodeService" && key != "LoadBalance" && key != "CallPolicy" && key != "Queue" {
+			slf.addError("%s unknown key %s",filePath,key)
+		}
+	}
+
+	for _,serviceName := range sortedKeys(serviceConfig.ServiceCfg) {
+		if len(getSubNetServiceNode(subnet,serviceName)) == 0 {
+			slf.addError("%s Service.%s is not in any node of subnet %s",filePath,serviceName,subnet.SubNetName)
+		}
+	}
+
+	nodeIdList := make([]int,0,len(serviceConfig.NodeServiceCfg))
+	for nodeId,_ := range serviceConfig.NodeServiceCfg {
+		nodeIdList = append(nodeIdList,nodeId)
+	}
+	sort.Ints(nodeIdList)
+	for _,nodeId := range nodeIdList {
+		nodeInfo,ok := getSubNetNode(subnet,nodeId)
+		if ok == false {
+			slf.addError("%s NodeService NodeId %d not in subnet %s",filePath,nodeId,subnet.SubNetName)
+			continue
+		}
+		for _,serviceName := range sortedKeys(serviceConfig.NodeServiceCfg[nodeId]) {
+			if serviceName != "NodeId" && hasLocalService(nodeInfo,serviceName) == false {
+				slf.addError("%s NodeService(NodeId=%d).%s is not in ServiceList of the node",filePath,nodeId,serviceName)
+			}
+		}
+	}
+
+	loadBalanceList := make([]string,0,len(serviceConfig.LoadBalance))
+	for serviceName,_ := range serviceConfig.LoadBalance {
+		loadBalanceList = append(loadBalanceList,serviceName)
+	}
+	sort.Strings(loadBalanceList)
+	for _,serviceName := range loadBalanceList {
+		if len(getSubNetServiceNode(subnet,serviceName)) == 0 {
+			slf.addError("%s LoadBalance service %s is not in any node of subnet %s",filePath,serviceName,subnet.SubNetName)
+		}
+	}
+
+	callPolicyList := make([]string,0,len(serviceConfig.CallPolicy))
+	for key,_ := range serviceConfig.CallPolicy {
+		callPolicyList = append(callPolicyList,key)
+	}
+	sort.Strings(callPolicyList)
+	for _,key := range callPolicyList {
+		serviceName := key
+		if idx := strings.Index(key,".");idx>=0 {
+			serviceName = key[:idx]
+			//熔断按服务统计,方法的熔断配置不生效
+			policy,servicePolicy := serviceConfig.CallPolicy[key],serviceConfig.CallPolicy[serviceName]
+			if (servicePolicy == nil && policy.BreakerFailCount != 0) ||
+				(servicePolicy != nil && (servicePolicy.BreakerFailCount != policy.BreakerFailCount || servicePolicy.BreakerOpenTime != policy.BreakerOpenTime)) {
+				slf.addError("%s CallPolicy %s breaker can only be set on service %s",filePath,key,serviceName)
+			}
+		}
+		if len(getSubNetServiceNode(subnet,serviceName)) == 0 {
+			slf.addError("%s CallPolicy service %s is not in any node of subnet %s",filePath,serviceName,subnet.SubNetName)
+		}
+	}
+
+	queueList := make([]string,0,len(serviceConfig.Queue))
+	for serviceName,_ := range serviceConfig.Queue {
+		queueList = append(queueList,serviceName)
+	}
+	sort.Strings(queueList)
+	for _,serviceName := range queueList {
+		//ClusterService在每个结点自动安装
+		if serviceName != ClusterServiceName && len(getSubNetServiceNode(subnet,serviceName)) == 0 {
+			slf.addError("%s Queue service %s is not in any node of subnet %s",filePath,serviceName,subnet.SubNetName)
+		}
+	}
+
+	//记录各服务配置中的监听地址,与NodeService合并后的结果为准
+	for _,nodeInfo := range subnet.NodeList {
+		rpcHost,_,err := net.SplitHostPort(nodeInfo.ListenAddr)
+		if err != nil {
+			continue
+		}
+		rpcHost = normalizeHost(rpcHost)
+
+		for _,s := range nodeInfo.ServiceList {
+			serviceName := strings.TrimPrefix(s,"_")
+			cfg,ok := serviceConfig.NodeServiceCfg[nodeInfo.NodeId][serviceName]
+			if ok == false {
+				cfg = serviceConfig.ServiceCfg[serviceName]
+			}
+			mapCfg,_ := cfg.(map[string]interface{})
+			addr,ok := mapCfg["ListenAddr"].(string)
+			if ok == false {
+				continue
+			}
+
+			desc := fmt.Sprintf("NodeId %d %s",nodeInfo.NodeId,serviceName)
+			host,port,err := net.SplitHostPort(addr)
+			if err != nil {
+				slf.addError("%s %s ListenAddr %q is invalid:%+v",filePath,desc,addr,err)
+				continue
+			}
+			slf.mapHostListen[rpcHost] = append(slf.mapHostListen[rpcHost],listenAddr{desc:desc,host:normalizeHost(host),port:port})
+		}
+	}
+}
+
+//rpc地址host相同的结点视为在同一台机器上,检查端口冲突
+func (slf *cfgChecker) checkListenAddr() {
+	hostList := make([]string,0,len(slf.mapHostListen))
+	for host,_ := range slf.mapHostListen {
+		hostList = append(hostList,host)
+	}
+	sort.Strings(hostList)
+
+	for _,host := range hostList {
+		addrList := slf.mapHostListen[host]
+		for i:=0;i<len(addrList);i++ {
+			for j:=i+1;j<len(addrList);j++ {
+				a,b := addrList[i],addrList[j]
+				if a.port != b.port || (a.host != b.host && isAnyHost(a.host) == false && isAnyHost(b.host) == false) {
+					continue
+				}
+				slf.addError("listen port %s is duplicated between %s(%s) and %s(%s)",a.port,a.desc,net.JoinHostPort(a.host,a.port),b.desc,net.JoinHostPort(b.host,b.port))
+			}
+		}
+	}
+}
+
+//检查json文件中不属于结构体t的字段,字段名与解析时一样不区分大小写
+func (slf *cfgChecker) checkUnknownKeys(filePath string,t reflect.Type) {
+	var v interface{}
+	if readCfgFile(filePath,&v) != nil {
+		return
+	}
+
+	slf.checkValueKeys(filePath,"",v,t)
+}
+
+func (slf *cfgChecker) checkValueKeys(filePath string,path string,v interface{},t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Struct:
+		m,ok := v.(map[string]interface{})
+		if ok == false {
+			return
+		}
+		for _,key := range sortedKeys(m) {
+			//remark为json配置中的备注
+			if strings.EqualFold(key,"remark") {
+				continue
+			}
+			field,ok := findField(t,key)
+			if ok == false {
+				slf.addError("%s unknown key %s",filePath,joinKeyPath(path,key))
+				continue
+			}
+			slf.checkValueKeys(filePath,joinKeyPath(path,key),m[key],field.Type)
+		}
+	case reflect.Slice:
+		list,ok := v.([]interface{})
+		if ok == false {
+			return
+		}
+		for i,elem := range list {
+			slf.checkValueKeys(filePath,fmt.Sprintf("%s[%d]",path,i),elem,t.Elem())
+		}
+	case reflect.Map:
+		m,ok := v.(map[string]interface{})
+		if ok == false {
+			return
+		}
+		for _,key := range sortedKeys(m) {
+			slf.checkValueKeys(filePath,joinKeyPath(path,key),m[key],t.Elem())
+		}
+	}
+}
+
+func findField(t reflect.Type,key string) (reflect.StructField,bool) {
+	for i:=0;i<t.NumField();i++ {
+		if strings.EqualFold(t.Field(i).Name,key) {
+			return t.Field(i),true
+		}
+	}
+
+	return reflect.StructField{},false
+}
+
+func joinKeyPath(path string,key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path+"."+key
+}
+
+func getSubNetNode(subnet SubNet,nodeId int) (NodeInfo,bool) {
+	for _,nodeInfo := range subnet.NodeList {
+		if nodeInfo.NodeId == nodeId {
+			return nodeInfo,true
+		}
+	}
+
+	return NodeInfo{},false
+}
+
+//子网内部署了该服务的结点,包括以_打头只在本结点内可见的服务
+func getSubNetServiceNode(subnet SubNet,serviceName string) []NodeInfo {
+	var nodeList []NodeInfo
+	for _,nodeInfo := range subnet.NodeList {
+		if hasLocalService(nodeInfo,serviceName) == true {
+			nodeList = append(nodeList,nodeInfo)
+		}
+	}
+
+	return nodeList
+}
+
+func hasLocalService(nodeInfo NodeInfo,serviceName string) bool {
+	for _,s := range nodeInfo.ServiceList {
+		if strings.TrimPrefix(s,"_") == serviceName {
+			return true
+		}
+	}
+
+	return false
+}
+
+//监听所有地址的host统一为空
+func normalizeHost(host string) string {
+	switch host {
+	case "localhost":
+		return "127.0.0.1"
+	case "0.0.0.0","::":
+		return ""
+	}
+
+	return host
+}
+
+func isAnyHost(host string) bool {
+	return host == ""
+}
@@ -1,16 +1,24 @@
 package cluster
 
 import (
+	"crypto/tls"
 	"fmt"
+	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/rpc"
 	"github.com/duanhf2012/origin/service"
 	"strings"
+	"sync"
 )
 
 var configdir = "./config/"
 
 type SubNet struct {
 	SubNetName string
+	RegistryNodeId int //注册中心结点,为0时使用静态配置的结点列表
+	ExposeService map[string][]string //对其他子网开放的服务,map[ServiceName]允许访问的子网列表,列表为空时对所有子网开放
+	RpcTLS RpcTLSConfig //结点间rpc连接的TLS配置,为空时使用明文
+	AdminToken string //运维rpc的口令,本子网结点间与运维命令连接时在握手中出示,为空时拒绝所有运维rpc
+	LegacyRpc bool //本子网有不支持握手的旧版本结点时设置,本子网结点间只在对方RpcVersion不小于rpc.RpcVersion时握手,没有握手的调用方按本子网结点处理
 	NodeList []NodeInfo
 }
 
@@ -19,6 +27,10 @@ type NodeInfo struct {
 	ListenAddr string
 	NodeName string
 	ServiceList []string
+	MaxRpcMsgLen uint32 //连接到本结点的rpc消息最大长度,为0时使用rpc.Default_MaxRpcMsgLen
+	Compress string     //rpc参数与返回值的压缩算法:snappy、gzip或zstd,为空时不压缩
+	CompressMinLen int  //超过该长度才压缩,为0时使用rpc.Default_CompressMinLen
+	RpcVersion int      //结点的rpc协议版本,不小于rpc.RpcVersion时连接后握手。本结点自动设置,静态配置的结点为0时按旧版本处理
 }
 
 type NodeRpcInfo struct {
@@ -31,6 +43,7 @@ type NodeRpcInfo struct {
 var cluster Cluster
 
 type Cluster struct {
+	locker sync.RWMutex        //运行中会修改路由表与rpc连接
 	localsubnet SubNet         //本子网
 	mapSubNetInfo map[string] SubNet //子网名称，子网信息
 
@@ -40,10 +53,15 @@ type Cluster struct {
 	localNodeMapService map[string]interface{}    //本Node支持的服务
 	localNodeInfo NodeInfo
 
+	serviceCfgLocker sync.RWMutex
 	localServiceCfg map[string]interface{} //map[servicename]数据
 	localNodeServiceCfg map[int]map[string]interface{}  //map[nodeid]map[servicename]数据
+	serviceCfgFile string //本子网的service.json路径
+	serviceCfgOverride []serviceCfgOverride //启动参数--set覆盖的服务配置
 
 	mapRpc map[int] NodeRpcInfo//nodeid
+	mapSubNetRpc map[int] NodeRpcInfo//其他子网中开放了服务给本子网的结点,Init时连接
+	mapSubNetTLS map[string]*tls.Config //连接各子网结点使用的TLS配置
 
 	rpcServer rpc.Server
 }
@@ -61,24 +79,62 @@ func (slf *Cluster) Init(currentNodeId int) error{
 	}
 
 	slf.rpcServer.Init(slf)
+	slf.rpcServer.SetNodeId(currentNodeId)
+	slf.rpcServer.SetAdminToken(slf.localsubnet.AdminToken)
+	rpc.SetRpcMethodSetFun(getLocalRpcMethodSet)
+	rpc.SetRequestFilter(slf.filterRequest)
+	err = slf.initTLSConfig()
+	if err != nil {
+		return err
+	}
 
 	//2.建议rpc连接
 	slf.mapRpc = map[int] NodeRpcInfo{}
+	slf.mapSubNetRpc = map[int] NodeRpcInfo{}
 	for _,nodeinfo := range slf.localSubNetMapNode {
-		rpcinfo := NodeRpcInfo{}
-		rpcinfo.nodeinfo = nodeinfo
-		rpcinfo.client = &rpc.Client{}
-		if nodeinfo.NodeId == currentNodeId {
-			rpcinfo.client.Connect("")
-		}else{
-			rpcinfo.client.Connect(nodeinfo.ListenAddr)
-		}
-		slf.mapRpc[nodeinfo.NodeId] = rpcinfo
+		slf.mapRpc[nodeinfo.NodeId] = slf.newNodeRpcInfo(slf.localsubnet.SubNetName,nodeinfo)
 	}
+	slf.connectSubNet()
 
 	return nil
 }
 
+func (slf *Cluster) newNodeRpcInfo(subnetName string,nodeInfo NodeInfo) NodeRpcInfo {
+	rpcinfo := NodeRpcInfo{}
+	rpcinfo.nodeinfo = nodeInfo
+	//被调用方需要通过握手知道调用方NodeId,才能检查开放的服务,没有握手的调用方将被拒绝。本子网结点间通过握手出示运维口令,才能同步服务的安装与卸载
+	//开启结点发现时,注册中心与成员结点需要通过握手确认对方
+	localSubNet := subnetName == slf.localsubnet.SubNetName
+	forceHandshake := localSubNet == false || slf.localsubnet.LegacyRpc == false || slf.localsubnet.AdminToken != "" || slf.localsubnet.RegistryNodeId != 0
+	rpcinfo.client = &rpc.Client{NodeId:nodeInfo.NodeId,LocalNodeId:slf.localNodeInfo.NodeId,PeerRpcVersion:nodeInfo.RpcVersion,ForceHandshake:forceHandshake,MaxMsgLen:nodeInfo.MaxRpcMsgLen,CompressType:slf.negotiateCompress(nodeInfo),CompressMinLen:slf.localNodeInfo.CompressMinLen}
+	if localSubNet == true {
+		rpcinfo.client.AdminToken = slf.localsubnet.AdminToken
+	}
+	if nodeInfo.NodeId == slf.localNodeInfo.NodeId {
+		rpcinfo.client.Connect("")
+	}else{
+		rpcinfo.client.TLSConfig = slf.mapSubNetTLS[subnetName]
+		rpcinfo.client.Connect(nodeInfo.ListenAddr)
+	}
+
+	return rpcinfo
+}
+
+//双方都开启压缩时使用被连接结点配置的算法
+func (slf *Cluster) negotiateCompress(nodeInfo NodeInfo) uint32 {
+	if slf.localNodeInfo.Compress == "" || nodeInfo.NodeId == slf.localNodeInfo.NodeId {
+		return rpc.CompressNone
+	}
+
+	compressType,err := rpc.GetCompressType(nodeInfo.Compress)
+	if err != nil {
+		log.Error("node id %d %s",nodeInfo.NodeId,err.Error())
+		return rpc.CompressNone
+	}
+
+	return compressType
+}
+
 func (slf *Cluster) FindRpcHandler(servicename string) rpc.IRpcHandler {
 	pService := service.GetService(servicename)
 	if pService == nil {
@@ -88,8 +144,31 @@ func (slf *Cluster) FindRpcHandler(servicename string) rpc.IRpcHandler {
 	return pService.GetRpcHandler()
 }
 
+//握手时交换的本结点rpc方法
+func getLocalRpcMethodSet() map[string][]string {
+	mapMethodSet := map[string][]string{}
+	for _,serviceDesc := range service.GetRpcServiceDesc("") {
+		methodList := make([]string,0,len(serviceDesc.MethodList))
+		for _,methodDesc := range serviceDesc.MethodList {
+			methodList = append(methodList,methodDesc.Name)
+		}
+		mapMethodSet[serviceDesc.Name] = methodList
+	}
+
+	return mapMethodSet
+}
+
 func (slf *Cluster) Start() {
-	slf.rpcServer.Start(slf.localNodeInfo.ListenAddr)
+	slf.rpcServer.Start(slf.localNodeInfo.ListenAddr,slf.localNodeInfo.MaxRpcMsgLen,slf.localNodeInfo.CompressMinLen)
+}
+
+//停止接受其他结点新的rpc请求
+func (slf *Cluster) StopAccept() {
+	slf.rpcServer.StopAccept()
+}
+
+func (slf *Cluster) Stop() {
+	slf.rpcServer.Stop()
 }
 
 
@@ -98,6 +177,12 @@ func GetCluster() *Cluster{
 }
 
 func (slf *Cluster) GetRpcClient(nodeid int) *rpc.Client {
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
+	return slf.getRpcClient(nodeid)
+}
+
+func (slf *Cluster) getRpcClient(nodeid int) *rpc.Client {
 	c,ok := slf.mapRpc[nodeid]
 	if ok == false {
 		return nil
@@ -107,6 +192,15 @@ func (slf *Cluster) GetRpcClient(nodeid int) *rpc.Client {
 }
 
 func GetRpcClient(nodeId int,serviceMethod string,clientList *[]*rpc.Client) error {
+	//跨子网调用subnet/Service.Method
+	if idx := strings.Index(serviceMethod,"/");idx>=0 {
+		subnetName := serviceMethod[:idx]
+		serviceMethod = serviceMethod[idx+1:]
+		if subnetName != GetCluster().localsubnet.SubNetName {
+			return GetCluster().getSubNetRpcClient(subnetName,nodeId,serviceMethod,clientList)
+		}
+	}
+
 	if nodeId>0 {
 		pClient := GetCluster().GetRpcClient(nodeId)
 		if pClient==nil {
@@ -130,6 +224,79 @@ func GetRpcServer() *rpc.Server{
 	return &cluster.rpcServer
 }
 
+func (slf *Cluster) GetNodeInfo(nodeId int) (NodeInfo,bool) {
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
+	nodeInfo,ok := slf.localSubNetMapNode[nodeId]
+	return nodeInfo,ok
+}
+
+//本结点运行中安装了服务,以_打头的服务只在本结点内可见
+func (slf *Cluster) AddLocalService(serviceName string) {
+	bLocalOnly := strings.Index(serviceName,"_") == 0
+	if bLocalOnly == true {
+		serviceName = serviceName[1:]
+	}
+
+	slf.locker.Lock()
+	slf.localNodeMapService[serviceName] = nil
+	slf.locker.Unlock()
+	if bLocalOnly == true {
+		return
+	}
+
+	slf.addNodeService(slf.localNodeInfo.NodeId,serviceName)
+	slf.broadcastServiceChanged(serviceName,true)
+}
+
+//本结点运行中卸载了服务
+func (slf *Cluster) RemoveLocalService(serviceName string) {
+	slf.locker.Lock()
+	delete(slf.localNodeMapService,serviceName)
+	slf.locker.Unlock()
+
+	slf.removeNodeService(slf.localNodeInfo.NodeId,serviceName)
+	slf.broadcastServiceChanged(serviceName,false)
+}
+
+func (slf *Cluster) addNodeService(nodeId int,serviceName string) {
+	slf.locker.Lock()
+	defer slf.locker.Unlock()
+
+	nodeInfo,ok := slf.localSubNetMapNode[nodeId]
+	if ok == false {
+		log.Error("node id %d is not in subnet %s",nodeId,slf.localsubnet.SubNetName)
+		return
+	}
+
+	for _,n := range slf.localSubNetMapService[serviceName] {
+		if n.NodeId == nodeId {
+			return
+		}
+	}
+	slf.localSubNetMapService[serviceName] = append(slf.localSubNetMapService[serviceName],nodeInfo)
+	log.Release("node id %d install service %s.",nodeId,serviceName)
+}
+
+func (slf *Cluster) removeNodeService(nodeId int,serviceName string) {
+	slf.locker.Lock()
+	defer slf.locker.Unlock()
+
+	nodeInfoList := slf.localSubNetMapService[serviceName]
+	for i,n := range nodeInfoList {
+		if n.NodeId == nodeId {
+			newList := append(nodeInfoList[:i],nodeInfoList[i+1:]...)
+			if len(newList) == 0 {
+				delete(slf.localSubNetMapService,serviceName)
+			}else{
+				slf.localSubNetMapService[serviceName] = newList
+			}
+			log.Release("node id %d uninstall service %s.",nodeId,serviceName)
+			return
+		}
+	}
+}
+
 func (slf *Cluster) IsNodeConnected (nodeId int) bool {
 	pClient := slf.GetRpcClient(nodeId)
 	return pClient!=nil && pClient.IsConnected()
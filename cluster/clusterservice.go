@@ -0,0 +1,195 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/rpc"
+	"github.com/duanhf2012/origin/service"
+	"github.com/golang/protobuf/proto"
+	"time"
+)
+
+//集群内置服务,每个结点自动安装,用于结点间同步集群信息与运维管理
+type ClusterService struct {
+	service.Service
+
+	mapRegNode map[int]time.Time //注册中心记录的结点最后心跳时间
+	registered bool
+	stopping bool
+	mapNodeHealthy map[int]bool //上次检查时各结点的健康状态
+}
+
+type FuncServiceInstaller func(serviceName string) error
+
+var installServiceFun FuncServiceInstaller
+var uninstallServiceFun FuncServiceInstaller
+
+//以下消息同时支持JsonProcessor与PBProcessor
+type ServiceReq struct {
+	ServiceName string `protobuf:"bytes,1,opt,name=ServiceName"`
+}
+
+type ServiceChangedInfo struct {
+	NodeId      int32  `protobuf:"varint,1,opt,name=NodeId"`
+	ServiceName string `protobuf:"bytes,2,opt,name=ServiceName"`
+	Installed   bool   `protobuf:"varint,3,opt,name=Installed"`
+}
+
+type EmptyRet struct {
+}
+
+type RpcInfoReq struct {
+	ServiceName string `protobuf:"bytes,1,opt,name=ServiceName"` //为空时只返回服务名列表,所有服务的描述可能超过消息最大长度
+}
+
+type RpcInfoRet struct {
+	NodeId          int32    `protobuf:"varint,1,opt,name=NodeId"`
+	ServiceList     string   `protobuf:"bytes,2,opt,name=ServiceList"`         //json格式的[]rpc.ServiceDesc
+	ServiceNameList []string `protobuf:"bytes,3,rep,name=ServiceNameList"` //ServiceName为空时返回
+}
+
+func (m *ServiceReq) Reset()         { *m = ServiceReq{} }
+func (m *ServiceReq) String() string { return proto.CompactTextString(m) }
+func (*ServiceReq) ProtoMessage()    {}
+
+func (m *ServiceChangedInfo) Reset()         { *m = ServiceChangedInfo{} }
+func (m *ServiceChangedInfo) String() string { return proto.CompactTextString(m) }
+func (*ServiceChangedInfo) ProtoMessage()    {}
+
+func (m *EmptyRet) Reset()         { *m = EmptyRet{} }
+func (m *EmptyRet) String() string { return proto.CompactTextString(m) }
+func (*EmptyRet) ProtoMessage()    {}
+
+func (m *RpcInfoReq) Reset()         { *m = RpcInfoReq{} }
+func (m *RpcInfoReq) String() string { return proto.CompactTextString(m) }
+func (*RpcInfoReq) ProtoMessage()    {}
+
+func (m *RpcInfoRet) Reset()         { *m = RpcInfoRet{} }
+func (m *RpcInfoRet) String() string { return proto.CompactTextString(m) }
+func (*RpcInfoRet) ProtoMessage()    {}
+
+const ClusterServiceName = "ClusterService"
+
+//只允许握手时出示了AdminToken的调用方调用的运维rpc,RPC_ServiceChanged会修改路由表,只接受本子网结点的通知
+var mapAdminMethod = map[string]bool{
+	ClusterServiceName+".RPC_InstallService":true,
+	ClusterServiceName+".RPC_UninstallService":true,
+	ClusterServiceName+".RPC_GetRpcInfo":true,
+	ClusterServiceName+".RPC_ServiceChanged":true,
+}
+
+//设置运行中安装与卸载服务的函数,由node设置
+func SetServiceInstaller(install FuncServiceInstaller,uninstall FuncServiceInstaller) {
+	installServiceFun = install
+	uninstallServiceFun = uninstall
+}
+
+func NewClusterService() *ClusterService {
+	clusterService := &ClusterService{}
+	clusterService.OnSetup(clusterService)
+	return clusterService
+}
+
+func (slf *ClusterService) OnInit() error {
+	slf.initDiscovery()
+	slf.AfterFunc(Default_PingInterval,slf.checkNodeHealth)
+	return nil
+}
+
+func (slf *ClusterService) OnPreStop() {
+	slf.unregisterNode()
+}
+
+//运维安装服务
+func (slf *ClusterService) RPC_InstallService(req *ServiceReq,ret *EmptyRet) error {
+	if installServiceFun == nil {
+		return fmt.Errorf("install service is not supported")
+	}
+
+	return installServiceFun(req.ServiceName)
+}
+
+//运维卸载服务
+func (slf *ClusterService) RPC_UninstallService(req *ServiceReq,ret *EmptyRet) error {
+	if uninstallServiceFun == nil {
+		return fmt.Errorf("uninstall service is not supported")
+	}
+
+	if req.ServiceName == slf.GetName() {
+		return fmt.Errorf("cannot uninstall %s",slf.GetName())
+	}
+
+	return uninstallServiceFun(req.ServiceName)
+}
+
+//返回本结点服务的rpc方法与参数类型描述,每次只返回一个服务
+func (slf *ClusterService) RPC_GetRpcInfo(req *RpcInfoReq,ret *RpcInfoRet) error {
+	ret.NodeId = int32(GetCluster().localNodeInfo.NodeId)
+	if req.ServiceName == "" {
+		ret.ServiceNameList = service.GetServiceNameList()
+		return nil
+	}
+
+	serviceDescList := service.GetRpcServiceDesc(req.ServiceName)
+	if len(serviceDescList) == 0 {
+		return fmt.Errorf("service %s is not installed",req.ServiceName)
+	}
+
+	serviceList,err := json.Marshal(serviceDescList)
+	if err != nil {
+		return err
+	}
+
+	ret.ServiceList = string(serviceList)
+	return nil
+}
+
+//过滤其他结点的请求,运维rpc只允许握手时出示了AdminToken的调用方调用,结点发现的rpc只允许本子网结点调用,其他子网的结点只能调用开放的服务
+func (slf *Cluster) filterRequest(peerNodeId int,peerAdmin bool,serviceMethod string) error {
+	if mapAdminMethod[serviceMethod] == true {
+		if peerAdmin == false {
+			return fmt.Errorf("node %d is not allowed to call %s,check AdminToken of subnet %s",peerNodeId,serviceMethod,slf.localsubnet.SubNetName)
+		}
+		return nil
+	}
+
+	if mapRegistryMethod[serviceMethod] == true || mapMemberMethod[serviceMethod] == true {
+		return slf.checkDiscoveryRequest(peerNodeId,peerAdmin,serviceMethod)
+	}
+
+	return slf.checkSubNetRequest(peerNodeId,serviceMethod)
+}
+
+//其他结点安装或卸载了服务
+func (slf *ClusterService) RPC_ServiceChanged(info *ServiceChangedInfo,ret *EmptyRet) error {
+	if info.Installed == true {
+		GetCluster().addNodeService(int(info.NodeId),info.ServiceName)
+	}else{
+		GetCluster().removeNodeService(int(info.NodeId),info.ServiceName)
+	}
+
+	return nil
+}
+
+//通知子网内其他结点本结点安装或卸载了服务
+func (slf *Cluster) broadcastServiceChanged(serviceName string,installed bool) {
+	info := ServiceChangedInfo{NodeId:int32(slf.localNodeInfo.NodeId),ServiceName:serviceName,Installed:installed}
+
+	slf.locker.RLock()
+	var clientList []*rpc.Client
+	for nodeId,rpcInfo := range slf.mapRpc {
+		if nodeId == slf.localNodeInfo.NodeId {
+			continue
+		}
+		clientList = append(clientList,rpcInfo.client)
+	}
+	slf.locker.RUnlock()
+
+	for _,pClient := range clientList {
+		pCall := pClient.Go(true,ClusterServiceName+".RPC_ServiceChanged",&info,nil)
+		if pCall.Err != nil {
+			log.Error("notify node %s service %s changed is fail:%+v",pClient.Addr,serviceName,pCall.Err)
+		}
+		rpc.ReleaseCall(pCall)
+	}
+}
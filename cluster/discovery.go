@@ -0,0 +1,387 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/event"
+	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/rpc"
+	"github.com/duanhf2012/origin/service"
+	"github.com/golang/protobuf/proto"
+	"sort"
+	"strings"
+	"time"
+)
+
+//cluster.json中配置RegistryNodeId后开启结点发现:
+//1.各结点启动后向注册中心结点注册,并获得已注册的结点列表
+//2.各结点定时向注册中心发送心跳,超时的结点将被移除
+//3.注册中心将结点的加入与离开通知到所有已注册结点,各结点更新路由表并产生NodeJoined/NodeLeft事件
+var Default_HeartbeatInterval = 3*time.Second
+var Default_HeartbeatTimeout = 10*time.Second
+
+//注册中心处理的结点发现rpc
+var mapRegistryMethod = map[string]bool{
+	ClusterServiceName+".RPC_RegisterNode":true,
+	ClusterServiceName+".RPC_Heartbeat":true,
+	ClusterServiceName+".RPC_UnregisterNode":true,
+}
+
+//注册中心通知成员结点的rpc,只接受注册中心调用
+var mapMemberMethod = map[string]bool{
+	ClusterServiceName+".RPC_NodeJoined":true,
+	ClusterServiceName+".RPC_NodeLeft":true,
+}
+
+type NodeInfoMsg struct {
+	NodeId      int32    `protobuf:"varint,1,opt,name=NodeId"`
+	ListenAddr  string   `protobuf:"bytes,2,opt,name=ListenAddr"`
+	NodeName    string   `protobuf:"bytes,3,opt,name=NodeName"`
+	ServiceList []string `protobuf:"bytes,4,rep,name=ServiceList"`
+	MaxRpcMsgLen uint32  `protobuf:"varint,5,opt,name=MaxRpcMsgLen"`
+	Compress    string   `protobuf:"bytes,6,opt,name=Compress"`
+	RpcVersion  int32    `protobuf:"varint,7,opt,name=RpcVersion"`
+}
+
+type NodeReq struct {
+	NodeId int32 `protobuf:"varint,1,opt,name=NodeId"`
+}
+
+type NodeListRet struct {
+	NodeList []*NodeInfoMsg `protobuf:"bytes,1,rep,name=NodeList"`
+}
+
+func (m *NodeInfoMsg) Reset()         { *m = NodeInfoMsg{} }
+func (m *NodeInfoMsg) String() string { return proto.CompactTextString(m) }
+func (*NodeInfoMsg) ProtoMessage()    {}
+
+func (m *NodeReq) Reset()         { *m = NodeReq{} }
+func (m *NodeReq) String() string { return proto.CompactTextString(m) }
+func (*NodeReq) ProtoMessage()    {}
+
+func (m *NodeListRet) Reset()         { *m = NodeListRet{} }
+func (m *NodeListRet) String() string { return proto.CompactTextString(m) }
+func (*NodeListRet) ProtoMessage()    {}
+
+func (m *NodeInfoMsg) toNodeInfo() NodeInfo {
+	return NodeInfo{NodeId:int(m.NodeId),ListenAddr:m.ListenAddr,NodeName:m.NodeName,ServiceList:m.ServiceList,MaxRpcMsgLen:m.MaxRpcMsgLen,Compress:m.Compress,RpcVersion:int(m.RpcVersion)}
+}
+
+//获取集群内置服务,可用于监听结点事件:
+//cluster.GetClusterService().RegEventReciverFunc(event.Sys_Event_NodeJoined,slf.GetEventHandler(),slf.OnNodeJoined)
+//事件的Data为NodeInfo
+func GetClusterService() *ClusterService {
+	s := service.GetService(ClusterServiceName)
+	if s == nil {
+		return nil
+	}
+
+	return s.(*ClusterService)
+}
+
+func (slf *Cluster) GetRegistryNodeId() int {
+	return slf.localsubnet.RegistryNodeId
+}
+
+//结点发现的rpc只接受本子网结点的调用:配置了AdminToken时调用方需要在握手中出示口令,否则需要在本结点的静态配置中
+//结点加入与离开的通知只接受注册中心发出的
+func (slf *Cluster) checkDiscoveryRequest(peerNodeId int,peerAdmin bool,serviceMethod string) error {
+	if slf.localsubnet.RegistryNodeId == 0 {
+		return fmt.Errorf("subnet %s has no RegistryNodeId,cannot call %s",slf.localsubnet.SubNetName,serviceMethod)
+	}
+	if peerNodeId <= 0 {
+		return fmt.Errorf("node without handshake is not allowed to call %s",serviceMethod)
+	}
+	if mapMemberMethod[serviceMethod] == true && peerNodeId != slf.localsubnet.RegistryNodeId {
+		return fmt.Errorf("node %d is not registry,cannot call %s",peerNodeId,serviceMethod)
+	}
+
+	if slf.localsubnet.AdminToken != "" {
+		if peerAdmin == false {
+			return fmt.Errorf("node %d is not allowed to call %s,check AdminToken of subnet %s",peerNodeId,serviceMethod,slf.localsubnet.SubNetName)
+		}
+		return nil
+	}
+
+	if _,ok := slf.mapSubNetNodeInfo[slf.localsubnet.SubNetName][peerNodeId];ok == false {
+		return fmt.Errorf("node %d is not configured in subnet %s,cannot call %s",peerNodeId,slf.localsubnet.SubNetName,serviceMethod)
+	}
+	return nil
+}
+
+//加入或更新结点,返回是否为新加入的结点
+func (slf *Cluster) addNode(nodeInfo NodeInfo) bool {
+	if nodeInfo.NodeId == slf.localNodeInfo.NodeId {
+		return false
+	}
+
+	slf.locker.Lock()
+	var oldClient *rpc.Client
+	rpcInfo,ok := slf.mapRpc[nodeInfo.NodeId]
+	if ok == false || rpcInfo.nodeinfo.ListenAddr != nodeInfo.ListenAddr || rpcInfo.nodeinfo.MaxRpcMsgLen != nodeInfo.MaxRpcMsgLen || rpcInfo.nodeinfo.Compress != nodeInfo.Compress || rpcInfo.nodeinfo.RpcVersion != nodeInfo.RpcVersion {
+		if ok == true {
+			oldClient = rpcInfo.client
+		}
+		rpcInfo = slf.newNodeRpcInfo(slf.localsubnet.SubNetName,nodeInfo)
+	}
+	rpcInfo.nodeinfo = nodeInfo
+	slf.mapRpc[nodeInfo.NodeId] = rpcInfo
+	slf.localSubNetMapNode[nodeInfo.NodeId] = nodeInfo
+
+	//以结点上报的服务列表为准
+	slf.removeAllNodeService(nodeInfo.NodeId)
+	for _,s := range nodeInfo.ServiceList {
+		if strings.Index(s,"_") == 0 {
+			continue
+		}
+		slf.localSubNetMapService[s] = append(slf.localSubNetMapService[s],nodeInfo)
+	}
+	slf.locker.Unlock()
+
+	if oldClient != nil {
+		go oldClient.Close()
+	}
+	if ok == false {
+		log.Release("node id %d %s joined.",nodeInfo.NodeId,nodeInfo.ListenAddr)
+	}
+	return ok == false
+}
+
+//移除结点,返回被移除的结点信息
+func (slf *Cluster) removeNode(nodeId int) (NodeInfo,bool) {
+	if nodeId == slf.localNodeInfo.NodeId {
+		return NodeInfo{},false
+	}
+
+	slf.locker.Lock()
+	rpcInfo,ok := slf.mapRpc[nodeId]
+	if ok == false {
+		slf.locker.Unlock()
+		return NodeInfo{},false
+	}
+	delete(slf.mapRpc,nodeId)
+	delete(slf.localSubNetMapNode,nodeId)
+	slf.removeAllNodeService(nodeId)
+	slf.locker.Unlock()
+
+	//连接可能正在重连中,不阻塞调用者
+	go rpcInfo.client.Close()
+	log.Release("node id %d %s left.",nodeId,rpcInfo.nodeinfo.ListenAddr)
+	return rpcInfo.nodeinfo,true
+}
+
+func (slf *Cluster) removeAllNodeService(nodeId int) {
+	for serviceName,nodeInfoList := range slf.localSubNetMapService {
+		for i,n := range nodeInfoList {
+			if n.NodeId != nodeId {
+				continue
+			}
+			newList := append(nodeInfoList[:i],nodeInfoList[i+1:]...)
+			if len(newList) == 0 {
+				delete(slf.localSubNetMapService,serviceName)
+			}else{
+				slf.localSubNetMapService[serviceName] = newList
+			}
+			break
+		}
+	}
+}
+
+//结点信息,服务列表为结点当前对子网开放的服务
+func (slf *Cluster) getNodeInfoMsg(nodeId int) *NodeInfoMsg {
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
+
+	nodeInfo,ok := slf.localSubNetMapNode[nodeId]
+	if ok == false {
+		return nil
+	}
+
+	msg := &NodeInfoMsg{NodeId:int32(nodeInfo.NodeId),ListenAddr:nodeInfo.ListenAddr,NodeName:nodeInfo.NodeName,MaxRpcMsgLen:nodeInfo.MaxRpcMsgLen,Compress:nodeInfo.Compress,RpcVersion:int32(nodeInfo.RpcVersion)}
+	for serviceName,nodeInfoList := range slf.localSubNetMapService {
+		for _,n := range nodeInfoList {
+			if n.NodeId == nodeId {
+				msg.ServiceList = append(msg.ServiceList,serviceName)
+				break
+			}
+		}
+	}
+	sort.Strings(msg.ServiceList)
+
+	return msg
+}
+
+func (slf *ClusterService) initDiscovery() {
+	registryNodeId := GetCluster().GetRegistryNodeId()
+	if registryNodeId == 0 {
+		return
+	}
+
+	if registryNodeId == GetCluster().localNodeInfo.NodeId {
+		slf.mapRegNode = map[int]time.Time{}
+		slf.AfterFunc(Default_HeartbeatInterval,slf.checkHeartbeat)
+		return
+	}
+
+	slf.AfterFunc(0,slf.registerNode)
+}
+
+func (slf *ClusterService) addNode(nodeInfo NodeInfo) {
+	if GetCluster().addNode(nodeInfo) == true {
+		slf.NotifyEvent(&event.Event{Type:event.Sys_Event_NodeJoined,Data:nodeInfo})
+	}
+}
+
+func (slf *ClusterService) removeNode(nodeId int) {
+	nodeInfo,ok := GetCluster().removeNode(nodeId)
+	if ok == true {
+		slf.NotifyEvent(&event.Event{Type:event.Sys_Event_NodeLeft,Data:nodeInfo})
+	}
+}
+
+//向注册中心注册本结点,失败时定时重试
+func (slf *ClusterService) registerNode() {
+	if slf.stopping == true {
+		return
+	}
+
+	//等待与注册中心的连接建立
+	if GetCluster().IsNodeConnected(GetCluster().GetRegistryNodeId()) == false {
+		slf.AfterFunc(time.Second,slf.registerNode)
+		return
+	}
+
+	localNodeId := GetCluster().localNodeInfo.NodeId
+	slf.AsyncCallNode(GetCluster().GetRegistryNodeId(),ClusterServiceName+".RPC_RegisterNode",GetCluster().getNodeInfoMsg(localNodeId),func(ret *NodeListRet,err error){
+		if err != nil {
+			log.Error("register node %d to registry is fail:%+v",localNodeId,err)
+			slf.AfterFunc(Default_HeartbeatInterval,slf.registerNode)
+			return
+		}
+
+		for _,n := range ret.NodeList {
+			slf.addNode(n.toNodeInfo())
+		}
+		slf.registered = true
+		log.Release("register node %d to registry is successful.",localNodeId)
+		slf.AfterFunc(Default_HeartbeatInterval,slf.heartbeat)
+	})
+}
+
+//心跳失败时重新注册,注册中心重启或已将本结点超时移除时可恢复
+func (slf *ClusterService) heartbeat() {
+	if slf.stopping == true {
+		return
+	}
+
+	slf.AsyncCallNode(GetCluster().GetRegistryNodeId(),ClusterServiceName+".RPC_Heartbeat",&NodeReq{NodeId:int32(GetCluster().localNodeInfo.NodeId)},func(ret *EmptyRet,err error){
+		if err != nil {
+			log.Error("heartbeat to registry is fail:%+v",err)
+			slf.registered = false
+			slf.registerNode()
+			return
+		}
+
+		slf.AfterFunc(Default_HeartbeatInterval,slf.heartbeat)
+	})
+}
+
+//结点退出时主动注销
+func (slf *ClusterService) unregisterNode() {
+	slf.stopping = true
+	if slf.registered == false {
+		return
+	}
+
+	err := slf.GoNode(GetCluster().GetRegistryNodeId(),ClusterServiceName+".RPC_UnregisterNode",&NodeReq{NodeId:int32(GetCluster().localNodeInfo.NodeId)})
+	if err != nil {
+		log.Error("unregister node from registry is fail:%+v",err)
+	}
+}
+
+//注册中心检查心跳超时的结点
+func (slf *ClusterService) checkHeartbeat() {
+	for nodeId,lastTime := range slf.mapRegNode {
+		if time.Since(lastTime) > Default_HeartbeatTimeout {
+			log.Error("node id %d heartbeat is timeout.",nodeId)
+			slf.removeRegNode(nodeId)
+		}
+	}
+
+	slf.AfterFunc(Default_HeartbeatInterval,slf.checkHeartbeat)
+}
+
+func (slf *ClusterService) removeRegNode(nodeId int) {
+	delete(slf.mapRegNode,nodeId)
+	slf.removeNode(nodeId)
+	slf.broadcastToRegNode(nodeId,ClusterServiceName+".RPC_NodeLeft",&NodeReq{NodeId:int32(nodeId)})
+}
+
+func (slf *ClusterService) broadcastToRegNode(exceptNodeId int,serviceMethod string,args interface{}) {
+	for nodeId,_ := range slf.mapRegNode {
+		if nodeId == exceptNodeId {
+			continue
+		}
+
+		err := slf.GoNode(nodeId,serviceMethod,args)
+		if err != nil {
+			log.Error("notify node %d %s is fail:%+v",nodeId,serviceMethod,err)
+		}
+	}
+}
+
+//注册中心:结点注册,返回已注册的结点列表
+func (slf *ClusterService) RPC_RegisterNode(info *NodeInfoMsg,ret *NodeListRet) error {
+	if slf.mapRegNode == nil {
+		return fmt.Errorf("node %d is not registry",GetCluster().localNodeInfo.NodeId)
+	}
+
+	nodeId := int(info.NodeId)
+	slf.mapRegNode[nodeId] = time.Now()
+	slf.addNode(info.toNodeInfo())
+	slf.broadcastToRegNode(nodeId,ClusterServiceName+".RPC_NodeJoined",info)
+
+	ret.NodeList = append(ret.NodeList,GetCluster().getNodeInfoMsg(GetCluster().localNodeInfo.NodeId))
+	for regNodeId,_ := range slf.mapRegNode {
+		if regNodeId == nodeId {
+			continue
+		}
+		if msg := GetCluster().getNodeInfoMsg(regNodeId);msg != nil {
+			ret.NodeList = append(ret.NodeList,msg)
+		}
+	}
+
+	return nil
+}
+
+//注册中心:结点心跳
+func (slf *ClusterService) RPC_Heartbeat(req *NodeReq,ret *EmptyRet) error {
+	nodeId := int(req.NodeId)
+	if _,ok := slf.mapRegNode[nodeId];ok == false {
+		return fmt.Errorf("node %d is not registered",nodeId)
+	}
+
+	slf.mapRegNode[nodeId] = time.Now()
+	return nil
+}
+
+//注册中心:结点注销
+func (slf *ClusterService) RPC_UnregisterNode(req *NodeReq,ret *EmptyRet) error {
+	if _,ok := slf.mapRegNode[int(req.NodeId)];ok == false {
+		return nil
+	}
+
+	slf.removeRegNode(int(req.NodeId))
+	return nil
+}
+
+//注册中心通知:结点加入
+func (slf *ClusterService) RPC_NodeJoined(info *NodeInfoMsg,ret *EmptyRet) error {
+	slf.addNode(info.toNodeInfo())
+	return nil
+}
+
+//注册中心通知:结点离开
+func (slf *ClusterService) RPC_NodeLeft(req *NodeReq,ret *EmptyRet) error {
+	slf.removeNode(int(req.NodeId))
+	return nil
+}
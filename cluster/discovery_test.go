@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"github.com/duanhf2012/origin/event"
+	"testing"
+	"time"
+)
+
+func TestFilterDiscoveryRequest(t *testing.T) {
+	c := &Cluster{
+		localsubnet:SubNet{SubNetName:"game",RegistryNodeId:1},
+		mapSubNetNodeInfo:map[string]map[int]NodeInfo{
+			"game":{1:NodeInfo{NodeId:1},2:NodeInfo{NodeId:2}},
+			"login":{5:NodeInfo{NodeId:5}},
+		},
+	}
+
+	testList := []struct{
+		adminToken string
+		peerNodeId int
+		peerAdmin bool
+		serviceMethod string
+		allowed bool
+	}{
+		{"",2,false,ClusterServiceName+".RPC_RegisterNode",true},
+		{"",0,false,ClusterServiceName+".RPC_RegisterNode",false},  //没有握手的调用方
+		{"",3,false,ClusterServiceName+".RPC_RegisterNode",false},  //不在静态配置中的结点
+		{"",5,false,ClusterServiceName+".RPC_Heartbeat",false},     //其他子网的结点
+		{"",2,false,ClusterServiceName+".RPC_UnregisterNode",true},
+		{"",1,false,ClusterServiceName+".RPC_NodeJoined",true},     //注册中心的通知
+		{"",2,false,ClusterServiceName+".RPC_NodeLeft",false},
+		{"secret",3,true,ClusterServiceName+".RPC_RegisterNode",true}, //出示口令的新结点
+		{"secret",2,false,ClusterServiceName+".RPC_Heartbeat",false},  //配置口令后NodeId不能代替口令
+		{"secret",1,true,ClusterServiceName+".RPC_NodeLeft",true},
+		{"secret",3,true,ClusterServiceName+".RPC_NodeLeft",false},
+	}
+
+	for _,test := range testList {
+		c.localsubnet.AdminToken = test.adminToken
+		err := c.filterRequest(test.peerNodeId,test.peerAdmin,test.serviceMethod)
+		if (err == nil) != test.allowed {
+			t.Fatalf("AdminToken %q node %d call %s,expect allowed %t,error:%+v",test.adminToken,test.peerNodeId,test.serviceMethod,test.allowed,err)
+		}
+	}
+
+	//未开启结点发现时不接受任何调用
+	c.localsubnet = SubNet{SubNetName:"game"}
+	if err := c.filterRequest(2,false,ClusterServiceName+".RPC_RegisterNode");err == nil {
+		t.Fatal("expect RPC_RegisterNode is denied without RegistryNodeId")
+	}
+}
+
+//以nodeId作为本结点初始化集群与ClusterService,结点的连接地址不可达,通知其他结点的rpc会失败
+func newTestDiscoveryService(nodeId int) *ClusterService {
+	cluster = Cluster{
+		localsubnet:SubNet{SubNetName:"game",RegistryNodeId:1},
+		localNodeInfo:NodeInfo{NodeId:nodeId},
+		mapRpc:map[int]NodeRpcInfo{},
+		localSubNetMapNode:map[int]NodeInfo{nodeId:{NodeId:nodeId}},
+		localSubNetMapService:map[string][]NodeInfo{},
+	}
+
+	clusterService := NewClusterService()
+	clusterService.Init(clusterService,GetRpcClient,GetRpcServer,nil)
+	if nodeId == 1 {
+		clusterService.mapRegNode = map[int]time.Time{}
+	}
+	return clusterService
+}
+
+func closeTestDiscoveryService() {
+	for _,rpcInfo := range cluster.mapRpc {
+		rpcInfo.client.Close()
+	}
+}
+
+//返回已产生的结点事件类型,默认监听结点加入与离开
+func listenNodeEvent(clusterService *ClusterService,eventTypeList ...event.EventType) func() []event.EventType {
+	if len(eventTypeList) == 0 {
+		eventTypeList = []event.EventType{event.Sys_Event_NodeJoined,event.Sys_Event_NodeLeft}
+	}
+	callback := func(ev *event.Event){}
+	for _,eventType := range eventTypeList {
+		clusterService.RegEventReciverFunc(eventType,clusterService.GetEventHandler(),callback)
+	}
+	eventChan := clusterService.GetEventProcessor().(*event.EventProcessor).GetEventChan()
+
+	return func() []event.EventType {
+		var eventList []event.EventType
+		for {
+			select {
+			case ev := <-eventChan:
+				eventList = append(eventList,ev.Type)
+			default:
+				return eventList
+			}
+		}
+	}
+}
+
+func serviceNodeIdList(serviceName string) []int {
+	GetCluster().locker.RLock()
+	defer GetCluster().locker.RUnlock()
+	var nodeIdList []int
+	for _,nodeInfo := range GetCluster().localSubNetMapService[serviceName] {
+		nodeIdList = append(nodeIdList,nodeInfo.NodeId)
+	}
+	return nodeIdList
+}
+
+func TestRegistryJoinAndLeave(t *testing.T) {
+	clusterService := newTestDiscoveryService(1)
+	defer closeTestDiscoveryService()
+	takeEvent := listenNodeEvent(clusterService)
+
+	var ret NodeListRet
+	err := clusterService.RPC_RegisterNode(&NodeInfoMsg{NodeId:2,ListenAddr:"127.0.0.1:1",ServiceList:[]string{"GameService"}},&ret)
+	if err != nil {
+		t.Fatalf("register node error:%+v",err)
+	}
+	if len(ret.NodeList) != 1 || ret.NodeList[0].NodeId != 1 {
+		t.Fatalf("expect node list with registry,but %+v",ret.NodeList)
+	}
+	if _,ok := GetCluster().GetNodeInfo(2);ok == false {
+		t.Fatal("node 2 is not added")
+	}
+	if eventList := takeEvent();len(eventList) != 1 || eventList[0] != event.Sys_Event_NodeJoined {
+		t.Fatalf("expect NodeJoined,but %+v",eventList)
+	}
+
+	//第二个结点注册时获得已注册的结点
+	ret = NodeListRet{}
+	err = clusterService.RPC_RegisterNode(&NodeInfoMsg{NodeId:3,ListenAddr:"127.0.0.1:2"},&ret)
+	if err != nil || len(ret.NodeList) != 2 {
+		t.Fatalf("expect node list with registry and node 2,but %+v,error:%+v",ret.NodeList,err)
+	}
+	takeEvent()
+
+	//重复注册只更新结点信息
+	err = clusterService.RPC_RegisterNode(&NodeInfoMsg{NodeId:2,ListenAddr:"127.0.0.1:1"},&NodeListRet{})
+	if err != nil {
+		t.Fatalf("register node again error:%+v",err)
+	}
+	if eventList := takeEvent();len(eventList) != 0 {
+		t.Fatalf("expect no event,but %+v",eventList)
+	}
+	if len(serviceNodeIdList("GameService")) != 0 {
+		t.Fatal("service list of node 2 is not updated")
+	}
+
+	if err = clusterService.RPC_Heartbeat(&NodeReq{NodeId:2},&EmptyRet{});err != nil {
+		t.Fatalf("heartbeat error:%+v",err)
+	}
+	if err = clusterService.RPC_Heartbeat(&NodeReq{NodeId:4},&EmptyRet{});err == nil {
+		t.Fatal("expect heartbeat of unregistered node is fail")
+	}
+
+	clusterService.RPC_UnregisterNode(&NodeReq{NodeId:2},&EmptyRet{})
+	if _,ok := GetCluster().GetNodeInfo(2);ok == true {
+		t.Fatal("node 2 is not removed")
+	}
+	if _,ok := clusterService.mapRegNode[2];ok == true {
+		t.Fatal("node 2 is still registered")
+	}
+	if eventList := takeEvent();len(eventList) != 1 || eventList[0] != event.Sys_Event_NodeLeft {
+		t.Fatalf("expect NodeLeft,but %+v",eventList)
+	}
+}
+
+func TestRegistryHeartbeatTimeout(t *testing.T) {
+	clusterService := newTestDiscoveryService(1)
+	defer closeTestDiscoveryService()
+	takeEvent := listenNodeEvent(clusterService)
+
+	clusterService.RPC_RegisterNode(&NodeInfoMsg{NodeId:2,ListenAddr:"127.0.0.1:1"},&NodeListRet{})
+	clusterService.RPC_RegisterNode(&NodeInfoMsg{NodeId:3,ListenAddr:"127.0.0.1:2"},&NodeListRet{})
+	takeEvent()
+
+	clusterService.mapRegNode[2] = time.Now().Add(-Default_HeartbeatTimeout-time.Second)
+	clusterService.checkHeartbeat()
+
+	if _,ok := clusterService.mapRegNode[2];ok == true {
+		t.Fatal("node 2 is not removed after heartbeat timeout")
+	}
+	if _,ok := GetCluster().GetNodeInfo(2);ok == true {
+		t.Fatal("node 2 is still in cluster after heartbeat timeout")
+	}
+	if _,ok := GetCluster().GetNodeInfo(3);ok == false {
+		t.Fatal("node 3 is removed before heartbeat timeout")
+	}
+	if eventList := takeEvent();len(eventList) != 1 || eventList[0] != event.Sys_Event_NodeLeft {
+		t.Fatalf("expect NodeLeft,but %+v",eventList)
+	}
+}
+
+func TestMemberNodeJoinedAndLeft(t *testing.T) {
+	clusterService := newTestDiscoveryService(2)
+	defer closeTestDiscoveryService()
+	takeEvent := listenNodeEvent(clusterService)
+
+	clusterService.RPC_NodeJoined(&NodeInfoMsg{NodeId:3,ListenAddr:"127.0.0.1:2",ServiceList:[]string{"GameService"}},&EmptyRet{})
+	if nodeIdList := serviceNodeIdList("GameService");len(nodeIdList) != 1 || nodeIdList[0] != 3 {
+		t.Fatalf("expect GameService on node 3,but %+v",nodeIdList)
+	}
+
+	clusterService.RPC_NodeLeft(&NodeReq{NodeId:3},&EmptyRet{})
+	clusterService.RPC_NodeLeft(&NodeReq{NodeId:3},&EmptyRet{})
+	if len(serviceNodeIdList("GameService")) != 0 {
+		t.Fatal("GameService of node 3 is not removed")
+	}
+
+	eventList := takeEvent()
+	if len(eventList) != 2 || eventList[0] != event.Sys_Event_NodeJoined || eventList[1] != event.Sys_Event_NodeLeft {
+		t.Fatalf("expect NodeJoined and NodeLeft,but %+v",eventList)
+	}
+}
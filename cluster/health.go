@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"github.com/duanhf2012/origin/event"
+	"github.com/duanhf2012/origin/log"
+	"time"
+)
+
+//ClusterService定时ping本子网内其他结点,记录往返时间,并在结点健康状态变化时
+//产生Sys_Event_NodeDown/Sys_Event_NodeUp事件,事件的Data为NodeHealth
+var Default_PingInterval = 3*time.Second
+
+type NodeHealth struct {
+	NodeId int
+	Connected bool
+	FailCount int32       //连续超时的调用数量
+	Rtt time.Duration     //最近一次ping的往返时间
+	Healthy bool          //不健康的结点在按服务路由时将被跳过
+}
+
+func (slf *Cluster) GetNodeHealth(nodeId int) (NodeHealth,bool) {
+	pClient := slf.GetRpcClient(nodeId)
+	if pClient == nil {
+		return NodeHealth{},false
+	}
+
+	return NodeHealth{NodeId:nodeId,Connected:pClient.IsConnected(),FailCount:pClient.GetFailCount(),Rtt:pClient.GetRtt(),Healthy:pClient.IsHealthy()},true
+}
+
+//本子网内除本结点外所有结点的健康状态
+func (slf *Cluster) GetNodeHealthList() []NodeHealth {
+	slf.locker.RLock()
+	nodeIdList := make([]int,0,len(slf.mapRpc))
+	for nodeId,_ := range slf.mapRpc {
+		if nodeId != slf.localNodeInfo.NodeId {
+			nodeIdList = append(nodeIdList,nodeId)
+		}
+	}
+	slf.locker.RUnlock()
+
+	healthList := make([]NodeHealth,0,len(nodeIdList))
+	for _,nodeId := range nodeIdList {
+		if health,ok := slf.GetNodeHealth(nodeId);ok == true {
+			healthList = append(healthList,health)
+		}
+	}
+
+	return healthList
+}
+
+func (slf *ClusterService) checkNodeHealth() {
+	if slf.stopping == true {
+		return
+	}
+
+	mapNodeHealthy := make(map[int]bool,len(slf.mapNodeHealthy))
+	for _,health := range GetCluster().GetNodeHealthList() {
+		mapNodeHealthy[health.NodeId] = health.Healthy
+		if health.Connected == true {
+			slf.ping(health.NodeId)
+		}
+
+		//首次检查的结点只记录状态
+		healthy,ok := slf.mapNodeHealthy[health.NodeId]
+		if ok == false || healthy == health.Healthy {
+			continue
+		}
+
+		if health.Healthy == true {
+			log.Release("node id %d is up.",health.NodeId)
+			slf.NotifyEvent(&event.Event{Type:event.Sys_Event_NodeUp,Data:health})
+		}else{
+			log.Error("node id %d is down,connected %t,fail count %d.",health.NodeId,health.Connected,health.FailCount)
+			slf.NotifyEvent(&event.Event{Type:event.Sys_Event_NodeDown,Data:health})
+		}
+	}
+	slf.mapNodeHealthy = mapNodeHealthy
+
+	slf.AfterFunc(Default_PingInterval,slf.checkNodeHealth)
+}
+
+func (slf *ClusterService) ping(nodeId int) {
+	start := time.Now()
+	slf.AsyncCallNode(nodeId,ClusterServiceName+".RPC_Ping",&NodeReq{NodeId:int32(GetCluster().localNodeInfo.NodeId)},func(ret *EmptyRet,err error){
+		if err != nil {
+			return
+		}
+
+		if pClient := GetCluster().GetRpcClient(nodeId);pClient != nil {
+			pClient.SetRtt(time.Since(start))
+		}
+	})
+}
+
+func (slf *ClusterService) RPC_Ping(req *NodeReq,ret *EmptyRet) error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package cluster
+
+import (
+	"github.com/duanhf2012/origin/event"
+	"github.com/duanhf2012/origin/rpc"
+	"net"
+	"testing"
+	"time"
+)
+
+//收到的请求只有在serve后才处理,之前的调用都会超时
+type testSilentHandler struct {
+	rpc.RpcHandler
+}
+
+func (slf *testSilentHandler) GetName() string {
+	return "TestSilentService"
+}
+
+func (slf *testSilentHandler) RPC_Echo(req *string,ret *string) error {
+	*ret = *req
+	return nil
+}
+
+func (slf *testSilentHandler) FindRpcHandler(serviceName string) rpc.IRpcHandler {
+	return slf
+}
+
+func (slf *testSilentHandler) serve() {
+	go func() {
+		for req := range slf.GetRpcRequestChan() {
+			slf.HandlerRpcRequest(req)
+		}
+	}()
+}
+
+func startTestSilentServer(t *testing.T) (*testSilentHandler,*rpc.Server,string) {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	rpcHandler := &testSilentHandler{}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	server := &rpc.Server{}
+	server.Init(rpcHandler)
+	server.SetNodeId(2)
+	server.Start(addr,0,0)
+	return rpcHandler,server,addr
+}
+
+func callTestSilentNode(pClient *rpc.Client) error {
+	arg := "ping"
+	var reply string
+	pCall := pClient.Go(false,"TestSilentService.RPC_Echo",&arg,&reply).Done()
+	err := pCall.Err
+	rpc.ReleaseCall(pCall)
+	return err
+}
+
+//结点健康状态:首次检查只记录,连续超时达到Default_UnhealthyFailCount时NodeDown,恢复后NodeUp
+func TestNodeHealthUpDownUp(t *testing.T) {
+	oldCallTimeout := rpc.Default_CallTimeout
+	rpc.Default_CallTimeout = 100*time.Millisecond
+	defer func() { rpc.Default_CallTimeout = oldCallTimeout }()
+
+	rpcHandler,server,addr := startTestSilentServer(t)
+	defer server.Stop()
+	clusterService := newTestDiscoveryService(1)
+	defer closeTestDiscoveryService()
+	takeEvent := listenNodeEvent(clusterService,event.Sys_Event_NodeUp,event.Sys_Event_NodeDown)
+
+	nodeInfo := NodeInfo{NodeId:2,ListenAddr:addr}
+	GetCluster().mapRpc[2] = GetCluster().newNodeRpcInfo("game",nodeInfo)
+	pClient := GetCluster().GetRpcClient(2)
+	for i:=0;i<100 && pClient.IsConnected() == false;i++ {
+		time.Sleep(10*time.Millisecond)
+	}
+	if pClient.IsHealthy() == false {
+		t.Fatal("node 2 must be healthy after connected")
+	}
+
+	clusterService.checkNodeHealth()
+	if eventList := takeEvent();len(eventList) != 0 {
+		t.Fatalf("first check must not notify,but %+v",eventList)
+	}
+
+	//未达到阈值时仍然健康
+	for i:=int32(1);i<rpc.Default_UnhealthyFailCount;i++ {
+		if callTestSilentNode(pClient) == nil {
+			t.Fatal("call must be timeout")
+		}
+	}
+	if pClient.GetFailCount() < rpc.Default_UnhealthyFailCount && pClient.IsHealthy() == false {
+		t.Fatalf("node 2 with fail count %d must be healthy",pClient.GetFailCount())
+	}
+
+	for pClient.GetFailCount() < rpc.Default_UnhealthyFailCount {
+		callTestSilentNode(pClient)
+	}
+	clusterService.checkNodeHealth()
+	if eventList := takeEvent();len(eventList) != 1 || eventList[0] != event.Sys_Event_NodeDown {
+		t.Fatalf("expect NodeDown,but %+v",eventList)
+	}
+	clusterService.checkNodeHealth()
+	if eventList := takeEvent();len(eventList) != 0 {
+		t.Fatalf("unchanged node must not notify,but %+v",eventList)
+	}
+
+	//收到返回后失败次数清零
+	rpcHandler.serve()
+	if err := callTestSilentNode(pClient);err != nil {
+		t.Fatalf("call is fail:%+v",err)
+	}
+	clusterService.checkNodeHealth()
+	if eventList := takeEvent();len(eventList) != 1 || eventList[0] != event.Sys_Event_NodeUp {
+		t.Fatalf("expect NodeUp,but %+v",eventList)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/rpc"
+	"github.com/duanhf2012/origin/util/cfgbind"
 	jsoniter "github.com/json-iterator/go"
 	"io/ioutil"
+	"sort"
 	"strings"
 )
 
@@ -13,11 +15,7 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
 func (slf *Cluster) ReadClusterConfig(filepath string) (*SubNet,error) {
 	c := &SubNet{}
-	d, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return nil, err
-	}
-	err = json.Unmarshal(d, c)
+	err := readCfgFile(filepath, c)
 	if err != nil {
 		return nil, err
 	}
@@ -26,39 +24,192 @@ func (slf *Cluster) ReadClusterConfig(filepath string) (*SubNet,error) {
 }
 
 
-func (slf *Cluster) ReadServiceConfig(filepath string)  (map[string]interface{},map[int]map[string]interface{},error) {
+//service.json中的配置
+type ServiceConfig struct {
+	ServiceCfg map[string]interface{}              //Service:map[ServiceName]服务配置
+	NodeServiceCfg map[int]map[string]interface{}  //NodeService:map[NodeId]map[ServiceName]服务配置
+	LoadBalance map[string]rpc.LoadBalanceType     //LoadBalance:map[ServiceName]负载均衡方式
+	CallPolicy map[string]*rpc.CallPolicy          //CallPolicy:map[Service或Service.Method]调用策略
+	Queue map[string]*rpc.QueueConfig              //Queue:map[ServiceName]队列配置
+}
+
+func (slf *Cluster) ReadServiceConfig(filepath string) (*ServiceConfig,error) {
 
 	c := map[string]interface{}{}
 
-	d, err := ioutil.ReadFile(filepath)
+	err := readCfgFile(filepath, &c)
 	if err != nil {
-		return nil,nil, err
+		return nil, err
 	}
-	err = json.Unmarshal(d, &c)
-	if err != nil {
-		return nil,nil, err
+
+	serviceConfig,errList := parseServiceConfig(c)
+	if len(errList) > 0 {
+		return nil,errList[0]
 	}
 
+	return serviceConfig,nil
+}
+
+//解析service.json,返回所有错误
+func parseServiceConfig(c map[string]interface{}) (*ServiceConfig,[]error) {
+	var errList []error
 	serviceConfig := map[string]interface{}{}
 	serviceCfg,ok  := c["Service"]
 	if ok == true {
-		serviceConfig = serviceCfg.(map[string]interface{})
+		serviceConfig,ok = serviceCfg.(map[string]interface{})
+		if ok == false {
+			errList = append(errList,fmt.Errorf("Service config is not an object:%+v",serviceCfg))
+			serviceConfig = map[string]interface{}{}
+		}
 	}
 
 	mapNodeService := map[int]map[string]interface{}{}
 	nodeServiceCfg,ok  := c["NodeService"]
 	if ok == true {
-		nodeServiceList := nodeServiceCfg.([]interface{})
-		for _,v := range nodeServiceList{
-			serviceCfg :=v.(map[string]interface{})
+		nodeServiceList,ok := nodeServiceCfg.([]interface{})
+		if ok == false {
+			errList = append(errList,fmt.Errorf("NodeService config is not an array:%+v",nodeServiceCfg))
+		}
+		for i,v := range nodeServiceList{
+			serviceCfg,ok :=v.(map[string]interface{})
+			if ok == false {
+				errList = append(errList,fmt.Errorf("NodeService[%d] is not an object:%+v",i,v))
+				continue
+			}
 			nodeid,ok := serviceCfg["NodeId"]
 			if ok == false {
-				log.Fatal("nodeservice list not find nodeid field: %+v",nodeServiceList)
+				errList = append(errList,fmt.Errorf("NodeService[%d] not find NodeId field",i))
+				continue
+			}
+			fNodeId,ok := nodeid.(float64)
+			if ok == false || fNodeId <= 0 || fNodeId != float64(int(fNodeId)) {
+				errList = append(errList,fmt.Errorf("NodeService[%d] NodeId %+v is not a valid node id",i,nodeid))
+				continue
 			}
-			mapNodeService[int(nodeid.(float64))] = serviceCfg
+			if _,ok = mapNodeService[int(fNodeId)];ok == true {
+				errList = append(errList,fmt.Errorf("NodeService[%d] NodeId %d is duplicated",i,int(fNodeId)))
+				continue
+			}
+			mapNodeService[int(fNodeId)] = serviceCfg
 		}
 	}
-	return serviceConfig,mapNodeService,nil
+
+	//"LoadBalance":{"ServiceName":"RoundRobin"}
+	mapLoadBalance := map[string]rpc.LoadBalanceType{}
+	loadBalanceCfg,ok := c["LoadBalance"]
+	if ok == true {
+		mapLoadBalanceCfg,ok := loadBalanceCfg.(map[string]interface{})
+		if ok == false {
+			errList = append(errList,fmt.Errorf("LoadBalance config is error:%+v",loadBalanceCfg))
+		}
+		for _,serviceName := range sortedKeys(mapLoadBalanceCfg) {
+			name,_ := mapLoadBalanceCfg[serviceName].(string)
+			loadBalance,err := rpc.ParseLoadBalance(name)
+			if err != nil {
+				errList = append(errList,fmt.Errorf("LoadBalance service %s %+v",serviceName,err))
+				continue
+			}
+			mapLoadBalance[serviceName] = loadBalance
+		}
+	}
+
+	mapCallPolicy,policyErrList := parseCallPolicy(c)
+	errList = append(errList,policyErrList...)
+
+	mapQueueConfig,queueErrList := parseQueueConfig(c)
+	errList = append(errList,queueErrList...)
+
+	return &ServiceConfig{ServiceCfg:serviceConfig,NodeServiceCfg:mapNodeService,LoadBalance:mapLoadBalance,CallPolicy:mapCallPolicy,Queue:mapQueueConfig},errList
+}
+
+//"Queue":{"ServiceName":{"RequestQueueSize":10000,"Overload":"Block","BlockTimeout":"100ms"}}
+func parseQueueConfig(c map[string]interface{}) (map[string]*rpc.QueueConfig,[]error) {
+	var errList []error
+	mapQueueConfig := map[string]*rpc.QueueConfig{}
+	queueCfg,ok := c["Queue"]
+	if ok == false {
+		return mapQueueConfig,nil
+	}
+
+	mapQueueCfg,ok := queueCfg.(map[string]interface{})
+	if ok == false {
+		errList = append(errList,fmt.Errorf("Queue config is error:%+v",queueCfg))
+		return mapQueueConfig,errList
+	}
+
+	for _,serviceName := range sortedKeys(mapQueueCfg) {
+		queueConfig := &rpc.QueueConfig{}
+		err := cfgbind.Bind(mapQueueCfg[serviceName],queueConfig)
+		if err != nil {
+			errList = append(errList,fmt.Errorf("Queue %s %+v",serviceName,err))
+			continue
+		}
+		mapQueueConfig[serviceName] = queueConfig
+	}
+
+	return mapQueueConfig,errList
+}
+
+//"CallPolicy":{"ServiceName":{"MaxRetry":2},"ServiceName.RPC_Method":{"Idempotent":true}}
+//方法的配置以所在服务的配置为默认值
+func parseCallPolicy(c map[string]interface{}) (map[string]*rpc.CallPolicy,[]error) {
+	var errList []error
+	mapCallPolicy := map[string]*rpc.CallPolicy{}
+	callPolicyCfg,ok := c["CallPolicy"]
+	if ok == false {
+		return mapCallPolicy,nil
+	}
+
+	mapCallPolicyCfg,ok := callPolicyCfg.(map[string]interface{})
+	if ok == false {
+		errList = append(errList,fmt.Errorf("CallPolicy config is error:%+v",callPolicyCfg))
+		return mapCallPolicy,errList
+	}
+
+	keyList := sortedKeys(mapCallPolicyCfg)
+	//服务的配置在前,方法的配置在后
+	sort.SliceStable(keyList,func(i, j int) bool {
+		return strings.Contains(keyList[i],".") == false && strings.Contains(keyList[j],".") == true
+	})
+	for _,key := range keyList {
+		policyCfg,ok := mapCallPolicyCfg[key].(map[string]interface{})
+		if ok == false {
+			errList = append(errList,fmt.Errorf("CallPolicy %s is not an object:%+v",key,mapCallPolicyCfg[key]))
+			continue
+		}
+
+		if idx := strings.Index(key,".");idx>=0 {
+			if serviceCfg,ok := mapCallPolicyCfg[key[:idx]].(map[string]interface{});ok == true {
+				mergeCfg := map[string]interface{}{}
+				for k,v := range serviceCfg {
+					mergeCfg[k] = v
+				}
+				for k,v := range policyCfg {
+					mergeCfg[k] = v
+				}
+				policyCfg = mergeCfg
+			}
+		}
+
+		policy := &rpc.CallPolicy{}
+		err := cfgbind.Bind(policyCfg,policy)
+		if err != nil {
+			errList = append(errList,fmt.Errorf("CallPolicy %s %+v",key,err))
+			continue
+		}
+		mapCallPolicy[key] = policy
+	}
+
+	return mapCallPolicy,errList
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string,0,len(m))
+	for k,_ := range m {
+		keys = append(keys,k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (slf *Cluster) ReadAllSubNetConfig() error {
@@ -71,7 +222,10 @@ func (slf *Cluster) ReadAllSubNetConfig() error {
 	slf.mapSubNetInfo =map[string] SubNet{}
 	for _,f := range fileInfoList{
 		if f.IsDir() == true {
-			filePath := strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name()+"/"+"cluster.json"
+			filePath,err := findCfgFile(strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name(),"cluster")
+			if err != nil {
+				return err
+			}
 			subnetinfo,err:=slf.ReadClusterConfig(filePath)
 			if err != nil {
 				return fmt.Errorf("read file path %s is error:%+v" ,filePath,err)
@@ -90,22 +244,36 @@ func (slf *Cluster) ReadLocalSubNetServiceConfig(subnet string) error {
 		return fmt.Errorf("Read %s dir is fail:%+v ",clusterCfgPath,err)
 	}
 
-	slf.mapSubNetInfo =map[string] SubNet{}
 	for _,f := range fileInfoList{
 		if f.IsDir() == true && f.Name()==subnet{ //同一子网
-			filePath := strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name()+"/"+"service.json"
-			localServiceCfg,localNodeServiceCfg,err:=slf.ReadServiceConfig(filePath)
+			filePath,err := findCfgFile(strings.TrimRight(strings.TrimRight(clusterCfgPath,"/"),"\\")+"/"+f.Name(),"service")
+			if err != nil {
+				return err
+			}
+			serviceConfig,err:=slf.ReadServiceConfig(filePath)
 			if err != nil {
 				return fmt.Errorf("Read file %s is fail :%+v",filePath,err)
 			}
-			slf.localServiceCfg = localServiceCfg
-			slf.localNodeServiceCfg =localNodeServiceCfg
+			slf.applyServiceCfgOverride(slf.localNodeInfo.NodeId,serviceConfig.ServiceCfg,serviceConfig.NodeServiceCfg)
+			rpc.SetServiceLoadBalance(serviceConfig.LoadBalance)
+			rpc.SetCallPolicy(serviceConfig.CallPolicy)
+			rpc.SetQueueConfig(serviceConfig.Queue)
+			slf.serviceCfgLocker.Lock()
+			slf.localServiceCfg = serviceConfig.ServiceCfg
+			slf.localNodeServiceCfg =serviceConfig.NodeServiceCfg
+			slf.serviceCfgFile = filePath
+			slf.serviceCfgLocker.Unlock()
 		}
 	}
 
 	return nil
 }
 
+//重新读取本子网的service.json
+func (slf *Cluster) ReloadServiceCfg() error {
+	return slf.ReadLocalSubNetServiceConfig(slf.localsubnet.SubNetName)
+}
+
 
 
 func (slf *Cluster) InitCfg(currentNodeId int) error{
@@ -150,6 +318,10 @@ func (slf *Cluster) InitCfg(currentNodeId int) error{
 	}
 	subnet.SubNetName = localSubnetName
 	for _,nodeinfo := range subnet.NodeList {
+		//本结点的协议版本通过服务发现告知其他结点
+		if nodeinfo.NodeId == currentNodeId {
+			nodeinfo.RpcVersion = rpc.RpcVersion
+		}
 		localSubNetMapNode[nodeinfo.NodeId] = nodeinfo
 
 		//装载本Node进程所有的服务
@@ -170,15 +342,15 @@ func (slf *Cluster) InitCfg(currentNodeId int) error{
 				continue
 			}
 
-			if _,ok := localSubNetMapService[s];ok== true{
-				localSubNetMapService[s] = []NodeInfo{}
-			}
 			localSubNetMapService[s] = append(localSubNetMapService[s],nodeinfo)
 		}
 	}
 	if localNodeInfo.NodeId == 0 {
 		return fmt.Errorf("Canoot find NodeId %d not in any config file.",currentNodeId)
 	}
+	if _,ok := localSubNetMapNode[subnet.RegistryNodeId];subnet.RegistryNodeId!=0 && ok == false {
+		return fmt.Errorf("RegistryNodeId %d not in subnet %s",subnet.RegistryNodeId,localSubnetName)
+	}
 
 
 	slf.mapSubNetNodeInfo=mapSubNetNodeInfo
@@ -193,7 +365,23 @@ func (slf *Cluster) InitCfg(currentNodeId int) error{
 }
 
 
+//本Node配置的服务列表,按cluster.json中ServiceList顺序排列
+func (slf *Cluster) GetLocalNodeServiceList() []string {
+	serviceList := make([]string,0,len(slf.localNodeInfo.ServiceList))
+	for _,s := range slf.localNodeInfo.ServiceList {
+		servicename := s
+		if strings.Index(s,"_") == 0 {
+			servicename = s[1:]
+		}
+		serviceList = append(serviceList,servicename)
+	}
+
+	return serviceList
+}
+
 func (slf *Cluster) IsConfigService(servicename string) bool {
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
 	_,ok := slf.localNodeMapService[servicename]
 	return ok
 }
@@ -201,14 +389,20 @@ func (slf *Cluster) IsConfigService(servicename string) bool {
 
 
 func (slf *Cluster) GetNodeIdByService(servicename string,rpcClientList *[]*rpc.Client) {
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
 	nodeInfoList,ok := slf.localSubNetMapService[servicename]
 	if ok == true {
 		for _,node := range nodeInfoList {
-			pClient := GetCluster().GetRpcClient(node.NodeId)
+			pClient := slf.getRpcClient(node.NodeId)
 			if pClient==nil {
 				log.Error("Cannot connect node id %d",node.NodeId)
 				continue
 			}
+			//跳过断开或连续超时的结点
+			if pClient.IsHealthy() == false {
+				continue
+			}
 			*rpcClientList = append(*rpcClientList,pClient)
 		}
 	}
@@ -225,6 +419,9 @@ func (slf *Cluster) getServiceCfg(servicename string) interface{}{
 }
 
 func (slf *Cluster) GetServiceCfg(nodeid int,servicename string) interface{}{
+	slf.serviceCfgLocker.RLock()
+	defer slf.serviceCfgLocker.RUnlock()
+
 	nodeService,ok := slf.localNodeServiceCfg[nodeid]
 	if ok == false {
 		return slf.getServiceCfg(servicename)
@@ -237,3 +434,17 @@ func (slf *Cluster) GetServiceCfg(nodeid int,servicename string) interface{}{
 
 	return v
 }
+
+//服务配置的来源,如config/cluster/subnet/service.json NodeService(NodeId=1).TcpService
+func (slf *Cluster) GetServiceCfgSource(nodeid int,servicename string) string{
+	slf.serviceCfgLocker.RLock()
+	defer slf.serviceCfgLocker.RUnlock()
+
+	if nodeService,ok := slf.localNodeServiceCfg[nodeid];ok == true {
+		if _,ok = nodeService[servicename];ok == true {
+			return fmt.Sprintf("%s NodeService(NodeId=%d).%s",slf.serviceCfgFile,nodeid,servicename)
+		}
+	}
+
+	return fmt.Sprintf("%s Service.%s",slf.serviceCfgFile,servicename)
+}
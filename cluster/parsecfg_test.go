@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"github.com/duanhf2012/origin/service"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestServiceConfig(t *testing.T,content string) string {
+	dir,err := ioutil.TempDir("","origin_cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir,"service.json")
+	err = ioutil.WriteFile(filePath,[]byte(content),0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestReadServiceConfigQueue(t *testing.T) {
+	filePath := writeTestServiceConfig(t,`{"Queue":{"TestService":{"Overload":"Block"}}}`)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	serviceConfig,err := GetCluster().ReadServiceConfig(filePath)
+	if err != nil {
+		t.Fatalf("queue config without size must be valid:%+v",err)
+	}
+	cfg := serviceConfig.Queue["TestService"]
+	if cfg == nil || cfg.RequestQueueSize != 0 || cfg.ResponeQueueSize != 0 || cfg.BlockTimeout != 100*time.Millisecond {
+		t.Fatalf("queue config is error:%+v",cfg)
+	}
+
+	filePath = writeTestServiceConfig(t,`{"Queue":{"TestService":{"RequestQueueSize":-1}}}`)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	if _,err = GetCluster().ReadServiceConfig(filePath);err == nil {
+		t.Fatal("negative queue size must be invalid")
+	}
+}
+
+type testCfgService struct {
+	service.Service
+	changed chan interface{}
+}
+
+func (slf *testCfgService) OnServiceCfgChanged(serviceCfg interface{}) {
+	slf.changed <- serviceCfg
+}
+
+//重新加载service.json后,只有配置有变化的服务回调OnServiceCfgChanged
+func TestReloadServiceCfgChanged(t *testing.T) {
+	dir,err := ioutil.TempDir("","origin_cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	subnetDir := filepath.Join(dir,"cluster","game")
+	if err = os.MkdirAll(subnetDir,0755);err != nil {
+		t.Fatal(err)
+	}
+	writeCfg := func(content string) {
+		if err := ioutil.WriteFile(filepath.Join(subnetDir,"service.json"),[]byte(content),0644);err != nil {
+			t.Fatal(err)
+		}
+		if err := GetCluster().ReloadServiceCfg();err != nil {
+			t.Fatalf("reload service config is fail:%+v",err)
+		}
+	}
+
+	oldConfigDir := configdir
+	configdir = dir+"/"
+	defer func() { configdir = oldConfigDir }()
+	cluster = Cluster{localsubnet:SubNet{SubNetName:"game"},localNodeInfo:NodeInfo{NodeId:1}}
+	writeCfg(`{"Service":{"testCfgService":{"MaxPlayer":100}}}`)
+
+	s := &testCfgService{changed:make(chan interface{},1)}
+	s.OnSetup(s)
+	s.Init(s,nil,nil,GetCluster().GetServiceCfg(1,s.GetName()))
+	s.Start()
+	defer s.Stop()
+
+	//文件内容变化但配置相同
+	writeCfg(`{
+	"Service":{"testCfgService":{"MaxPlayer":100}}
+}`)
+	s.NotifyServiceCfgChanged(GetCluster().GetServiceCfg(1,s.GetName()))
+	select {
+	case cfg := <-s.changed:
+		t.Fatalf("unchanged config must be skipped:%+v",cfg)
+	case <-time.After(200*time.Millisecond):
+	}
+
+	writeCfg(`{"Service":{"testCfgService":{"MaxPlayer":200}}}`)
+	s.NotifyServiceCfgChanged(GetCluster().GetServiceCfg(1,s.GetName()))
+	select {
+	case cfg := <-s.changed:
+		if cfg.(map[string]interface{})["MaxPlayer"] != float64(200) {
+			t.Fatalf("changed config is error:%+v",cfg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnServiceCfgChanged is not called")
+	}
+}
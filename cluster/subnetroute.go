@@ -0,0 +1,156 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/rpc"
+	"strings"
+)
+
+//查找其他子网中开放了该服务的结点
+func (slf *Cluster) getSubNetRpcClient(subnetName string,nodeId int,serviceMethod string,clientList *[]*rpc.Client) error {
+	serviceAndMethod := strings.Split(serviceMethod,".")
+	if len(serviceAndMethod)!=2 {
+		return fmt.Errorf("servicemethod param  %s is error!",serviceMethod)
+	}
+	serviceName := serviceAndMethod[0]
+
+	subnet,ok := slf.mapSubNetInfo[subnetName]
+	if ok == false {
+		return fmt.Errorf("cannot find subnet %s!",subnetName)
+	}
+
+	if slf.isServiceExposed(subnet,serviceName,slf.localsubnet.SubNetName) == false {
+		return fmt.Errorf("service %s of subnet %s is not exposed to subnet %s!",serviceName,subnetName,slf.localsubnet.SubNetName)
+	}
+
+	for _,nodeInfo := range subnet.NodeList {
+		if nodeId>0 && nodeInfo.NodeId != nodeId {
+			continue
+		}
+		if hasService(nodeInfo,serviceName) == false {
+			continue
+		}
+
+		//连接中的结点直接跳过,不在调用方协程中等待连接。开放了服务的结点在Init时已开始连接
+		pClient := slf.getSubNetNodeClient(subnetName,nodeInfo)
+		if pClient.IsConnected() == false || (nodeId == 0 && pClient.IsHealthy() == false) {
+			continue
+		}
+		*clientList = append(*clientList,pClient)
+	}
+
+	if len(*clientList) == 0 {
+		return fmt.Errorf("cannot find connected node of service %s in subnet %s!",serviceName,subnetName)
+	}
+
+	return nil
+}
+
+//subnet的服务是否对toSubNetName子网开放
+func (slf *Cluster) isServiceExposed(subnet SubNet,serviceName string,toSubNetName string) bool {
+	subnetList,ok := subnet.ExposeService[serviceName]
+	if ok == false {
+		return false
+	}
+
+	if len(subnetList) == 0 {
+		return true
+	}
+
+	for _,name := range subnetList {
+		if name == toSubNetName {
+			return true
+		}
+	}
+
+	return false
+}
+
+//以_打头的服务只在本结点内可见
+func hasService(nodeInfo NodeInfo,serviceName string) bool {
+	for _,s := range nodeInfo.ServiceList {
+		if s == serviceName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (slf *Cluster) getSubNetNodeClient(subnetName string,nodeInfo NodeInfo) *rpc.Client {
+	slf.locker.Lock()
+	rpcInfo,ok := slf.mapSubNetRpc[nodeInfo.NodeId]
+	if ok == true {
+		slf.locker.Unlock()
+		return rpcInfo.client
+	}
+
+	rpcInfo = slf.newNodeRpcInfo(subnetName,nodeInfo)
+	slf.mapSubNetRpc[nodeInfo.NodeId] = rpcInfo
+	slf.locker.Unlock()
+
+	log.Release("connect to node id %d %s of subnet %s.",nodeInfo.NodeId,nodeInfo.ListenAddr,subnetName)
+	return rpcInfo.client
+}
+
+//连接其他子网中开放了服务给本子网的结点,连接在后台建立,避免首次调用时因为还未连接而失败
+func (slf *Cluster) connectSubNet() {
+	for subnetName,subnet := range slf.mapSubNetInfo {
+		if subnetName == slf.localsubnet.SubNetName {
+			continue
+		}
+
+		for _,nodeInfo := range subnet.NodeList {
+			for _,serviceName := range nodeInfo.ServiceList {
+				if slf.isServiceExposed(subnet,serviceName,slf.localsubnet.SubNetName) == true {
+					slf.getSubNetNodeClient(subnetName,nodeInfo)
+					break
+				}
+			}
+		}
+	}
+}
+
+//返回结点所在的子网,不在任何子网的静态配置中时返回空
+func (slf *Cluster) getNodeSubNetName(nodeId int) string {
+	for subnetName,mapNodeInfo := range slf.mapSubNetNodeInfo {
+		if _,ok := mapNodeInfo[nodeId];ok == true {
+			return subnetName
+		}
+	}
+
+	return ""
+}
+
+//其他子网的结点只能调用本子网开放给它的服务。没有握手的调用方只在LegacyRpc时按本子网结点处理,不在静态配置中也不是动态加入的调用方总是拒绝
+func (slf *Cluster) checkSubNetRequest(peerNodeId int,serviceMethod string) error {
+	if peerNodeId <= 0 {
+		if slf.localsubnet.LegacyRpc == true {
+			return nil
+		}
+		return fmt.Errorf("node without handshake is not allowed to call %s,set LegacyRpc of subnet %s if it has old nodes",serviceMethod,slf.localsubnet.SubNetName)
+	}
+
+	peerSubNetName := slf.getNodeSubNetName(peerNodeId)
+	if peerSubNetName == "" {
+		if _,ok := slf.GetNodeInfo(peerNodeId);ok == false {
+			return fmt.Errorf("node %d is not configured in any subnet,cannot call %s",peerNodeId,serviceMethod)
+		}
+		//通过注册中心动态加入的本子网结点
+		peerSubNetName = slf.localsubnet.SubNetName
+	}
+	if peerSubNetName == slf.localsubnet.SubNetName {
+		return nil
+	}
+
+	serviceName := serviceMethod
+	if idx := strings.Index(serviceMethod,".");idx >= 0 {
+		serviceName = serviceMethod[:idx]
+	}
+	if slf.isServiceExposed(slf.localsubnet,serviceName,peerSubNetName) == false {
+		return fmt.Errorf("service %s of subnet %s is not exposed to node %d of subnet %s",serviceName,slf.localsubnet.SubNetName,peerNodeId,peerSubNetName)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func newTestSubNetCluster() *Cluster {
+	return &Cluster{
+		localsubnet:SubNet{SubNetName:"login",AdminToken:"secret",ExposeService:map[string][]string{"LoginService":{"game"},"ChatService":{}}},
+		mapSubNetNodeInfo:map[string]map[int]NodeInfo{
+			"login":{1:NodeInfo{NodeId:1}},
+			"game":{2:NodeInfo{NodeId:2}},
+			"battle":{3:NodeInfo{NodeId:3}},
+		},
+		localSubNetMapNode:map[int]NodeInfo{1:{NodeId:1},4:{NodeId:4}},
+	}
+}
+
+func TestFilterSubNetRequest(t *testing.T) {
+	c := newTestSubNetCluster()
+	testList := []struct{
+		peerNodeId int
+		peerAdmin bool
+		serviceMethod string
+		allowed bool
+	}{
+		{1,false,"DBService.RPC_Load",true},     //本子网结点
+		{0,false,"DBService.RPC_Load",false},    //没有握手的调用方
+		{99,false,"DBService.RPC_Load",false},   //不在配置中的调用方
+		{4,false,"DBService.RPC_Load",true},     //动态加入的本子网结点
+		{2,false,"LoginService.RPC_Login",true}, //开放给game子网
+		{3,false,"LoginService.RPC_Login",false},
+		{3,false,"ChatService.RPC_Send",true},   //对所有子网开放
+		{2,false,"DBService.RPC_Load",false},
+		{1,true,ClusterServiceName+".RPC_InstallService",true},
+		{1,false,ClusterServiceName+".RPC_InstallService",false}, //NodeId不能代替口令
+		{0,true,ClusterServiceName+".RPC_UninstallService",true},
+		{0,false,ClusterServiceName+".RPC_GetRpcInfo",false},
+		{1,false,ClusterServiceName+".RPC_ServiceChanged",false},
+		{1,true,ClusterServiceName+".RPC_ServiceChanged",true},
+	}
+
+	for _,test := range testList {
+		err := c.filterRequest(test.peerNodeId,test.peerAdmin,test.serviceMethod)
+		if (err == nil) != test.allowed {
+			t.Fatalf("node %d call %s,expect allowed %t,error:%+v",test.peerNodeId,test.serviceMethod,test.allowed,err)
+		}
+	}
+
+	//有旧版本结点时没有握手的调用方按本子网结点处理
+	c.localsubnet.LegacyRpc = true
+	if err := c.filterRequest(0,false,"DBService.RPC_Load");err != nil {
+		t.Fatalf("expect node without handshake is allowed with LegacyRpc,error:%+v",err)
+	}
+	if err := c.filterRequest(99,false,"DBService.RPC_Load");err == nil {
+		t.Fatal("expect unknown node is denied with LegacyRpc")
+	}
+}
+
+func TestConnectSubNet(t *testing.T) {
+	c := &Cluster{
+		localsubnet:SubNet{SubNetName:"game"},
+		localNodeInfo:NodeInfo{NodeId:1},
+		mapSubNetRpc:map[int]NodeRpcInfo{},
+		mapSubNetInfo:map[string]SubNet{
+			"game":{SubNetName:"game",NodeList:[]NodeInfo{{NodeId:1}}},
+			"login":{
+				SubNetName:"login",
+				ExposeService:map[string][]string{"LoginService":{"game"},"GMService":{"gm"}},
+				NodeList:[]NodeInfo{
+					{NodeId:2,ListenAddr:"127.0.0.1:1",ServiceList:[]string{"DBService","LoginService"}},
+					{NodeId:3,ListenAddr:"127.0.0.1:2",ServiceList:[]string{"GMService"}},
+				},
+			},
+		},
+	}
+
+	c.connectSubNet()
+	defer func() {
+		for _,rpcInfo := range c.mapSubNetRpc {
+			rpcInfo.client.Close()
+		}
+	}()
+
+	if _,ok := c.mapSubNetRpc[2];ok == false {
+		t.Fatal("expect node 2 with exposed LoginService is connected")
+	}
+	if len(c.mapSubNetRpc) != 1 {
+		t.Fatalf("expect only node 2 is connected,but %d nodes",len(c.mapSubNetRpc))
+	}
+}
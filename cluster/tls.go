@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/rpc"
+	"io/ioutil"
+)
+
+//子网内结点间rpc连接的TLS配置,配置后双方都需要出示由CAFile签发的证书
+type RpcTLSConfig struct {
+	CAFile string     //校验对端证书的CA,可以包含多个证书,其他子网的结点连接本子网时也按此校验
+	CertFile string   //本结点的证书,监听与连接时使用
+	KeyFile string
+	ServerName string //校验服务端证书的名称,为空时使用ListenAddr中的主机
+}
+
+func (slf *RpcTLSConfig) IsEnabled() bool {
+	return slf.CAFile != "" || slf.CertFile != "" || slf.KeyFile != ""
+}
+
+func (slf *RpcTLSConfig) load() (tls.Certificate,*x509.CertPool,error) {
+	if slf.CAFile == "" || slf.CertFile == "" || slf.KeyFile == "" {
+		return tls.Certificate{},nil,fmt.Errorf("RpcTLS CAFile, CertFile and KeyFile are required")
+	}
+
+	cert,err := tls.LoadX509KeyPair(slf.CertFile,slf.KeyFile)
+	if err != nil {
+		return tls.Certificate{},nil,fmt.Errorf("RpcTLS load certificate %s error:%+v",slf.CertFile,err)
+	}
+
+	caData,err := ioutil.ReadFile(slf.CAFile)
+	if err != nil {
+		return tls.Certificate{},nil,fmt.Errorf("RpcTLS read CAFile error:%+v",err)
+	}
+	certPool := x509.NewCertPool()
+	if certPool.AppendCertsFromPEM(caData) == false {
+		return tls.Certificate{},nil,fmt.Errorf("RpcTLS CAFile %s has no certificate",slf.CAFile)
+	}
+
+	return cert,certPool,nil
+}
+
+//监听使用的配置,要求连接方出示证书
+func (slf *RpcTLSConfig) serverConfig() (*tls.Config,error) {
+	cert,certPool,err := slf.load()
+	if err != nil {
+		return nil,err
+	}
+
+	return &tls.Config{
+		Certificates:[]tls.Certificate{cert},
+		ClientCAs:certPool,
+		ClientAuth:tls.RequireAndVerifyClientCert,
+		MinVersion:tls.VersionTLS12,
+	},nil
+}
+
+//连接subnet中结点使用的配置,以本结点的证书作为客户端证书,按subnet的CA校验服务端
+func (slf *Cluster) clientTLSConfig(subnetName string,subnet SubNet) (*tls.Config,error) {
+	if subnet.RpcTLS.IsEnabled() == false {
+		return nil,nil
+	}
+	if slf.localsubnet.RpcTLS.IsEnabled() == false {
+		return nil,fmt.Errorf("subnet %s requires RpcTLS but subnet %s has no certificate",subnetName,slf.localsubnet.SubNetName)
+	}
+
+	cert,_,err := slf.localsubnet.RpcTLS.load()
+	if err != nil {
+		return nil,err
+	}
+	_,certPool,err := subnet.RpcTLS.load()
+	if err != nil {
+		return nil,fmt.Errorf("subnet %s %+v",subnetName,err)
+	}
+
+	return &tls.Config{
+		Certificates:[]tls.Certificate{cert},
+		RootCAs:certPool,
+		ServerName:subnet.RpcTLS.ServerName,
+		MinVersion:tls.VersionTLS12,
+	},nil
+}
+
+//加载rpc监听与连接各子网使用的TLS配置,本子网的配置错误时返回错误
+func (slf *Cluster) initTLSConfig() error {
+	slf.mapSubNetTLS = map[string]*tls.Config{}
+	for subnetName,subnet := range slf.mapSubNetInfo {
+		config,err := slf.clientTLSConfig(subnetName,subnet)
+		if err != nil {
+			if subnetName == slf.localsubnet.SubNetName {
+				return err
+			}
+			//连接该子网时将无法通过校验
+			log.Error("%s",err.Error())
+			continue
+		}
+		if config != nil {
+			slf.mapSubNetTLS[subnetName] = config
+		}
+	}
+
+	if slf.localsubnet.RpcTLS.IsEnabled() == false {
+		return nil
+	}
+	config,err := slf.localsubnet.RpcTLS.serverConfig()
+	if err != nil {
+		return err
+	}
+	slf.rpcServer.SetTLSConfig(config)
+	return nil
+}
+
+//运维命令连接本子网结点使用的客户端,TLS与消息长度与结点间的连接相同。只需要InitCfg,不需要Init
+//握手时出示AdminToken,被调用方据此检查运维rpc的权限
+func (slf *Cluster) NewAdminRpcClient(nodeId int) (*rpc.Client,error) {
+	nodeInfo,ok := slf.GetNodeInfo(nodeId)
+	if ok == false {
+		return nil,fmt.Errorf("cannot find nodeid %d",nodeId)
+	}
+	if slf.localsubnet.AdminToken == "" {
+		return nil,fmt.Errorf("AdminToken of subnet %s is empty",slf.localsubnet.SubNetName)
+	}
+
+	config,err := slf.clientTLSConfig(slf.localsubnet.SubNetName,slf.localsubnet)
+	if err != nil {
+		return nil,err
+	}
+
+	client := &rpc.Client{NodeId:nodeId,PeerRpcVersion:nodeInfo.RpcVersion,ForceHandshake:true,AdminToken:slf.localsubnet.AdminToken,MaxMsgLen:nodeInfo.MaxRpcMsgLen}
+	client.TLSConfig = config
+	return client,nil
+}
@@ -3,6 +3,7 @@ package event
 import (
 	"fmt"
 	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/trace"
 	"runtime"
 	"sync"
 )
@@ -16,6 +17,8 @@ type EventCallBack func(event *Event)
 type Event struct {
 	Type EventType
 	Data interface{}
+
+	TraceContext trace.SpanContext //处理事件时所属的trace,在服务协程中发出时可以设置为当前上下文
 }
 
 type IEventHandler interface {
@@ -232,6 +235,8 @@ func (slf *EventProcessor) pushEvent(event *Event){
 }
 
 func (slf *EventProcessor) castEvent(event *Event){
+	slf.locker.RLock()
+	defer slf.locker.RUnlock()
 	if slf.mapListenerEvent == nil{
 		log.Error("mapListenerEvent not init!")
 		return
@@ -7,6 +7,11 @@ const (
 	Sys_Event_Tcp         EventType = 1
 	Sys_Event_Http_Event  EventType = 2
 	Sys_Event_WebSocket   EventType = 3
+	Sys_Event_ServiceCfgChanged EventType = 4
+	Sys_Event_NodeJoined  EventType = 5
+	Sys_Event_NodeLeft    EventType = 6
+	Sys_Event_NodeDown    EventType = 7
+	Sys_Event_NodeUp      EventType = 8
 	Sys_Event_User_Define EventType = 1000
 )
 
@@ -97,6 +97,11 @@ func (logger *Logger) Close() {
 }
 
 func (logger *Logger) doPrintf(level int, printLevel string, format string, a ...interface{}) {
+	logger.doPrintfPrefix(4, level, printLevel, "", format, a...)
+}
+
+// prefix is printed after the level, calldepth is the same as log.Logger.Output
+func (logger *Logger) doPrintfPrefix(calldepth int, level int, printLevel string, prefix string, format string, a ...interface{}) {
 	if level < logger.level {
 		return
 	}
@@ -125,8 +130,8 @@ func (logger *Logger) doPrintf(level int, printLevel string, format string, a ..
 		}
 	}
 
-	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	format = printLevel + prefix + format
+	logger.baseLogger.Output(calldepth, fmt.Sprintf(format, a...))
 
 	if level == fatalLevel {
 		os.Exit(1)
@@ -174,6 +179,20 @@ func Fatal(format string, a ...interface{}) {
 	gLogger.doPrintf(fatalLevel, printFatalLevel, format, a...)
 }
 
+// prefix is printed after the level, such as the trace id.
+// depth is the number of frames between the caller to report and this function
+func DebugPrefix(depth int, prefix string, format string, a ...interface{}) {
+	gLogger.doPrintfPrefix(depth+3, debugLevel, printDebugLevel, prefix, format, a...)
+}
+
+func ReleasePrefix(depth int, prefix string, format string, a ...interface{}) {
+	gLogger.doPrintfPrefix(depth+3, releaseLevel, printReleaseLevel, prefix, format, a...)
+}
+
+func ErrorPrefix(depth int, prefix string, format string, a ...interface{}) {
+	gLogger.doPrintfPrefix(depth+3, errorLevel, printErrorLevel, prefix, format, a...)
+}
+
 func Close() {
 	gLogger.Close()
 }
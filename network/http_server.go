@@ -1,6 +1,7 @@
 package network
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/duanhf2012/origin/log"
 	"net/http"
@@ -33,10 +34,15 @@ func (slf *HttpServer) Init(listenAddr string, handler http.Handler, readTimeout
 }
 
 func (slf *HttpServer) Start() {
+	err := slf.initServer()
+	if err != nil {
+		log.Error("http server %s init is fail:%s", slf.listenAddr, err.Error())
+		return
+	}
 	go slf.startListen()
 }
 
-func (slf *HttpServer) startListen() error {
+func (slf *HttpServer) initServer() error {
 	var tlsCaList []tls.Certificate
 	var tlsConfig *tls.Config
 	for _, caFile := range slf.caFileList {
@@ -61,14 +67,18 @@ func (slf *HttpServer) startListen() error {
 		TLSConfig:      tlsConfig,
 	}
 
+	return nil
+}
+
+func (slf *HttpServer) startListen() error {
 	var err error
-	if len(tlsCaList) > 0 {
+	if slf.httpServer.TLSConfig != nil {
 		err = slf.httpServer.ListenAndServeTLS("", "")
 	} else {
 		err = slf.httpServer.ListenAndServe()
 	}
 
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("Listen for address %s failure:%+v.",slf.listenAddr,err)
 		return err
 	}
@@ -76,6 +86,17 @@ func (slf *HttpServer) startListen() error {
 	return nil
 }
 
+//停止监听,并在timeout内等待处理中的请求完成
+func (slf *HttpServer) Shutdown(timeout time.Duration) error {
+	if slf.httpServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return slf.httpServer.Shutdown(ctx)
+}
+
 
 func (slf *HttpServer) SetCAFile(caFile []CAFile) {
 	slf.caFileList = caFile
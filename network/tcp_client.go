@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/duanhf2012/origin/log"
 	"net"
 	"sync"
@@ -15,6 +16,7 @@ type TCPClient struct {
 	PendingWriteNum int
 	AutoReconnect   bool
 	NewAgent        func(*TCPConn) Agent
+	TLSConfig       *tls.Config // 不为nil时使用TLS,连接时完成握手
 	conns           ConnSet
 	wg              sync.WaitGroup
 	closeFlag       bool
@@ -24,6 +26,7 @@ type TCPClient struct {
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
+	ChunkedMsg   bool // MaxMsgLen超过长度字段的表示范围时拆包发送
 	msgParser    *MsgParser
 }
 
@@ -66,12 +69,15 @@ func (client *TCPClient) init() {
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
 	msgParser.SetByteOrder(client.LittleEndian)
+	if client.ChunkedMsg {
+		msgParser.SetChunked(client.MaxMsgLen)
+	}
 	client.msgParser = msgParser
 }
 
 func (client *TCPClient) dial() net.Conn {
 	for {
-		conn, err := net.Dial("tcp", client.Addr)
+		conn, err := client.dialConn()
 		if err == nil || client.closeFlag {
 			return conn
 		}
@@ -82,6 +88,15 @@ func (client *TCPClient) dial() net.Conn {
 	}
 }
 
+func (client *TCPClient) dialConn() (net.Conn, error) {
+	if client.TLSConfig == nil {
+		return net.Dial("tcp", client.Addr)
+	}
+
+	dialer := &net.Dialer{Timeout: client.ConnectInterval}
+	return tls.DialWithDialer(dialer, "tcp", client.Addr, client.TLSConfig)
+}
+
 func (client *TCPClient) connect() {
 	defer client.wg.Done()
 
@@ -4,6 +4,7 @@ import (
 	"github.com/duanhf2012/origin/log"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 type ConnSet map[net.Conn]struct{}
@@ -14,6 +15,7 @@ type TCPConn struct {
 	writeChan chan []byte
 	closeFlag bool
 	msgParser *MsgParser
+	chunked   int32 // 为1时按拆包格式读写,见MsgParser
 }
 
 func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
@@ -44,7 +46,10 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 }
 
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	// TLS连接不是*net.TCPConn,直接关闭
+	if conn, ok := tcpConn.conn.(*net.TCPConn); ok {
+		conn.SetLinger(0)
+	}
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
@@ -116,6 +121,25 @@ func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
 	return tcpConn.msgParser.Write(tcpConn, args...)
 }
 
+// 连接双方协商后开启拆包,MsgParser也需要SetChunked。
+// 未开启时长度字段的最大值不作为拆包标记,超过长度字段表示范围的消息不能发送
+func (tcpConn *TCPConn) SetChunked(chunked bool) {
+	var v int32
+	if chunked {
+		v = 1
+	}
+	atomic.StoreInt32(&tcpConn.chunked, v)
+}
+
+func (tcpConn *TCPConn) IsChunked() bool {
+	return tcpConn.msgParser.isChunked(tcpConn)
+}
+
+// 连接上当前允许的最大消息长度
+func (tcpConn *TCPConn) GetMaxMsgLen() uint32 {
+	return tcpConn.msgParser.getMaxMsgLen(tcpConn)
+}
+
 func (tcpConn *TCPConn) IsConnected() bool {
 	return tcpConn.closeFlag == false
 }
\ No newline at end of file
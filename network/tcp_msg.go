@@ -5,16 +5,23 @@ import (
 	"errors"
 	"io"
 	"math"
+	"sync/atomic"
 )
 
 // --------------
 // | len | data |
 // --------------
+// chunked: len等于长度字段的最大值时表示后面还有包,最后一个包的len小于最大值(可以为0)
+// 只在MsgParser与TCPConn都SetChunked后使用,两种格式在消息长度小于最大值时相同
+// ------------------------------------------
+// | max | data | max | data | len | data |
+// ------------------------------------------
 type MsgParser struct {
 	lenMsgLen    int
 	minMsgLen    uint32
 	maxMsgLen    uint32
 	littleEndian bool
+	chunked      bool
 }
 
 func NewMsgParser() *MsgParser {
@@ -61,14 +68,48 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
-// goroutine safe
-func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
+// It's dangerous to call the method on reading or writing
+// 超过长度字段表示范围的消息拆分成多个包发送,接收时再合并,maxMsgLen为合并后的最大长度
+// 连接双方协商一致后还需要TCPConn.SetChunked才生效
+func (p *MsgParser) SetChunked(maxMsgLen uint32) {
+	p.chunked = true
+	if maxMsgLen != 0 {
+		p.maxMsgLen = maxMsgLen
+	}
+}
+
+// 长度字段能表示的最大值
+func (p *MsgParser) maxPackLen() uint32 {
+	switch p.lenMsgLen {
+	case 1:
+		return math.MaxUint8
+	case 2:
+		return math.MaxUint16
+	}
+
+	return math.MaxUint32
+}
+
+func (p *MsgParser) isChunked(conn *TCPConn) bool {
+	return p.chunked && atomic.LoadInt32(&conn.chunked) == 1
+}
+
+// 未开启拆包时不能超过长度字段的表示范围
+func (p *MsgParser) getMaxMsgLen(conn *TCPConn) uint32 {
+	if p.isChunked(conn) == false && p.maxMsgLen > p.maxPackLen() {
+		return p.maxPackLen()
+	}
+
+	return p.maxMsgLen
+}
+
+func (p *MsgParser) readLen(conn *TCPConn) (uint32, error) {
 	var b [4]byte
 	bufMsgLen := b[:p.lenMsgLen]
 
 	// read len
 	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	// parse len
@@ -90,6 +131,38 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 		}
 	}
 
+	return msgLen, nil
+}
+
+func (p *MsgParser) putLen(msg []byte, msgLen uint32) {
+	switch p.lenMsgLen {
+	case 1:
+		msg[0] = byte(msgLen)
+	case 2:
+		if p.littleEndian {
+			binary.LittleEndian.PutUint16(msg, uint16(msgLen))
+		} else {
+			binary.BigEndian.PutUint16(msg, uint16(msgLen))
+		}
+	case 4:
+		if p.littleEndian {
+			binary.LittleEndian.PutUint32(msg, msgLen)
+		} else {
+			binary.BigEndian.PutUint32(msg, msgLen)
+		}
+	}
+}
+
+// goroutine safe
+func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
+	msgLen, err := p.readLen(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isChunked(conn) && msgLen == p.maxPackLen() {
+		return p.readChunked(conn, msgLen)
+	}
 
 	// check len
 	if msgLen > p.maxMsgLen {
@@ -107,6 +180,33 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 	return msgData, nil
 }
 
+// 读取并合并拆分的包,packLen为第一个包的长度
+func (p *MsgParser) readChunked(conn *TCPConn, packLen uint32) ([]byte, error) {
+	var msgData []byte
+	for {
+		if uint64(len(msgData))+uint64(packLen) > uint64(p.maxMsgLen) {
+			return nil, errors.New("message too long")
+		}
+
+		l := len(msgData)
+		msgData = append(msgData, make([]byte, packLen)...)
+		if _, err := io.ReadFull(conn, msgData[l:]); err != nil {
+			return nil, err
+		}
+		if packLen < p.maxPackLen() {
+			break
+		}
+
+		var err error
+		packLen, err = p.readLen(conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msgData, nil
+}
+
 // goroutine safe
 func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 	// get len
@@ -116,32 +216,21 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 	}
 
 	// check len
-	if msgLen > p.maxMsgLen {
+	if msgLen > p.getMaxMsgLen(conn) {
 		return errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
 		return errors.New("message too short")
 	}
 
+	if p.isChunked(conn) && msgLen >= p.maxPackLen() {
+		return p.writeChunked(conn, msgLen, args...)
+	}
+
 	//msgLen -= 2
 	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
 
 	// write len
-	switch p.lenMsgLen {
-	case 1:
-		msg[0] = byte(msgLen)
-	case 2:
-		if p.littleEndian {
-			binary.LittleEndian.PutUint16(msg, uint16(msgLen))
-		} else {
-			binary.BigEndian.PutUint16(msg, uint16(msgLen))
-		}
-	case 4:
-		if p.littleEndian {
-			binary.LittleEndian.PutUint32(msg, msgLen)
-		} else {
-			binary.BigEndian.PutUint32(msg, msgLen)
-		}
-	}
+	p.putLen(msg, msgLen)
 
 	// write data
 	l := p.lenMsgLen
@@ -154,3 +243,31 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 
 	return nil
 }
+
+// 按长度字段的最大值拆包,一次写入保证多个协程同时发送时包不会交错
+func (p *MsgParser) writeChunked(conn *TCPConn, msgLen uint32, args ...[]byte) error {
+	data := make([]byte, 0, msgLen)
+	for i := 0; i < len(args); i++ {
+		data = append(data, args[i]...)
+	}
+
+	maxPackLen := p.maxPackLen()
+	packNum := msgLen/maxPackLen + 1
+	msg := make([]byte, 0, packNum*uint32(p.lenMsgLen)+msgLen)
+	var bufMsgLen [4]byte
+	for i := uint32(0); i < packNum; i++ {
+		packLen := maxPackLen
+		if i == packNum-1 {
+			packLen = uint32(len(data))
+		}
+
+		p.putLen(bufMsgLen[:], packLen)
+		msg = append(msg, bufMsgLen[:p.lenMsgLen]...)
+		msg = append(msg, data[:packLen]...)
+		data = data[packLen:]
+	}
+
+	conn.Write(msg)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package network
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+)
+
+func newTestMsgParser(chunked bool) *MsgParser {
+	msgParser := NewMsgParser()
+	msgParser.SetMsgLen(2, 1, 1024*1024)
+	if chunked {
+		msgParser.SetChunked(1024 * 1024)
+	}
+
+	return msgParser
+}
+
+// 返回写端与读端的连接,connChunked为两端的TCPConn.SetChunked
+func newTestConnPair(writeParser *MsgParser, readParser *MsgParser, connChunked bool) (*TCPConn, *TCPConn) {
+	c1, c2 := net.Pipe()
+	writeConn := newTCPConn(c1, 10, writeParser)
+	readConn := newTCPConn(c2, 10, readParser)
+	writeConn.SetChunked(connChunked)
+	readConn.SetChunked(connChunked)
+
+	return writeConn, readConn
+}
+
+func makeTestMsg(msgLen int) []byte {
+	msg := make([]byte, msgLen)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	return msg
+}
+
+func checkReadMsg(t *testing.T, readConn *TCPConn, msg []byte) {
+	data, err := readConn.ReadMsg()
+	if err != nil {
+		t.Fatalf("read message length %d error:%+v", len(msg), err)
+	}
+	if bytes.Equal(data, msg) == false {
+		t.Fatalf("read message length %d,expect %d", len(data), len(msg))
+	}
+}
+
+func TestMsgParserChunked(t *testing.T) {
+	writeConn, readConn := newTestConnPair(newTestMsgParser(true), newTestMsgParser(true), true)
+	defer writeConn.Destroy()
+	defer readConn.Destroy()
+
+	// 长度字段最大值前后的边界,以及需要拆成多个包的消息
+	for _, msgLen := range []int{1, math.MaxUint16 - 1, math.MaxUint16, math.MaxUint16 + 1, math.MaxUint16 * 2, 300000} {
+		msg := makeTestMsg(msgLen)
+		if err := writeConn.WriteMsg(msg); err != nil {
+			t.Fatalf("write message length %d error:%+v", msgLen, err)
+		}
+		checkReadMsg(t, readConn, msg)
+	}
+
+	if writeConn.WriteMsg(makeTestMsg(1024*1024+1)) == nil {
+		t.Fatal("write message must fail when it exceeds MaxMsgLen")
+	}
+}
+
+func TestMsgParserNotNegotiated(t *testing.T) {
+	// 双方都支持拆包但连接没有协商开启时按原格式读写
+	writeConn, readConn := newTestConnPair(newTestMsgParser(true), newTestMsgParser(true), false)
+	defer writeConn.Destroy()
+	defer readConn.Destroy()
+
+	if writeConn.GetMaxMsgLen() != math.MaxUint16 {
+		t.Fatalf("max message length is %d before chunked is negotiated", writeConn.GetMaxMsgLen())
+	}
+	if writeConn.WriteMsg(makeTestMsg(math.MaxUint16+1)) == nil {
+		t.Fatal("write message must fail when it exceeds the length field")
+	}
+
+	msg := makeTestMsg(math.MaxUint16)
+	if err := writeConn.WriteMsg(msg); err != nil {
+		t.Fatalf("write message error:%+v", err)
+	}
+	checkReadMsg(t, readConn, msg)
+}
+
+func TestMsgParserMismatched(t *testing.T) {
+	// 不支持拆包的一端即使连接开启了拆包也按原格式读写,与另一端未开启时相同
+	writeConn, readConn := newTestConnPair(newTestMsgParser(false), newTestMsgParser(true), true)
+	defer writeConn.Destroy()
+	defer readConn.Destroy()
+
+	if writeConn.IsChunked() == true || readConn.IsChunked() == false {
+		t.Fatal("chunked must be enabled only when both parser and conn enable it")
+	}
+	msg := makeTestMsg(math.MaxUint16 - 1)
+	if err := writeConn.WriteMsg(msg); err != nil {
+		t.Fatalf("write message error:%+v", err)
+	}
+	checkReadMsg(t, readConn, msg)
+	if writeConn.WriteMsg(makeTestMsg(math.MaxUint16+1)) == nil {
+		t.Fatal("write message must fail when the parser does not support chunked")
+	}
+}
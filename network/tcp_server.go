@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/duanhf2012/origin/log"
 	"net"
 	"sync"
@@ -12,6 +13,7 @@ type TCPServer struct {
 	MaxConnNum      int
 	PendingWriteNum int
 	NewAgent        func(*TCPConn) Agent
+	TLSConfig       *tls.Config // 不为nil时使用TLS
 	ln              net.Listener
 	conns           ConnSet
 	mutexConns      sync.Mutex
@@ -23,6 +25,7 @@ type TCPServer struct {
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
+	ChunkedMsg   bool // MaxMsgLen超过长度字段的表示范围时拆包发送
 	msgParser    *MsgParser
 }
 
@@ -36,6 +39,9 @@ func (server *TCPServer) init() {
 	if err != nil {
 		log.Fatal("%v", err)
 	}
+	if server.TLSConfig != nil {
+		ln = tls.NewListener(ln, server.TLSConfig)
+	}
 
 	if server.MaxConnNum <= 0 {
 		server.MaxConnNum = 100
@@ -56,6 +62,9 @@ func (server *TCPServer) init() {
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
+	if server.ChunkedMsg {
+		msgParser.SetChunked(server.MaxMsgLen)
+	}
 	server.msgParser = msgParser
 }
 
@@ -113,6 +122,12 @@ func (server *TCPServer) run() {
 	}
 }
 
+//停止接受新的连接,已建立的连接不受影响
+func (server *TCPServer) CloseListener() {
+	server.ln.Close()
+	server.wgLn.Wait()
+}
+
 func (server *TCPServer) Close() {
 	server.ln.Close()
 	server.wgLn.Wait()
@@ -140,6 +140,11 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+// 停止接受新的连接,已建立的连接不受影响
+func (server *WSServer) CloseListener() {
+	server.ln.Close()
+}
+
 func (server *WSServer) Close() {
 	server.ln.Close()
 
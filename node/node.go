@@ -6,24 +6,28 @@ import (
 	"github.com/duanhf2012/origin/console"
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/profiler"
+	"github.com/duanhf2012/origin/rpc"
 	"github.com/duanhf2012/origin/service"
+	"github.com/duanhf2012/origin/trace"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
-var closeSig chan bool
 var sigs chan os.Signal
 var nodeId int
 var preSetupService []service.IService //预安装
+var mapServiceTemplate = map[string]service.IService{} //Setup时服务实例的副本,运行中安装时以此创建新实例
 var profilerInterval time.Duration
+var bOpenTrace bool
+var traceExporter trace.IExporter
 
 func init() {
-	closeSig = make(chan bool,1)
 	sigs = make(chan os.Signal, 3)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM,syscall.Signal(10))
 }
@@ -72,10 +76,19 @@ func initNode(id int){
 		log.Fatal("read system config is error %+v",err)
 	}
 
-	//2.setup service
-	for _,s := range preSetupService {
-		//是否配置的service
-		if cluster.GetCluster().IsConfigService(s.GetName()) == false {
+	//2.安装集群内置服务,最先启动最后退出
+	clusterService := cluster.NewClusterService()
+	clusterService.Init(clusterService,cluster.GetRpcClient,cluster.GetRpcServer,nil)
+	service.Setup(clusterService)
+	cluster.SetServiceInstaller(InstallService,UninstallService)
+	service.SetServiceCfgSource(func(serviceName string) string {
+		return cluster.GetCluster().GetServiceCfgSource(nodeId,serviceName)
+	})
+
+	//3.按ServiceList顺序setup service
+	for _,serviceName := range cluster.GetCluster().GetLocalNodeServiceList() {
+		s := getPreSetupService(serviceName)
+		if s == nil || service.GetService(serviceName) != nil {
 			continue
 		}
 
@@ -85,20 +98,77 @@ func initNode(id int){
 		service.Setup(s)
 	}
 
-	//3.service初始化
-	service.Init(closeSig)
+	//4.service初始化
+	err = service.Init()
+	if err != nil {
+		log.Fatal("init service is error %+v",err)
+	}
+}
+
+func getPreSetupService(serviceName string) service.IService {
+	for _,s := range preSetupService {
+		if s.GetName() == serviceName {
+			return s
+		}
+	}
+
+	return nil
 }
 
 func Start() {
 	console.RegisterCommand("start",startNode)
 	console.RegisterCommand("stop",stopNode)
+	console.RegisterCommand("reload",reloadNode)
+	console.RegisterCommand("install",installServiceCmd)
+	console.RegisterCommand("uninstall",uninstallServiceCmd)
+	console.RegisterCommand("check-config",checkConfig)
+	console.RegisterCommand("rpcinfo",rpcInfoCmd)
 	err := console.Run(os.Args)
 	if err!=nil {
 		fmt.Printf("%+v\n",err)
-		return
+		os.Exit(1)
+	}
+}
+
+//检查config/cluster下所有子网的配置,一次列出所有问题
+func checkConfig(args []string) error {
+	errList := cluster.GetCluster().CheckConfig(func(serviceName string) bool {
+		return getPreSetupService(serviceName) != nil
+	})
+	for _,err := range errList {
+		fmt.Println(err.Error())
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("check config is fail,found %d errors",len(errList))
+	}
+
+	fmt.Println("check config is successful.")
+	return nil
+}
+
+
+func reloadNode(args []string) error {
+	processid,err := getRunProcessPid()
+	if err != nil {
+		return err
 	}
+
+	ReloadProcess(processid)
+	return nil
 }
 
+func reloadServiceCfg() {
+	err := cluster.GetCluster().ReloadServiceCfg()
+	if err != nil {
+		log.Error("reload service config is error %+v",err)
+		return
+	}
+
+	service.NotifyAllServiceCfgChanged(func(serviceName string) interface{} {
+		return cluster.GetCluster().GetServiceCfg(nodeId,serviceName)
+	})
+}
 
 func stopNode(args []string) error {
 	processid,err := getRunProcessPid()
@@ -112,6 +182,9 @@ func stopNode(args []string) error {
 
 func startNode(args []string) error {
 	//1.解析参数
+	if len(args) < 3 {
+		return fmt.Errorf("invalid option, try `%s start nodeid=1`",args[0])
+	}
 	param := args[2]
 	sparam := strings.Split(param,"=")
 	if len(sparam) != 2 {
@@ -125,6 +198,29 @@ func startNode(args []string) error {
 		return fmt.Errorf("invalid option %s",param)
 	}
 
+	//--set ServiceName.Key=value覆盖服务配置,可以指定多个
+	for i:=3;i<len(args);i++ {
+		var setting string
+		if args[i] == "--set" && i+1<len(args) {
+			i++
+			setting = args[i]
+		}else if strings.HasPrefix(args[i],"--set=") {
+			setting = strings.TrimPrefix(args[i],"--set=")
+		}else{
+			return fmt.Errorf("invalid option %s",args[i])
+		}
+
+		err = cluster.GetCluster().AddServiceCfgOverride(setting)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bOpenTrace == true {
+		trace.Open(path.Base(os.Args[0]),nodeId,traceExporter)
+		rpc.OpenTrace()
+	}
+
 	log.Release("Start running server.")
 	//2.初始化node
 	initNode(nodeId)
@@ -146,7 +242,12 @@ func startNode(args []string) error {
 	}
 	for bRun {
 		select {
-		case <-sigs:
+		case sig := <-sigs:
+			if sig == syscall.Signal(10) {
+				log.Release("receipt reload signal.")
+				reloadServiceCfg()
+				continue
+			}
 			log.Debug("receipt stop signal.")
 			bRun = false
 		case <- pProfilerTicker.C:
@@ -155,8 +256,10 @@ func startNode(args []string) error {
 	}
 
 	//7.退出
-	close(closeSig)
-	service.WaitStop()
+	cluster.GetCluster().StopAccept()
+	service.StopAllService()
+	cluster.GetCluster().Stop()
+	trace.Close()
 
 	log.Debug("Server is stop.")
 	return nil
@@ -167,7 +270,50 @@ func Setup(s ...service.IService)  {
 	for _,sv := range s {
 		sv.OnSetup(sv)
 		preSetupService = append(preSetupService,sv)
+		mapServiceTemplate[sv.GetName()] = service.NewService(sv)
+	}
+}
+
+//运行中安装已通过Setup注册的服务,以_打头的服务只在本结点内可见
+//每次安装都复制Setup时的实例创建新实例,卸载过的实例已释放不能再次使用
+func InstallService(serviceName string) error {
+	name := strings.TrimPrefix(serviceName,"_")
+	setupService,ok := mapServiceTemplate[name]
+	if ok == false {
+		return fmt.Errorf("service %s is not setup",name)
+	}
+
+	if service.GetService(name) != nil {
+		return fmt.Errorf("service %s is already installed",name)
+	}
+
+	s := service.NewService(setupService)
+	pServiceCfg := cluster.GetCluster().GetServiceCfg(nodeId,name)
+	s.Init(s,cluster.GetRpcClient,cluster.GetRpcServer,pServiceCfg)
+	err := service.Install(s)
+	if err != nil {
+		return err
+	}
+
+	cluster.GetCluster().AddLocalService(serviceName)
+	log.Release("Install service %s is successful.",name)
+	return nil
+}
+
+//运行中卸载服务,先通知其他结点不再路由到本结点,再处理完剩余队列后释放
+func UninstallService(serviceName string) error {
+	if service.GetService(serviceName) == nil {
+		return fmt.Errorf("service %s is not installed",serviceName)
 	}
+
+	cluster.GetCluster().RemoveLocalService(serviceName)
+	err := service.Uninstall(serviceName)
+	if err != nil {
+		return err
+	}
+
+	log.Release("Uninstall service %s is successful.",serviceName)
+	return nil
 }
 
 func GetService(servicename string) service.IService {
@@ -186,3 +332,9 @@ func SetSysLog(strLevel string, pathname string, flag int){
 func OpenProfilerReport(interval time.Duration){
 	profilerInterval = interval
 }
+
+//开启分布式追踪,exporter为nil时只在日志中打印trace id,不导出span
+func OpenTrace(exporter trace.IExporter){
+	bOpenTrace = true
+	traceExporter = exporter
+}
@@ -0,0 +1,39 @@
+package node
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//stop命令发送SIGTERM,reload命令发送信号10(SIGUSR1)
+func TestKillAndReloadProcessSignal(t *testing.T) {
+	sigChan := make(chan os.Signal,1)
+	signal.Notify(sigChan,syscall.SIGTERM,syscall.Signal(10))
+	defer signal.Stop(sigChan)
+
+	for _,test := range []struct{
+		send func(processId int)
+		sig os.Signal
+	}{
+		{KillProcess,syscall.SIGTERM},
+		{ReloadProcess,syscall.Signal(10)},
+	} {
+		test.send(os.Getpid())
+		select {
+		case sig := <-sigChan:
+			if sig != test.sig {
+				t.Fatalf("expect signal %v,but %v",test.sig,sig)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("signal %v is not received",test.sig)
+		}
+		//node在init中也监听了这些信号
+		select {
+		case <-sigs:
+		default:
+		}
+	}
+}
@@ -8,10 +8,19 @@ import (
 )
 
 func KillProcess(processId int){
-	err := syscall.Kill(processId,syscall.Signal(10))
+	err := syscall.Kill(processId,syscall.SIGTERM)
 	if err != nil {
 		fmt.Printf("kill processid %d is fail:%+v.\n",processId,err)
 	}else{
 		fmt.Printf("kill processid %d is successful.\n",processId)
 	}
 }
+
+func ReloadProcess(processId int){
+	err := syscall.Kill(processId,syscall.Signal(10))
+	if err != nil {
+		fmt.Printf("reload processid %d is fail:%+v.\n",processId,err)
+	}else{
+		fmt.Printf("reload processid %d is successful.\n",processId)
+	}
+}
@@ -4,4 +4,8 @@ package node
 
 func KillProcess(processId int){
 
+}
+
+func ReloadProcess(processId int){
+
 }
\ No newline at end of file
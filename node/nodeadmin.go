@@ -0,0 +1,181 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/duanhf2012/origin/cluster"
+	"github.com/duanhf2012/origin/rpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var adminConnectTimeout = 5*time.Second
+
+//解析命令行中key=value格式的参数
+func parseCmdParam(args []string) (map[string]string,error) {
+	mapParam := map[string]string{}
+	for i:=2;i<len(args);i++ {
+		sparam := strings.SplitN(args[i],"=",2)
+		if len(sparam) != 2 {
+			return nil,fmt.Errorf("invalid option %s",args[i])
+		}
+		mapParam[sparam[0]] = sparam[1]
+	}
+
+	return mapParam,nil
+}
+
+func getCmdNodeId(mapParam map[string]string) (int,error) {
+	strNodeId,ok := mapParam["nodeid"]
+	if ok == false {
+		return 0,fmt.Errorf("missing option nodeid")
+	}
+
+	nodeId,err := strconv.Atoi(strNodeId)
+	if err != nil {
+		return 0,fmt.Errorf("invalid option nodeid=%s",strNodeId)
+	}
+
+	return nodeId,nil
+}
+
+//连接到运行中的结点,用于运维命令
+func connectNode(nodeId int) (*rpc.Client,error) {
+	err := cluster.GetCluster().InitCfg(nodeId)
+	if err != nil {
+		return nil,err
+	}
+
+	nodeInfo,_ := cluster.GetCluster().GetNodeInfo(nodeId)
+	client,err := cluster.GetCluster().NewAdminRpcClient(nodeId)
+	if err != nil {
+		return nil,err
+	}
+
+	client.Connect(nodeInfo.ListenAddr)
+	for t := time.Now();client.IsConnected() == false;time.Sleep(100*time.Millisecond) {
+		if time.Since(t) > adminConnectTimeout {
+			client.Close()
+			return nil,fmt.Errorf("connect node %d %s is timeout",nodeId,nodeInfo.ListenAddr)
+		}
+	}
+
+	return client,nil
+}
+
+func callClientRpc(client *rpc.Client,serviceMethod string,args interface{},reply interface{}) error {
+	pCall := client.Go(false,serviceMethod,args,reply)
+	if pCall.Err != nil {
+		return pCall.Err
+	}
+
+	return pCall.Done().Err
+}
+
+//连接到运行中的结点并调用rpc,用于运维命令
+func callNodeRpc(nodeId int,serviceMethod string,args interface{},reply interface{}) error {
+	client,err := connectNode(nodeId)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return callClientRpc(client,serviceMethod,args,reply)
+}
+
+//program install nodeid=1 service=ServiceName
+func installServiceCmd(args []string) error {
+	return changeServiceCmd(args,"RPC_InstallService")
+}
+
+//program uninstall nodeid=1 service=ServiceName
+func uninstallServiceCmd(args []string) error {
+	return changeServiceCmd(args,"RPC_UninstallService")
+}
+
+func changeServiceCmd(args []string,method string) error {
+	mapParam,err := parseCmdParam(args)
+	if err != nil {
+		return err
+	}
+
+	nodeId,err := getCmdNodeId(mapParam)
+	if err != nil {
+		return err
+	}
+
+	serviceName,ok := mapParam["service"]
+	if ok == false {
+		return fmt.Errorf("missing option service")
+	}
+
+	err = callNodeRpc(nodeId,cluster.ClusterServiceName+"."+method,&cluster.ServiceReq{ServiceName:serviceName},&cluster.EmptyRet{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s on node %d is successful.\n",args[1],serviceName,nodeId)
+	return nil
+}
+
+//program rpcinfo nodeid=1 [service=ServiceName],输出结点上服务的rpc方法与参数类型描述
+func rpcInfoCmd(args []string) error {
+	mapParam,err := parseCmdParam(args)
+	if err != nil {
+		return err
+	}
+
+	nodeId,err := getCmdNodeId(mapParam)
+	if err != nil {
+		return err
+	}
+
+	client,err := connectNode(nodeId)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	//逐个服务获取,避免所有服务的描述超过消息最大长度
+	serviceNameList := []string{mapParam["service"]}
+	if mapParam["service"] == "" {
+		var ret cluster.RpcInfoRet
+		err = callClientRpc(client,cluster.ClusterServiceName+".RPC_GetRpcInfo",&cluster.RpcInfoReq{},&ret)
+		if err != nil {
+			return err
+		}
+		serviceNameList = ret.ServiceNameList
+	}
+
+	serviceDescList := make([]json.RawMessage,0,len(serviceNameList))
+	for _,serviceName := range serviceNameList {
+		var ret cluster.RpcInfoRet
+		err = callClientRpc(client,cluster.ClusterServiceName+".RPC_GetRpcInfo",&cluster.RpcInfoReq{ServiceName:serviceName},&ret)
+		if err != nil {
+			return err
+		}
+
+		var descList []json.RawMessage
+		err = json.Unmarshal([]byte(ret.ServiceList),&descList)
+		if err != nil {
+			return err
+		}
+		serviceDescList = append(serviceDescList,descList...)
+	}
+
+	serviceList,err := json.Marshal(serviceDescList)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	err = json.Indent(&out,serviceList,"","  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(out.String())
+	return nil
+}
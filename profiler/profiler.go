@@ -54,12 +54,15 @@ type Profiler struct {
 }
 
 var mapProfiler map[string]*Profiler
+var mapProfilerLocker sync.RWMutex
 
 func init(){
 	mapProfiler = map[string]*Profiler{}
 }
 
 func RegProfiler(profilerName string) *Profiler {
+	mapProfilerLocker.Lock()
+	defer mapProfilerLocker.Unlock()
 	if _,ok :=mapProfiler[profilerName];ok==true {
 		return nil
 	}
@@ -69,6 +72,12 @@ func RegProfiler(profilerName string) *Profiler {
 	return pProfiler
 }
 
+func UnRegProfiler(profilerName string) {
+	mapProfilerLocker.Lock()
+	defer mapProfilerLocker.Unlock()
+	delete(mapProfiler,profilerName)
+}
+
 func (slf *Profiler) SetMaxOverTime(tm time.Duration){
 	slf.maxOverTime = tm
 }
@@ -179,8 +188,36 @@ func DefaultReportFunction(name string,callNum int,costTime time.Duration,record
 	log.Release(strReport)
 }
 
+//附加到Report中的报告,返回空字符串时不输出
+type ExtraReportFunType func() string
+
+var extraReportLocker sync.Mutex
+var mapExtraReport = map[string]ExtraReportFunType{}
+
+//添加附加报告,如rpc的熔断状态,每次Report时调用
+func AddReportFunction(name string,reportFun ExtraReportFunType) {
+	extraReportLocker.Lock()
+	defer extraReportLocker.Unlock()
+	mapExtraReport[name] = reportFun
+}
+
+func extraReport() {
+	extraReportLocker.Lock()
+	defer extraReportLocker.Unlock()
+	for name,reportFun := range mapExtraReport {
+		strReport := reportFun()
+		if strReport != "" {
+			log.Release("Profiler report tag "+name+":\n"+strReport)
+		}
+	}
+}
+
 func Report() {
+	defer extraReport()
+
 	var record *list.List
+	mapProfilerLocker.RLock()
+	defer mapProfilerLocker.RUnlock()
 	for name,prof := range mapProfiler{
 		prof.stackLocker.RLock()
 
@@ -0,0 +1,189 @@
+package rpc
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/profiler"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = 0 //正常调用
+	breakerOpen     breakerState = 1 //熔断,调用直接失败
+	breakerHalfOpen breakerState = 2 //放行了一个探测调用,成功后恢复
+)
+
+var breakerStateName = []string{"closed","open","half-open"}
+
+//结点上一个服务的熔断器,按服务的CallPolicy统计连续失败
+type circuitBreaker struct {
+	state breakerState
+	failCount int
+	stateTime time.Time //熔断或开始探测的时间
+	openNum int         //累计熔断次数
+}
+
+//熔断时调用返回的错误,可以重试其他结点
+type BreakerOpenError struct {
+	NodeId int
+	ServiceName string
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of service %s on node %d is open",e.ServiceName,e.NodeId)
+}
+
+//有熔断器的Client,用于输出报告
+var breakerClientLocker sync.Mutex
+var mapBreakerClient = map[*Client]struct{}{}
+
+func init(){
+	profiler.AddReportFunction("RpcCircuitBreaker",breakerReport)
+}
+
+func getServiceName(serviceMethod string) string {
+	if idx := strings.Index(serviceMethod,".");idx>=0 {
+		return serviceMethod[:idx]
+	}
+
+	return serviceMethod
+}
+
+//返回服务配置的熔断策略,未配置时返回nil
+func getBreakerPolicy(serviceName string) *CallPolicy {
+	policy := getCallPolicy(serviceName)
+	if policy == nil || policy.BreakerFailCount <= 0 {
+		return nil
+	}
+
+	return policy
+}
+
+//熔断中且还不能探测
+func (slf *Client) isBreakerOpen(serviceMethod string) bool {
+	if slf.bSelfNode == true {
+		return false
+	}
+	serviceName := getServiceName(serviceMethod)
+	policy := getBreakerPolicy(serviceName)
+	if policy == nil {
+		return false
+	}
+
+	slf.breakerLocker.Lock()
+	defer slf.breakerLocker.Unlock()
+	breaker,ok := slf.mapBreaker[serviceName]
+	return ok == true && breaker.state != breakerClosed && time.Since(breaker.stateTime) < policy.BreakerOpenTime
+}
+
+//发出调用前检查,熔断超过BreakerOpenTime后放行一个探测调用
+func (slf *Client) acquireBreaker(serviceMethod string) error {
+	if slf.bSelfNode == true {
+		return nil
+	}
+	serviceName := getServiceName(serviceMethod)
+	policy := getBreakerPolicy(serviceName)
+	if policy == nil {
+		return nil
+	}
+
+	slf.breakerLocker.Lock()
+	defer slf.breakerLocker.Unlock()
+	breaker,ok := slf.mapBreaker[serviceName]
+	if ok == false || breaker.state == breakerClosed {
+		return nil
+	}
+	//探测调用没有结果(如被取消)时,超过BreakerOpenTime后再放行一个
+	if time.Since(breaker.stateTime) < policy.BreakerOpenTime {
+		return &BreakerOpenError{NodeId:slf.NodeId,ServiceName:serviceName}
+	}
+
+	breaker.state = breakerHalfOpen
+	breaker.stateTime = time.Now()
+	return nil
+}
+
+//记录调用结果,收到返回即为成功,超时与连接断开为失败
+func (slf *Client) recordBreaker(serviceMethod string,fail bool) {
+	if slf.bSelfNode == true {
+		return
+	}
+	serviceName := getServiceName(serviceMethod)
+	policy := getBreakerPolicy(serviceName)
+	if policy == nil {
+		return
+	}
+
+	slf.breakerLocker.Lock()
+	defer slf.breakerLocker.Unlock()
+	breaker,ok := slf.mapBreaker[serviceName]
+	if ok == false {
+		if fail == false {
+			return
+		}
+		breaker = &circuitBreaker{}
+		if slf.mapBreaker == nil {
+			slf.mapBreaker = map[string]*circuitBreaker{}
+			breakerClientLocker.Lock()
+			mapBreakerClient[slf] = struct{}{}
+			breakerClientLocker.Unlock()
+		}
+		slf.mapBreaker[serviceName] = breaker
+	}
+
+	if fail == false {
+		breaker.state = breakerClosed
+		breaker.failCount = 0
+		return
+	}
+
+	breaker.failCount++
+	if breaker.state == breakerHalfOpen || (breaker.state == breakerClosed && breaker.failCount >= policy.BreakerFailCount) {
+		breaker.state = breakerOpen
+		breaker.stateTime = time.Now()
+		breaker.openNum++
+	}
+}
+
+func (slf *Client) removeBreakerReport() {
+	breakerClientLocker.Lock()
+	delete(mapBreakerClient,slf)
+	breakerClientLocker.Unlock()
+}
+
+//输出熔断过或有失败的服务,都正常时返回空字符串
+func breakerReport() string {
+	breakerClientLocker.Lock()
+	clientList := make([]*Client,0,len(mapBreakerClient))
+	for c := range mapBreakerClient {
+		clientList = append(clientList,c)
+	}
+	breakerClientLocker.Unlock()
+	sort.Slice(clientList,func(i, j int) bool {
+		return clientList[i].NodeId < clientList[j].NodeId
+	})
+
+	var strReport string
+	for _,c := range clientList {
+		c.breakerLocker.Lock()
+		serviceList := make([]string,0,len(c.mapBreaker))
+		for serviceName := range c.mapBreaker {
+			serviceList = append(serviceList,serviceName)
+		}
+		sort.Strings(serviceList)
+		for _,serviceName := range serviceList {
+			breaker := c.mapBreaker[serviceName]
+			if breaker.state == breakerClosed && breaker.failCount == 0 && breaker.openNum == 0 {
+				continue
+			}
+			strReport += fmt.Sprintf("node %d service %s is %s,fail count %d,open count %d\n",c.NodeId,serviceName,breakerStateName[breaker.state],breaker.failCount,breaker.openNum)
+		}
+		c.breakerLocker.Unlock()
+	}
+
+	return strReport
+}
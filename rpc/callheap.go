@@ -0,0 +1,34 @@
+package rpc
+
+//按截止时间排序的未返回调用,用于检查超时
+type callHeap []*Call
+
+func (h callHeap) Len() int {
+	return len(h)
+}
+
+func (h callHeap) Less(i, j int) bool {
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h callHeap) Swap(i, j int) {
+	h[i],h[j] = h[j],h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *callHeap) Push(x interface{}) {
+	call := x.(*Call)
+	call.heapIndex = len(*h)
+	*h = append(*h,call)
+}
+
+func (h *callHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	call := old[n-1]
+	old[n-1] = nil
+	call.heapIndex = -1
+	*h = old[:n-1]
+	return call
+}
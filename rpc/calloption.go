@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"context"
+	"time"
+)
+
+//未指定超时时间的调用使用该超时时间
+var Default_CallTimeout = 15*time.Second
+
+//检查调用超时的间隔
+var Default_TimeoutCheckInterval = 100*time.Millisecond
+
+//单次调用的选项
+type CallOption func(opt *callOption)
+
+type callOption struct {
+	loadBalance LoadBalanceType
+	hashKey string
+	timeout time.Duration
+	hasTimeout bool
+	ctx context.Context
+	header map[string]string
+	deadline time.Time //多次调用共用的截止时间
+}
+
+//指定本次调用的负载均衡方式
+func WithLoadBalance(loadBalance LoadBalanceType) CallOption {
+	return func(opt *callOption) {
+		opt.loadBalance = loadBalance
+	}
+}
+
+//按key进行一致性哈希选择结点
+func WithHashKey(key string) CallOption {
+	return func(opt *callOption) {
+		opt.loadBalance = LB_ConsistentHash
+		opt.hashKey = key
+	}
+}
+
+//指定本次调用的超时时间,小于等于0时不限制
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(opt *callOption) {
+		opt.timeout = timeout
+		opt.hasTimeout = true
+	}
+}
+
+//ctx被取消时本次调用以失败返回,ctx的截止时间早于超时时间时以截止时间为准
+func WithContext(ctx context.Context) CallOption {
+	return func(opt *callOption) {
+		opt.ctx = ctx
+	}
+}
+
+//设置本次调用的请求头,服务端拦截器可以通过Invocation.Header读取
+func WithHeader(key string,value string) CallOption {
+	return func(opt *callOption) {
+		if opt.header == nil {
+			opt.header = map[string]string{}
+		}
+		opt.header[key] = value
+	}
+}
+
+//指定截止时间,用于多次调用共用同一截止时间
+func withDeadline(deadline time.Time) CallOption {
+	return func(opt *callOption) {
+		opt.deadline = deadline
+		opt.hasTimeout = true
+	}
+}
+
+//计算一次截止时间,CastCall的各结点与重试的各次调用共用该截止时间
+func sharedDeadlineOption(opts []CallOption) []CallOption {
+	deadline := makeCallOption(opts).getDeadline(false)
+	return append(opts[:len(opts):len(opts)],withDeadline(deadline))
+}
+
+func makeCallOption(opts []CallOption) *callOption {
+	opt := &callOption{}
+	for _,o := range opts {
+		o(opt)
+	}
+
+	return opt
+}
+
+//调用的截止时间,零值表示不限制。不需要返回的调用只在指定了超时时间时才有截止时间
+func (slf *callOption) getDeadline(noReply bool) time.Time {
+	var deadline time.Time
+	if slf.deadline.IsZero() == false {
+		deadline = slf.deadline
+	}else if slf.hasTimeout == true {
+		if slf.timeout > 0 {
+			deadline = time.Now().Add(slf.timeout)
+		}
+	}else if noReply == false {
+		deadline = time.Now().Add(Default_CallTimeout)
+	}
+
+	if slf.ctx != nil {
+		if ctxDeadline,ok := slf.ctx.Deadline();ok == true && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+			deadline = ctxDeadline
+		}
+	}
+
+	return deadline
+}
+
+//发送给被调用方的剩余超时时间(毫秒),0表示不限制
+func getRemainTimeout(deadline time.Time) int64 {
+	if deadline.IsZero() {
+		return 0
+	}
+
+	remain := time.Until(deadline).Milliseconds()
+	if remain < 1 {
+		remain = 1
+	}
+
+	return remain
+}
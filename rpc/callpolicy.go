@@ -0,0 +1,180 @@
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//服务或方法的调用策略,在service.json的CallPolicy中配置
+type CallPolicy struct {
+	Idempotent bool                                 //只有幂等的调用才会重试
+	MaxRetry int `validate:"min=0"`                 //连接断开等失败后最多重试的次数
+	RetryBackoff time.Duration `default:"50ms"`     //第一次重试前的等待时间,之后每次翻倍
+	MaxRetryBackoff time.Duration `default:"1s"`   //重试等待时间的上限
+	BreakerFailCount int `validate:"min=0"`         //结点连续失败该次数后熔断,为0时不熔断
+	BreakerOpenTime time.Duration `default:"10s"`  //熔断后经过该时间放行一个探测调用
+}
+
+var callPolicyLocker sync.RWMutex
+var mapCallPolicy = map[string]*CallPolicy{} //map[Service或Service.Method]调用策略
+
+//调用失败且调用没有到达被调用方或连接已断开,幂等的调用可以重试
+type ConnError struct {
+	msg string
+}
+
+func (e *ConnError) Error() string {
+	return e.msg
+}
+
+func connErrorf(format string, a ...interface{}) error {
+	return &ConnError{msg:fmt.Sprintf(format,a...)}
+}
+
+//设置调用策略,由cluster读取service.json后设置。方法的策略以所在服务的策略为默认值
+func SetCallPolicy(mapPolicy map[string]*CallPolicy) {
+	callPolicyLocker.Lock()
+	defer callPolicyLocker.Unlock()
+	mapCallPolicy = mapPolicy
+}
+
+//先查找Service.Method,再查找Service,都没有配置时返回nil
+func getCallPolicy(serviceMethod string) *CallPolicy {
+	callPolicyLocker.RLock()
+	defer callPolicyLocker.RUnlock()
+	if policy,ok := mapCallPolicy[serviceMethod];ok == true {
+		return policy
+	}
+
+	serviceName := serviceMethod
+	if idx := strings.Index(serviceMethod,".");idx>=0 {
+		serviceName = serviceMethod[:idx]
+	}
+	return mapCallPolicy[serviceName]
+}
+
+func (slf *CallPolicy) canRetry() bool {
+	return slf != nil && slf.Idempotent == true && slf.MaxRetry > 0
+}
+
+//第retry次重试前的等待时间
+func (slf *CallPolicy) backoff(retry int) time.Duration {
+	backoff := slf.RetryBackoff
+	for i := 1;i < retry && backoff < slf.MaxRetryBackoff;i++ {
+		backoff *= 2
+	}
+	if slf.MaxRetryBackoff > 0 && backoff > slf.MaxRetryBackoff {
+		backoff = slf.MaxRetryBackoff
+	}
+
+	return backoff
+}
+
+//还有重试次数,错误可以重试,并且等待后没有超过截止时间
+func (slf *CallPolicy) shouldRetry(retry int,err error,deadline time.Time) (time.Duration,bool) {
+	if retry >= slf.MaxRetry {
+		return 0,false
+	}
+	if _,ok := err.(*ConnError);ok == false {
+		if _,ok = err.(*BreakerOpenError);ok == false {
+			return 0,false
+		}
+	}
+
+	backoff := slf.backoff(retry+1)
+	if deadline.IsZero() == false && time.Now().Add(backoff).After(deadline) {
+		return 0,false
+	}
+	return backoff,true
+}
+
+func (slf *RpcHandler) callRpcWithRetry(policy *CallPolicy,nodeId int,serviceMethod string,args interface{},reply interface{},opts []CallOption) error {
+	opts = sharedDeadlineOption(opts)
+	opt := makeCallOption(opts)
+	for retry := 0;;retry++ {
+		err := slf.callRpcOnce(nodeId,serviceMethod,args,reply,opts)
+		backoff,ok := policy.shouldRetry(retry,err,opt.deadline)
+		if ok == false {
+			return err
+		}
+
+		//等待期间ctx被取消时不再重试
+		if opt.ctx == nil {
+			time.Sleep(backoff)
+			continue
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-opt.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("RPC call %s is canceled:%v",serviceMethod,opt.ctx.Err())
+		}
+	}
+}
+
+//失败后在等待backoff后于本服务协程中重新发出调用,重试结束后才回调fVal
+func (slf *RpcHandler) wrapRetryCallback(policy *CallPolicy,nodeId int,serviceMethod string,args interface{},fVal reflect.Value,opts []CallOption) reflect.Value {
+	deadline := makeCallOption(opts).deadline
+	retry := 0
+	var retryVal reflect.Value
+	retryVal = reflect.MakeFunc(fVal.Type(),func(in []reflect.Value) []reflect.Value {
+		var err error
+		if in[1].IsNil() == false {
+			err = in[1].Interface().(error)
+		}
+
+		backoff,ok := policy.shouldRetry(retry,err,deadline)
+		if ok == false {
+			return fVal.Call(in)
+		}
+		retry++
+		slf.postCallback(serviceMethod,backoff,func() {
+			slf.asyncCallClient(nodeId,serviceMethod,args,retryVal,opts)
+		})
+		return nil
+	})
+
+	return retryVal
+}
+
+//在本服务协程中执行fn,delay大于0时延迟执行,等待期间计入未返回的异步调用
+func (slf *RpcHandler) postCallback(name string,delay time.Duration,fn func()) {
+	call := MakeCall()
+	call.ServiceMethod = name
+	call.Reply = fn
+	call.callback = &funcCallback
+	call.rpcHandler = slf
+	atomic.AddInt32(&slf.pendingAsyncCallNum,1)
+	if delay <= 0 {
+		slf.pushResponeCB(call)
+		return
+	}
+
+	time.AfterFunc(delay,func() {
+		slf.callResponeCallBack <- call
+	})
+}
+
+//在服务协程中投递到回调队列,队列满时不能阻塞,否则投递给自己或相互投递的服务协程无法再处理回调队列
+func (slf *RpcHandler) pushResponeCB(call *Call) {
+	select {
+	case slf.callResponeCallBack <- call:
+	default:
+		go func() {
+			slf.callResponeCallBack <- call
+		}()
+	}
+}
+
+var funcCallback reflect.Value
+
+func init(){
+	funcCallback = reflect.ValueOf(func(fn func(),err error){
+		fn()
+	})
+}
@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallPolicyBackoff(t *testing.T) {
+	policy := &CallPolicy{Idempotent:true,MaxRetry:5,RetryBackoff:10*time.Millisecond,MaxRetryBackoff:50*time.Millisecond}
+	expect := []time.Duration{10*time.Millisecond,20*time.Millisecond,40*time.Millisecond,50*time.Millisecond,50*time.Millisecond}
+	for i,e := range expect {
+		if b := policy.backoff(i+1);b != e {
+			t.Fatalf("retry %d backoff is %v,expect %v",i+1,b,e)
+		}
+	}
+
+	if _,ok := policy.shouldRetry(0,errors.New("service error"),time.Time{});ok == true {
+		t.Fatal("error returned by service must not be retried")
+	}
+	if _,ok := policy.shouldRetry(0,connErrorf("disconnect"),time.Time{});ok == false {
+		t.Fatal("connection error must be retried")
+	}
+	if _,ok := policy.shouldRetry(5,connErrorf("disconnect"),time.Time{});ok == true {
+		t.Fatal("retry must stop after MaxRetry")
+	}
+	if _,ok := policy.shouldRetry(0,connErrorf("disconnect"),time.Now().Add(time.Millisecond));ok == true {
+		t.Fatal("retry must stop when backoff exceeds the deadline")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	SetCallPolicy(map[string]*CallPolicy{"TestService":{BreakerFailCount:2,BreakerOpenTime:20*time.Millisecond}})
+	defer SetCallPolicy(map[string]*CallPolicy{})
+
+	client := &Client{NodeId:1}
+	defer client.removeBreakerReport()
+	serviceMethod := "TestService.RPC_Test"
+	client.recordBreaker(serviceMethod,true)
+	if client.acquireBreaker(serviceMethod) != nil {
+		t.Fatal("breaker must not open before BreakerFailCount failures")
+	}
+	client.recordBreaker(serviceMethod,true)
+	if _,ok := client.acquireBreaker(serviceMethod).(*BreakerOpenError);ok == false {
+		t.Fatal("breaker must open after BreakerFailCount failures")
+	}
+	if breakerReport() == "" {
+		t.Fatal("open breaker must be reported")
+	}
+
+	//超过BreakerOpenTime后只放行一个探测调用
+	time.Sleep(30*time.Millisecond)
+	if client.acquireBreaker(serviceMethod) != nil {
+		t.Fatal("breaker must let a probe call through")
+	}
+	if client.acquireBreaker(serviceMethod) == nil {
+		t.Fatal("breaker must reject calls while probing")
+	}
+	client.recordBreaker(serviceMethod,false)
+	if client.acquireBreaker(serviceMethod) != nil || client.isBreakerOpen(serviceMethod) == true {
+		t.Fatal("breaker must close after a successful probe")
+	}
+}
+
+func TestCallRetryCancel(t *testing.T) {
+	rpcHandler := &RpcHandler{}
+	rpcHandler.funcRpcClient = func(nodeId int,serviceMethod string,client *[]*Client) error {
+		return connErrorf("node is disconnect.")
+	}
+
+	//等待重试期间ctx被取消时立即返回
+	policy := &CallPolicy{Idempotent:true,MaxRetry:5,RetryBackoff:time.Second,MaxRetryBackoff:time.Second}
+	ctx,cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond,cancel)
+	startTime := time.Now()
+	err := rpcHandler.callRpcWithRetry(policy,0,"TestService.RPC_Test",nil,nil,[]CallOption{WithContext(ctx)})
+	if err == nil || time.Since(startTime) > 500*time.Millisecond {
+		t.Fatalf("retry must stop when ctx is canceled,err %v cost %v",err,time.Since(startTime))
+	}
+}
+
+func TestCircuitBreakerNoReply(t *testing.T) {
+	SetCallPolicy(map[string]*CallPolicy{"TestPeerService":{BreakerFailCount:1,BreakerOpenTime:20*time.Millisecond}})
+	defer SetCallPolicy(map[string]*CallPolicy{})
+
+	server,addr := startTestPeerServer(t,0)
+	defer server.Stop()
+	client := connectTestPeer(t,addr,RpcVersion)
+	defer client.Close()
+
+	//不需要返回的探测调用发出后即恢复
+	serviceMethod := "TestPeerService.RPC_Echo"
+	client.recordBreaker(serviceMethod,true)
+	time.Sleep(30*time.Millisecond)
+	if client.acquireBreaker(serviceMethod) != nil {
+		t.Fatal("breaker must let a probe call through")
+	}
+	req := "probe"
+	pCall := client.Go(true,serviceMethod,&req,nil)
+	if pCall.Err != nil {
+		t.Fatalf("go is fail:%+v",pCall.Err)
+	}
+	ReleaseCall(pCall)
+	if client.acquireBreaker(serviceMethod) != nil || client.isBreakerOpen(serviceMethod) == true {
+		t.Fatal("breaker must close after a no reply probe is sent")
+	}
+}
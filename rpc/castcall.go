@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//广播调用中一个结点的结果
+type CastResult struct {
+	NodeId int
+	Reply interface{} //与传入的reply类型相同,Err不为nil时无效
+	Err error
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+//找到部署了服务的所有结点,按NodeId排序
+func (slf *RpcHandler) getCastClient(serviceMethod string,reply interface{}) ([]*Client,reflect.Type,error) {
+	replyType := reflect.TypeOf(reply)
+	if replyType == nil || replyType.Kind() != reflect.Ptr {
+		return nil,nil,fmt.Errorf("cast call %s reply param must be a pointer!",serviceMethod)
+	}
+
+	var pClientList []*Client
+	err := slf.funcRpcClient(0,serviceMethod,&pClientList)
+	if err != nil {
+		return nil,nil,err
+	}
+	if len(pClientList) == 0 {
+		return nil,nil,fmt.Errorf("Cannot find %s in any node!",serviceMethod)
+	}
+
+	sort.Slice(pClientList,func(i, j int) bool {
+		return pClientList[i].NodeId < pClientList[j].NodeId
+	})
+	return pClientList,replyType,nil
+}
+
+type castCall struct {
+	pClient *Client
+	pCall *Call
+	chain *interceptorChain
+	inv *Invocation
+}
+
+func (slf *RpcHandler) castCallRpc(serviceMethod string,args interface{},reply interface{},opts []CallOption) ([]CastResult,error) {
+	pClientList,replyType,err := slf.getCastClient(serviceMethod,reply)
+	if err != nil {
+		log.Error("Call serviceMethod is error:%+v!",err)
+		return nil,err
+	}
+	serviceMethod = trimSubNet(serviceMethod)
+	opts = sharedDeadlineOption(opts)
+
+	//先向所有结点发出调用,再依次等待返回
+	resultList := make([]CastResult,len(pClientList))
+	callList := make([]castCall,len(pClientList))
+	for i,pClient := range pClientList {
+		result := &resultList[i]
+		result.NodeId = pClient.NodeId
+		result.Reply = reflect.New(replyType.Elem()).Interface()
+
+		c := &callList[i]
+		c.pClient = pClient
+		if result.Err = pClient.acquireBreaker(serviceMethod);result.Err != nil {
+			continue
+		}
+		opt := makeCallOption(opts)
+		if chain := slf.newClientChain();chain.isEmpty() == false {
+			c.chain = chain
+			c.inv = &Invocation{ServiceMethod:serviceMethod,NodeId:pClient.NodeId,Args:args,Reply:result.Reply,Header:opt.header}
+			result.Err = chain.before(c.inv)
+			if result.Err != nil {
+				continue
+			}
+			opt.header = c.inv.Header
+		}
+		c.pCall,result.Err = slf.castClient(opt,pClient,serviceMethod,args,result.Reply)
+	}
+
+	for i := range callList {
+		c := &callList[i]
+		if c.pCall != nil {
+			resultList[i].Err = c.pCall.Done().Err
+			if c.pClient.bSelfNode == true {
+				c.pClient.RemovePending(c.pCall.Seq)
+			}
+			ReleaseCall(c.pCall)
+		}
+		if c.inv != nil {
+			resultList[i].Err = c.chain.after(c.inv,resultList[i].Err)
+		}
+	}
+
+	return resultList,nil
+}
+
+//发出调用,返回nil的Call表示调用已结束
+func (slf *RpcHandler) castClient(opt *callOption,pClient *Client,serviceMethod string,args interface{},reply interface{}) (*Call,error) {
+	if pClient.bSelfNode == true {
+		pLocalRpcServer:=slf.funcRpcServer()
+		sMethod := strings.Split(serviceMethod,".")
+		if len(sMethod)!=2 {
+			return nil,fmt.Errorf("Call serviceMethod %s is error!",serviceMethod)
+		}
+		//自己服务调用,在当前协程中直接执行
+		if sMethod[0] == slf.rpcHandler.GetName() {
+			return nil,pLocalRpcServer.myselfRpcHandlerGo(pClient,sMethod[0],sMethod[1],args,reply)
+		}
+		return pLocalRpcServer.selfNodeRpcHandlerGo(opt,pClient,false,sMethod[0],sMethod[1],args,nil,reply,nil),nil
+	}
+
+	pCall := pClient.goCall(opt,false,serviceMethod,args,reply)
+	if pCall.Err != nil {
+		err := pCall.Err
+		ReleaseCall(pCall)
+		return nil,err
+	}
+	return pCall,nil
+}
+
+func (slf *RpcHandler) asyncCastCallRpc(serviceMethod string,args interface{},reply interface{},callback func(resultList []CastResult),opts []CallOption) error {
+	if callback == nil {
+		err := fmt.Errorf("cast call %s callback is nil!",serviceMethod)
+		log.Error("%+v",err)
+		return err
+	}
+	pClientList,replyType,err := slf.getCastClient(serviceMethod,reply)
+	if err != nil {
+		log.Error("Call serviceMethod is error:%+v!",err)
+		return err
+	}
+	opts = sharedDeadlineOption(opts)
+
+	//每个结点的回调都在本服务协程中执行,最后一个返回时回调callback
+	resultList := make([]CastResult,len(pClientList))
+	remain := len(pClientList)
+	sending := true //发出调用失败时会立即回调fVal
+	funcType := reflect.FuncOf([]reflect.Type{replyType,errorType},nil,false)
+	for i,pClient := range pClientList {
+		result := &resultList[i]
+		result.NodeId = pClient.NodeId
+		fVal := reflect.MakeFunc(funcType,func(in []reflect.Value) []reflect.Value {
+			result.Reply = in[0].Interface()
+			if in[1].IsNil() == false {
+				result.Err = in[1].Interface().(error)
+			}
+			remain--
+			if remain == 0 && sending == false {
+				callback(resultList)
+			}
+			return nil
+		})
+		slf.asyncCallRpc(pClient.NodeId,serviceMethod,args,fVal.Interface(),opts)
+	}
+
+	//所有结点都已立即返回时,不在AsyncCastCall中回调,而是投递到回调队列
+	sending = false
+	if remain == 0 {
+		slf.postCallback(serviceMethod,0,func() {
+			callback(resultList)
+		})
+	}
+
+	return nil
+}
+
+//调用部署了服务的所有结点,等待所有结点返回或超时后返回每个结点的结果
+//reply只用于指定返回值的类型,如(*Ret)(nil),每个结点的返回值单独创建。找不到结点时返回错误
+//所有结点共用一个截止时间,可以通过opts指定超时时间与请求头等,负载均衡选项无效
+func (slf *RpcHandler) CastCall(serviceMethod string,args interface{},reply interface{},opts ...CallOption) ([]CastResult,error) {
+	return slf.castCallRpc(serviceMethod,args,reply,opts)
+}
+
+//异步的CastCall,所有结点返回或超时后在本服务协程中回调callback,返回错误时不会回调
+func (slf *RpcHandler) AsyncCastCall(serviceMethod string,args interface{},reply interface{},callback func(resultList []CastResult),opts ...CallOption) error {
+	return slf.asyncCastCallRpc(serviceMethod,args,reply,callback,opts)
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAsyncCastCallSendFail(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	rpcHandler.funcRpcClient = func(nodeId int,serviceMethod string,client *[]*Client) error {
+		if nodeId == 0 {
+			*client = append(*client,&Client{NodeId:2},&Client{NodeId:1})
+			return nil
+		}
+		return fmt.Errorf("node %d is disconnect",nodeId)
+	}
+
+	//所有结点都发送失败时也在回调队列中回调
+	var resultList []CastResult
+	err := rpcHandler.AsyncCastCall("TestService.RPC_Add",1,(*int)(nil),func(r []CastResult) {
+		resultList = r
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resultList != nil {
+		t.Fatal("callback must not be called in AsyncCastCall")
+	}
+	runFuture(rpcHandler)
+	if len(resultList) != 2 || resultList[0].NodeId != 1 || resultList[1].NodeId != 2 {
+		t.Fatalf("unexpected result %+v",resultList)
+	}
+	for _,result := range resultList {
+		if result.Err == nil {
+			t.Fatalf("node %d must fail",result.NodeId)
+		}
+	}
+
+	//找不到结点时返回错误,不回调
+	rpcHandler.funcRpcClient = func(nodeId int,serviceMethod string,client *[]*Client) error {
+		return nil
+	}
+	called := false
+	err = rpcHandler.AsyncCastCall("TestService.RPC_Add",1,(*int)(nil),func(r []CastResult) {
+		called = true
+	})
+	runFuture(rpcHandler)
+	if err == nil || called == true {
+		t.Fatal("cast call without node must return error")
+	}
+}
+
+func TestCastCallSendFail(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	rpcHandler.funcRpcClient = func(nodeId int,serviceMethod string,client *[]*Client) error {
+		*client = append(*client,&Client{NodeId:2},&Client{NodeId:1})
+		return nil
+	}
+
+	//未连接的结点立即返回错误,结果按NodeId排序
+	resultList,err := rpcHandler.CastCall("TestService.RPC_Add",1,(*int)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resultList) != 2 || resultList[0].NodeId != 1 || resultList[1].NodeId != 2 {
+		t.Fatalf("unexpected result %+v",resultList)
+	}
+	for _,result := range resultList {
+		if result.Err == nil {
+			t.Fatalf("node %d must fail",result.NodeId)
+		}
+	}
+
+	if _,err = rpcHandler.CastCall("TestService.RPC_Add",1,0);err == nil {
+		t.Fatal("reply which is not a pointer must return error")
+	}
+}
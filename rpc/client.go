@@ -1,7 +1,8 @@
 package rpc
 
 import (
-	"container/list"
+	"container/heap"
+	"context"
 	"fmt"
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/network"
@@ -14,20 +15,46 @@ import (
 )
 
 type Client struct {
+	NodeId int
+	LocalNodeId int //本结点的NodeId,握手时发送给被调用方
+	PeerRpcVersion int //被调用方结点信息中的RpcVersion,为0时是不支持握手的旧版本结点
+	ForceHandshake bool //不受HandshakePolicy与PeerRpcVersion影响总是握手,用于被调用方需要知道调用方NodeId的连接
+	AdminToken string //握手时发送给被调用方,与被调用方的AdminToken相同时可以调用运维rpc
+	MaxMsgLen uint32 //连接上允许的最大消息长度,与被连接结点的配置一致,为0时使用Default_MaxRpcMsgLen
+	CompressType uint32 //希望使用的压缩算法,CompressNone表示不压缩。握手时被调用方支持该算法才压缩
+	CompressMinLen int  //超过该长度的参数才压缩,为0时使用Default_CompressMinLen
 	bSelfNode bool
 	network.TCPClient
 	conn *network.TCPConn
 
 	pendingLock sync.RWMutex
 	startSeq uint64
-	pending map[uint64]*list.Element
-	pendingTimer *list.List
-	callRpcTimerout time.Duration
-	maxCheckCallRpcCount int
+	pending map[uint64]*Call
+	pendingTimer callHeap //按截止时间排序的未返回调用
+	selfCallNum int32     //本结点服务调用自己时正在执行的调用数量,这些调用不经过pending
+	closeSig chan bool
+
+	failCount int32 //连续超时的调用数量,收到返回时清零
+	rtt int64       //最近一次ping的往返时间
+
+	breakerLocker sync.Mutex
+	mapBreaker map[string]*circuitBreaker //map[ServiceName]熔断器
+
+	handshakeDone int32 //握手完成后才认为已连接
+	compressType uint32 //握手协商的压缩算法,没有握手时不压缩
+	peerVersion string
+	methodSetLocker sync.RWMutex
+	mapPeerMethod map[string]map[string]bool //握手时被调用方的rpc方法,对方不支持握手时为nil
 }
 
+//连续超时达到该数量时结点被认为不健康,路由时将跳过该结点
+var Default_UnhealthyFailCount int32 = 3
+
 func (slf *Client) NewClientAgent(conn *network.TCPConn) network.Agent {
+	//握手必须是连接上的第一条消息
+	slf.sendHandshake(conn)
 	slf.conn = conn
+	atomic.StoreInt32(&slf.failCount,0)
 	slf.ResetPending()
 
 	return slf
@@ -35,19 +62,25 @@ func (slf *Client) NewClientAgent(conn *network.TCPConn) network.Agent {
 
 func (slf *Client) Connect(addr string) error {
 	slf.Addr = addr
-	slf.maxCheckCallRpcCount = 100
-	slf.callRpcTimerout = 15*time.Second
 	slf.ConnNum = 1
 	slf.ConnectInterval = time.Second*2
 	slf.PendingWriteNum = 2000000
 	slf.AutoReconnect = true
+	if slf.MaxMsgLen == 0 {
+		slf.MaxMsgLen = Default_MaxRpcMsgLen
+	}
+	if slf.CompressMinLen == 0 {
+		slf.CompressMinLen = Default_CompressMinLen
+	}
 	slf.LenMsgLen = 2
 	slf.MinMsgLen = 2
-	slf.MaxMsgLen = math.MaxUint16
+	slf.TCPClient.MaxMsgLen = slf.MaxMsgLen
+	slf.ChunkedMsg = slf.MaxMsgLen > math.MaxUint16
 	slf.NewAgent = slf.NewClientAgent
 	slf.LittleEndian = LittleEndian
 	slf.ResetPending()
-	go slf.startCheckRpcCallTimer()
+	slf.closeSig = make(chan bool)
+	go slf.startCheckRpcCallTimer(slf.closeSig)
 	if addr == "" {
 		slf.bSelfNode = true
 		return nil
@@ -57,67 +90,98 @@ func (slf *Client) Connect(addr string) error {
 	return nil
 }
 
-func (slf *Client) startCheckRpcCallTimer(){
-	tick :=time.NewTicker( 3 * time.Second)
+//closeSig由参数传入,Close置空slf.closeSig时不影响本协程
+func (slf *Client) startCheckRpcCallTimer(closeSig chan bool){
+	tick :=time.NewTicker(Default_TimeoutCheckInterval)
 
 	for{
 		select {
 			case <- tick.C:
 				slf.checkRpcCallTimerout()
+			case <- closeSig:
+				tick.Stop()
+				return
 		}
 	}
-	tick.Stop()
 }
 
-func (slf *Client) makeCallFail(call *Call){
+//关闭连接,未返回的调用全部以失败返回
+func (slf *Client) Close(){
+	slf.TCPClient.Close()
+	if slf.closeSig != nil {
+		close(slf.closeSig)
+		slf.closeSig = nil
+	}
+	slf.ResetPending()
+	slf.removeBreakerReport()
+}
+
+//将调用结果交给调用方,异步调用投递到调用方服务的回调队列
+func finishCall(call *Call){
 	if call.callback!=nil && call.callback.IsValid() {
 		call.rpcHandler.(*RpcHandler).callResponeCallBack<-call
 	}else{
 		call.done <- call
 	}
-	slf.removePending(call.Seq)
 }
 
+//取出所有已超过截止时间的调用,每次O(logN)
 func (slf *Client) checkRpcCallTimerout(){
 	tnow := time.Now()
 
-	for i:=0;i<slf.maxCheckCallRpcCount;i++ {
-		slf.pendingLock.Lock()
-		pElem := slf.pendingTimer.Front()
-		if pElem == nil {
-			slf.pendingLock.Unlock()
-			break
-		}
-		pCall := pElem.Value.(*Call)
-		if tnow.Sub(pCall.calltime) > slf.callRpcTimerout {
-			pCall.Err = fmt.Errorf("RPC call takes more than %d seconds!",slf.callRpcTimerout/time.Second)
-			slf.makeCallFail(pCall)
-			slf.pendingLock.Unlock()
-			continue
-		}
-		slf.pendingLock.Unlock()
+	var timeoutList []*Call
+	slf.pendingLock.Lock()
+	for len(slf.pendingTimer)>0 && tnow.After(slf.pendingTimer[0].deadline) {
+		pCall := slf.removePending(slf.pendingTimer[0].Seq)
+		pCall.Err = fmt.Errorf("RPC call %s takes more than %v!",pCall.ServiceMethod,pCall.deadline.Sub(pCall.calltime).Round(time.Millisecond))
+		timeoutList = append(timeoutList,pCall)
+	}
+	slf.pendingLock.Unlock()
+
+	if len(timeoutList) > 0 {
+		atomic.AddInt32(&slf.failCount,int32(len(timeoutList)))
+	}
+	for _,pCall := range timeoutList {
+		slf.recordBreaker(pCall.ServiceMethod,true)
+		finishCall(pCall)
 	}
 }
 
 func (slf *Client) ResetPending(){
 	slf.pendingLock.Lock()
-	if slf.pending != nil {
-		for _,v := range slf.pending {
-			v.Value.(*Call).Err = fmt.Errorf("node is disconnect.")
-			v.Value.(*Call).done <- v.Value.(*Call)
+	pending := slf.pending
+	slf.pending = map[uint64]*Call{}
+	slf.pendingTimer = nil
+	for _,pCall := range pending {
+		pCall.heapIndex = -1
+		if pCall.cancelSig != nil {
+			close(pCall.cancelSig)
+			pCall.cancelSig = nil
 		}
 	}
-
-	slf.pending = map[uint64]*list.Element{}
-	slf.pendingTimer = list.New()
 	slf.pendingLock.Unlock()
+
+	for _,pCall := range pending {
+		pCall.Err = connErrorf("node is disconnect.")
+		slf.recordBreaker(pCall.ServiceMethod,true)
+		finishCall(pCall)
+	}
+}
+
+//已发出未返回的调用数量,包括本结点服务调用自己时正在执行的调用
+func (slf *Client) GetPendingNum() int{
+	slf.pendingLock.RLock()
+	defer slf.pendingLock.RUnlock()
+	return len(slf.pending)+int(atomic.LoadInt32(&slf.selfCallNum))
 }
 
 func (slf *Client) AddPending(call *Call){
 	slf.pendingLock.Lock()
 	call.calltime = time.Now()
-	elemTimer := slf.pendingTimer.PushBack(call)
-	slf.pending[call.Seq] = elemTimer//如果下面发送失败，将会一一直存在这里
+	slf.pending[call.Seq] = call//如果下面发送失败，将会一一直存在这里
+	if call.deadline.IsZero() == false {
+		heap.Push(&slf.pendingTimer,call)
+	}
 	slf.pendingLock.Unlock()
 }
 
@@ -129,42 +193,91 @@ func (slf *Client) RemovePending(seq uint64)  *Call{
 }
 
 func (slf *Client) removePending(seq uint64) *Call{
-	v,ok := slf.pending[seq]
+	call,ok := slf.pending[seq]
 	if ok == false{
 		return nil
 	}
-	slf.pendingTimer.Remove(v)
 	delete(slf.pending,seq)
-	return v.Value.(*Call)
+	if call.heapIndex >= 0 {
+		heap.Remove(&slf.pendingTimer,call.heapIndex)
+	}
+	if call.cancelSig != nil {
+		close(call.cancelSig)
+		call.cancelSig = nil
+	}
+	return call
 }
 
 
 func (slf *Client) FindPending(seq uint64) *Call{
 	slf.pendingLock.Lock()
-	v,ok := slf.pending[seq]
+	pCall,ok := slf.pending[seq]
+	slf.pendingLock.Unlock()
 	if ok == false {
-		slf.pendingLock.Unlock()
 		return nil
 	}
 
-	pCall := v.Value.(*Call)
-	slf.pendingLock.Unlock()
-
 	return pCall
 }
 
+//取消未返回的调用,调用已返回时不做处理
+func (slf *Client) CancelPending(seq uint64,err error) bool {
+	pCall := slf.RemovePending(seq)
+	if pCall == nil {
+		return false
+	}
+
+	pCall.Err = err
+	finishCall(pCall)
+	return true
+}
+
+//加入pending后,ctx被取消时取消该调用
+func (slf *Client) addPendingWithContext(call *Call,ctx context.Context){
+	if ctx == nil || ctx.Done() == nil {
+		slf.AddPending(call)
+		return
+	}
+
+	seq := call.Seq
+	serviceMethod := call.ServiceMethod
+	cancelSig := make(chan struct{})
+	call.cancelSig = cancelSig
+	slf.AddPending(call)
+	go func() {
+		select {
+		case <-ctx.Done():
+			slf.CancelPending(seq,fmt.Errorf("RPC call %s is canceled:%v",serviceMethod,ctx.Err()))
+		case <-cancelSig:
+		}
+	}()
+}
+
 func (slf *Client) generateSeq() uint64{
 	return atomic.AddUint64(&slf.startSeq,1)
 }
 
 func (slf *Client) AsycCall(rpcHandler IRpcHandler,serviceMethod string,callback reflect.Value, args interface{},replyParam interface{}) error {
+	return slf.asyncCall(&callOption{},rpcHandler,serviceMethod,callback,args,replyParam)
+}
+
+func (slf *Client) asyncCall(opt *callOption,rpcHandler IRpcHandler,serviceMethod string,callback reflect.Value, args interface{},replyParam interface{}) error {
 	call := MakeCall()
 	call.Reply = replyParam
 	call.callback = &callback
 	call.rpcHandler = rpcHandler
 	call.ServiceMethod = serviceMethod
+	call.deadline = opt.getDeadline(false)
+	if err := slf.checkPeerMethod(serviceMethod);err != nil {
+		ReleaseCall(call)
+		return err
+	}
 
+	var flag uint32
 	InParam,herr := processor.Marshal(args)
+	if herr == nil {
+		InParam,flag,herr = slf.compressParam(InParam)
+	}
 	if herr != nil {
 		ReleaseCall(call)
 		return herr
@@ -173,75 +286,136 @@ func (slf *Client) AsycCall(rpcHandler IRpcHandler,serviceMethod string,callback
 	request := &RpcRequest{}
 	call.Arg = args
 	call.Seq = slf.generateSeq()
-	request.RpcRequestData = processor.MakeRpcRequest(slf.startSeq,serviceMethod,false,InParam,nil)
-	slf.AddPending(call)
-
+	request.RpcRequestData = processor.MakeRpcRequest(call.Seq,serviceMethod,false,InParam,nil,getRemainTimeout(call.deadline),opt.header,flag)
 	bytes,err := processor.Marshal(request.RpcRequestData)
 	processor.ReleaseRpcRequest(request.RpcRequestData)
 	if err != nil {
-		slf.RemovePending(call.Seq)
 		ReleaseCall(call)
 		return err
 	}
 
+	if uint32(len(bytes)) > slf.getMaxMsgLen() {
+		ReleaseCall(call)
+		return slf.errMsgTooLong(serviceMethod,len(bytes))
+	}
+
 	if slf.conn == nil {
-		slf.RemovePending(call.Seq)
 		ReleaseCall(call)
 		return fmt.Errorf("Rpc server is disconnect,call %s is fail!",serviceMethod)
 	}
 
+	slf.addPendingWithContext(call,opt.ctx)
 	err = slf.conn.WriteMsg(bytes)
 	if err != nil {
-		slf.RemovePending(call.Seq)
-		ReleaseCall(call)
+		//已被超时或取消处理的调用由回调释放
+		if slf.RemovePending(call.Seq) != nil {
+			ReleaseCall(call)
+		}else{
+			err = nil
+		}
 	}
 
 	return err
 }
 
+//连接协商了压缩时,在flag中带上压缩算法,被调用方按该算法压缩返回值
+func (slf *Client) compressParam(inParam []byte) ([]byte,uint32,error) {
+	compressType := atomic.LoadUint32(&slf.compressType)
+	if compressType == CompressNone {
+		return inParam,0,nil
+	}
+
+	inParam,flag,err := compressData(compressType,slf.CompressMinLen,inParam)
+	return inParam,flag|compressType<<flagCompressShift,err
+}
+
+//连接没有协商拆包时不能超过65535
+func (slf *Client) getMaxMsgLen() uint32 {
+	conn := slf.conn
+	if conn == nil {
+		return slf.MaxMsgLen
+	}
+
+	return conn.GetMaxMsgLen()
+}
+
+func (slf *Client) errMsgTooLong(serviceMethod string,msgLen int) error {
+	return fmt.Errorf("call %s is fail,request length %d exceeds max message length %d of node %d",serviceMethod,msgLen,slf.getMaxMsgLen(),slf.NodeId)
+}
+
 func (slf *Client) RawGo(noReply bool,serviceMethod string,args []byte,additionParam interface{},reply interface{}) *Call {
+	return slf.rawGo(&callOption{},noReply,serviceMethod,args,additionParam,reply)
+}
+
+func (slf *Client) rawGo(opt *callOption,noReply bool,serviceMethod string,args []byte,additionParam interface{},reply interface{}) *Call {
 	call := MakeCall()
 	call.ServiceMethod = serviceMethod
 	call.Reply = reply
+	call.deadline = opt.getDeadline(noReply)
+	if call.Err = slf.checkPeerMethod(serviceMethod);call.Err != nil {
+		return call
+	}
+
+	inParam,flag,err := slf.compressParam(args)
+	if err != nil {
+		call.Err = err
+		return call
+	}
 
 	request := &RpcRequest{}
 	call.Arg = args
 	call.Seq = slf.generateSeq()
-	if noReply == false {
-		slf.AddPending(call)
-	}
-	request.RpcRequestData = processor.MakeRpcRequest(slf.startSeq,serviceMethod,noReply,args,additionParam)
+	request.RpcRequestData = processor.MakeRpcRequest(call.Seq,serviceMethod,noReply,inParam,additionParam,getRemainTimeout(call.deadline),opt.header,flag)
 	bytes,err := processor.Marshal(request.RpcRequestData)
 	processor.ReleaseRpcRequest(request.RpcRequestData)
 	if err != nil {
 		call.Err = err
-		slf.RemovePending(call.Seq)
+		return call
+	}
+
+	if uint32(len(bytes)) > slf.getMaxMsgLen() {
+		call.Err = slf.errMsgTooLong(serviceMethod,len(bytes))
 		return call
 	}
 
 	if slf.conn == nil {
-		call.Err = fmt.Errorf("call %s is fail,rpc client is disconnect.",serviceMethod)
-		slf.RemovePending(call.Seq)
+		call.Err = connErrorf("call %s is fail,rpc client is disconnect.",serviceMethod)
+		slf.recordBreaker(serviceMethod,true)
 		return call
 	}
 
+	if noReply == false {
+		slf.addPendingWithContext(call,opt.ctx)
+	}
 	err = slf.conn.WriteMsg(bytes)
+	if err != nil && noReply == false && slf.RemovePending(call.Seq) == nil {
+		//已被超时或取消处理,取出结果保证done中没有残留
+		return <-call.done
+	}
 	if err != nil {
-		slf.RemovePending(call.Seq)
-		call.Err = err
+		call.Err = connErrorf("call %s is fail,%v",serviceMethod,err)
+		slf.recordBreaker(serviceMethod,true)
+	}else if noReply == true {
+		//不需要返回的调用发出即为成功,否则放行的探测调用一直没有结果
+		slf.recordBreaker(serviceMethod,false)
 	}
 
 	return call
 }
 
 func (slf *Client) Go(noReply bool,serviceMethod string, args interface{},reply interface{}) *Call {
+	return slf.goCall(&callOption{},noReply,serviceMethod,args,reply)
+}
+
+func (slf *Client) goCall(opt *callOption,noReply bool,serviceMethod string, args interface{},reply interface{}) *Call {
 	InParam,err := processor.Marshal(args)
 	if err != nil {
 		call := MakeCall()
 		call.Err = err
+		return call
 	}
 
-	return slf.RawGo(noReply,serviceMethod,InParam,nil,reply)
+	return slf.rawGo(opt,noReply,serviceMethod,InParam,nil,reply)
 }
 
 func (slf *Client) Run(){
@@ -254,6 +428,9 @@ func (slf *Client) Run(){
 		}
 	}()
 
+	if slf.readHandshake() == false {
+		return
+	}
 	for {
 		bytes,err := slf.conn.ReadMsg()
 		if err != nil {
@@ -262,7 +439,7 @@ func (slf *Client) Run(){
 		}
 		//1.解析head
 		respone := &RpcResponse{}
-		respone.RpcResponeData =processor.MakeRpcResponse(0,nil,nil)
+		respone.RpcResponeData =processor.MakeRpcResponse(0,nil,nil,0)
 
 		err = processor.Unmarshal(bytes,respone.RpcResponeData)
 		if err != nil {
@@ -271,13 +448,19 @@ func (slf *Client) Run(){
 			continue
 		}
 
+		atomic.StoreInt32(&slf.failCount,0)
 		v := slf.RemovePending(respone.RpcResponeData.GetSeq())
 		if v == nil {
 			log.Error("rpcClient cannot find seq %d in pending",respone.RpcResponeData.GetSeq())
 		}else  {
+			slf.recordBreaker(v.ServiceMethod,false)
 			v.Err = nil
 			if len(respone.RpcResponeData.GetReply()) >0 {
-				err = processor.Unmarshal(respone.RpcResponeData.GetReply(),v.Reply)
+				var reply []byte
+				reply,err = decompressData(atomic.LoadUint32(&slf.compressType),respone.RpcResponeData.GetFlag(),respone.RpcResponeData.GetReply())
+				if err == nil {
+					err = processor.Unmarshal(reply,v.Reply)
+				}
 				if err != nil {
 					log.Error("rpcClient Unmarshal body error,error:%+v",err)
 					v.Err = err
@@ -288,11 +471,7 @@ func (slf *Client) Run(){
 				v.Err= respone.RpcResponeData.GetErr()
 			}
 
-			if v.callback!=nil && v.callback.IsValid() {
-				 v.rpcHandler.(*RpcHandler).callResponeCallBack<-v
-			}else{
-				v.done <- v
-			}
+			finishCall(v)
 		}
 
 		processor.ReleaseRpcRespose(respone.RpcResponeData)
@@ -302,6 +481,28 @@ func (slf *Client) Run(){
 func (slf *Client) OnClose(){
 }
 
+//被调用方支持握手时,握手完成后才认为已连接
 func (slf *Client) IsConnected() bool {
-	return slf.conn!=nil && slf.conn.IsConnected()==true
+	return slf.conn!=nil && slf.conn.IsConnected()==true && atomic.LoadInt32(&slf.handshakeDone) == 1
+}
+
+func (slf *Client) GetFailCount() int32 {
+	return atomic.LoadInt32(&slf.failCount)
+}
+
+func (slf *Client) GetRtt() time.Duration {
+	return time.Duration(atomic.LoadInt64(&slf.rtt))
+}
+
+func (slf *Client) SetRtt(rtt time.Duration) {
+	atomic.StoreInt64(&slf.rtt,int64(rtt))
+}
+
+//已连接并且没有连续超时
+func (slf *Client) IsHealthy() bool {
+	if slf.bSelfNode == true {
+		return true
+	}
+
+	return slf.IsConnected() && slf.GetFailCount() < Default_UnhealthyFailCount
 }
\ No newline at end of file
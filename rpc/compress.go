@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+//压缩算法
+const (
+	CompressNone   uint32 = 0
+	CompressSnappy uint32 = 1
+	CompressGzip   uint32 = 2
+	CompressZstd   uint32 = 3
+)
+
+//请求与返回的Flag
+const (
+	FlagCompressed uint32 = 1 //InParam或Reply已压缩
+
+	//请求Flag的8~15位为连接协商的压缩算法,被调用方按该算法压缩返回值
+	flagCompressShift = 8
+	flagCompressMask  = 0xff
+)
+
+var Default_CompressMinLen = 1024                  //超过该长度的参数与返回值才压缩
+var Default_MaxDecompressLen = 64*1024*1024        //解压后的最大长度
+
+var mapCompressName = map[string]uint32{"":CompressNone,"none":CompressNone,"snappy":CompressSnappy,"gzip":CompressGzip,"zstd":CompressZstd}
+
+var gzipWriterPool = sync.Pool{New:func() interface{} {
+	return gzip.NewWriter(nil)
+}}
+
+var zstdOnce sync.Once
+var zstdEncoder *zstd.Encoder
+var zstdDecoder *zstd.Decoder
+var zstdErr error
+
+//按名称获取压缩算法,支持snappy、gzip与zstd,空字符串表示不压缩
+func GetCompressType(name string) (uint32,error) {
+	compressType,ok := mapCompressName[strings.ToLower(name)]
+	if ok == false {
+		return CompressNone,fmt.Errorf("compress %s is not supported, expect snappy, gzip or zstd",name)
+	}
+
+	return compressType,nil
+}
+
+func getFlagCompressType(flag uint32) uint32 {
+	return (flag>>flagCompressShift)&flagCompressMask
+}
+
+//是否支持该压缩算法,握手时被调用方不支持调用方指定的算法则不压缩
+func isCompressSupported(compressType uint32) bool {
+	return compressType == CompressSnappy || compressType == CompressGzip || compressType == CompressZstd
+}
+
+//创建失败时每次压缩与解压都返回该错误
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder,zstdErr = zstd.NewWriter(nil,zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if zstdErr != nil {
+			zstdErr = fmt.Errorf("create zstd encoder fail:%+v",zstdErr)
+			return
+		}
+		zstdDecoder,zstdErr = zstd.NewReader(nil,zstd.WithDecoderConcurrency(0),zstd.WithDecoderMaxMemory(uint64(Default_MaxDecompressLen)))
+		if zstdErr != nil {
+			zstdErr = fmt.Errorf("create zstd decoder fail:%+v",zstdErr)
+		}
+	})
+
+	return zstdErr
+}
+
+func compress(compressType uint32,data []byte) ([]byte,error) {
+	switch compressType {
+	case CompressSnappy:
+		return snappy.Encode(nil,data),nil
+	case CompressGzip:
+		var buff bytes.Buffer
+		w := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(w)
+		w.Reset(&buff)
+		if _,err := w.Write(data);err != nil {
+			return nil,err
+		}
+		if err := w.Close();err != nil {
+			return nil,err
+		}
+		return buff.Bytes(),nil
+	case CompressZstd:
+		if err := initZstd();err != nil {
+			return nil,err
+		}
+		return zstdEncoder.EncodeAll(data,nil),nil
+	}
+
+	return nil,fmt.Errorf("compress type %d is not supported",compressType)
+}
+
+func decompress(compressType uint32,data []byte) ([]byte,error) {
+	switch compressType {
+	case CompressSnappy:
+		l,err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil,err
+		}
+		if l > Default_MaxDecompressLen {
+			return nil,fmt.Errorf("decompressed length %d exceeds %d",l,Default_MaxDecompressLen)
+		}
+		return snappy.Decode(nil,data)
+	case CompressGzip:
+		r,err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil,err
+		}
+		defer r.Close()
+		out,err := ioutil.ReadAll(io.LimitReader(r,int64(Default_MaxDecompressLen)+1))
+		if err != nil {
+			return nil,err
+		}
+		if len(out) > Default_MaxDecompressLen {
+			return nil,fmt.Errorf("decompressed length exceeds %d",Default_MaxDecompressLen)
+		}
+		return out,nil
+	case CompressZstd:
+		if err := initZstd();err != nil {
+			return nil,err
+		}
+		return zstdDecoder.DecodeAll(data,nil)
+	}
+
+	return nil,fmt.Errorf("compress type %d is not supported",compressType)
+}
+
+//data超过minLen且压缩后更小时返回压缩后的数据与FlagCompressed
+func compressData(compressType uint32,minLen int,data []byte) ([]byte,uint32,error) {
+	if compressType == CompressNone || len(data) < minLen {
+		return data,0,nil
+	}
+
+	compressed,err := compress(compressType,data)
+	if err != nil {
+		return nil,0,err
+	}
+	if len(compressed) >= len(data) {
+		return data,0,nil
+	}
+
+	return compressed,FlagCompressed,nil
+}
+
+//flag带有FlagCompressed时解压
+func decompressData(compressType uint32,flag uint32,data []byte) ([]byte,error) {
+	if flag&FlagCompressed == 0 {
+		return data,nil
+	}
+
+	return decompress(compressType,data)
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressData(t *testing.T) {
+	data := bytes.Repeat([]byte("origin compress "),1000)
+	for _,compressType := range []uint32{CompressSnappy,CompressGzip,CompressZstd} {
+		compressed,flag,err := compressData(compressType,Default_CompressMinLen,data)
+		if err != nil || flag != FlagCompressed || len(compressed) >= len(data) {
+			t.Fatalf("compress type %d fail:%+v,flag %d,length %d",compressType,err,flag,len(compressed))
+		}
+		out,err := decompressData(compressType,flag,compressed)
+		if err != nil || bytes.Equal(out,data) == false {
+			t.Fatalf("decompress type %d fail:%+v",compressType,err)
+		}
+
+		//不足最小长度时不压缩
+		short,flag,err := compressData(compressType,Default_CompressMinLen,data[:10])
+		if err != nil || flag != 0 || bytes.Equal(short,data[:10]) == false {
+			t.Fatalf("compress type %d must not compress short data",compressType)
+		}
+	}
+
+	//按其他算法解压时返回错误
+	compressed,flag,_ := compressData(CompressZstd,0,data)
+	for _,compressType := range []uint32{CompressSnappy,CompressGzip} {
+		if _,err := decompressData(compressType,flag,compressed);err == nil {
+			t.Fatalf("zstd data must not be decompressed by type %d",compressType)
+		}
+	}
+	if _,err := decompress(99,compressed);err == nil {
+		t.Fatal("unsupported compress type must return error")
+	}
+	if _,_,err := compressData(99,0,data);err == nil {
+		t.Fatal("unsupported compress type must return error")
+	}
+}
@@ -0,0 +1,249 @@
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+//异步调用的结果,回调都通过callResponeCallBack在发起调用的服务协程中执行
+//Future不是协程安全的,只能在所属服务的协程中使用,等待其他服务的Future时结果会投递到所属服务的协程
+type Future struct {
+	rpcHandler *RpcHandler
+	done bool
+	reply interface{}
+	err error
+	callbackList []func(reply interface{},err error)
+	scheduled bool     //已投递执行回调
+	timer *time.Timer  //Timeout的定时器
+}
+
+func (slf *RpcHandler) newFuture() *Future {
+	return &Future{rpcHandler:slf}
+}
+
+//创建一个已完成的Future,用于在Then中直接返回结果
+func (slf *RpcHandler) NewCompletedFuture(reply interface{},err error) *Future {
+	future := slf.newFuture()
+	future.complete(reply,err)
+	return future
+}
+
+func (slf *RpcHandler) asyncCallFuture(nodeId int,serviceMethod string,args interface{},reply interface{},opts []CallOption) *Future {
+	future := slf.newFuture()
+	replyType := reflect.TypeOf(reply)
+	if replyType == nil || replyType.Kind() != reflect.Ptr {
+		future.complete(nil,fmt.Errorf("call %s reply param must be a pointer!",serviceMethod))
+		return future
+	}
+
+	funcType := reflect.FuncOf([]reflect.Type{replyType,errorType},nil,false)
+	fVal := reflect.MakeFunc(funcType,func(in []reflect.Value) []reflect.Value {
+		if in[1].IsNil() == false {
+			future.complete(nil,in[1].Interface().(error))
+		}else{
+			future.complete(in[0].Interface(),nil)
+		}
+		return nil
+	})
+	err := slf.asyncCallRpc(nodeId,serviceMethod,args,fVal.Interface(),opts)
+	if err != nil {
+		future.complete(nil,err)
+	}
+
+	return future
+}
+
+//异步调用,返回的Future在调用返回后完成。reply只用于指定返回值类型,如(*Ret)(nil)
+func (slf *RpcHandler) AsyncCallFuture(serviceMethod string,args interface{},reply interface{},opts ...CallOption) *Future {
+	return slf.asyncCallFuture(0,serviceMethod,args,reply,opts)
+}
+
+func (slf *RpcHandler) AsyncCallNodeFuture(nodeId int,serviceMethod string,args interface{},reply interface{},opts ...CallOption) *Future {
+	return slf.asyncCallFuture(nodeId,serviceMethod,args,reply,opts)
+}
+
+func (slf *Future) complete(reply interface{},err error) {
+	if slf.done == true {
+		return
+	}
+
+	slf.done = true
+	slf.reply = reply
+	slf.err = err
+	if slf.timer != nil {
+		slf.timer.Stop()
+		slf.timer = nil
+	}
+	slf.schedule()
+}
+
+//已完成且有回调时投递到服务协程执行,没有所属服务的Future直接执行
+func (slf *Future) schedule() {
+	if slf.done == false || len(slf.callbackList) == 0 || slf.scheduled == true {
+		return
+	}
+
+	if slf.rpcHandler == nil {
+		slf.runCallback()
+		return
+	}
+	slf.scheduled = true
+	slf.rpcHandler.postCallback("Future",0,slf.runCallback)
+}
+
+func (slf *Future) runCallback() {
+	slf.scheduled = false
+	callbackList := slf.callbackList
+	slf.callbackList = nil
+	for _,callback := range callbackList {
+		callback(slf.reply,slf.err)
+	}
+}
+
+func (slf *Future) IsDone() bool {
+	return slf.done
+}
+
+//完成后有效,Err不为nil时为nil
+func (slf *Future) Reply() interface{} {
+	return slf.reply
+}
+
+func (slf *Future) Err() error {
+	return slf.err
+}
+
+//完成后在服务协程中回调,已完成时在下一次处理回调队列时回调
+func (slf *Future) OnComplete(callback func(reply interface{},err error)) {
+	slf.callbackList = append(slf.callbackList,callback)
+	slf.schedule()
+}
+
+//完成后在owner的服务协程中回调。属于其他服务时在其服务协程中注册回调,结果再投递到owner的回调队列
+func (slf *Future) onCompleteIn(owner *RpcHandler,callback func(reply interface{},err error)) {
+	if slf.rpcHandler == owner || slf.rpcHandler == nil {
+		slf.OnComplete(callback)
+		return
+	}
+
+	postCallback := callback
+	if owner != nil {
+		postCallback = func(reply interface{},err error) {
+			owner.postCallback("Future",0,func() {
+				callback(reply,err)
+			})
+		}
+	}
+	slf.rpcHandler.postCallback("Future",0,func() {
+		slf.OnComplete(postCallback)
+	})
+}
+
+//成功后以返回值调用fn,返回的Future在fn返回的Future完成后完成
+//失败时不调用fn,直接以该错误完成;fn返回nil时以(nil,nil)完成
+func (slf *Future) Then(fn func(reply interface{}) *Future) *Future {
+	next := slf.rpcHandler.newFuture()
+	slf.OnComplete(func(reply interface{},err error) {
+		if err != nil {
+			next.complete(nil,err)
+			return
+		}
+
+		future,err := callThen(fn,reply)
+		if err != nil {
+			next.complete(nil,err)
+			return
+		}
+		if future == nil {
+			next.complete(nil,nil)
+			return
+		}
+		//fn可以返回其他服务的Future
+		future.onCompleteIn(next.rpcHandler,next.complete)
+	})
+
+	return next
+}
+
+//fn崩溃时以错误完成,避免后续的Future永远不完成
+func callThen(fn func(reply interface{}) *Future,reply interface{}) (future *Future,err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			l := runtime.Stack(buf, false)
+			err = fmt.Errorf("%v: %s", r, buf[:l])
+		}
+	}()
+
+	return fn(reply),nil
+}
+
+//超过timeout未完成时以超时错误完成,返回新的Future,不会取消原来的调用
+func (slf *Future) Timeout(timeout time.Duration) *Future {
+	next := slf.rpcHandler.newFuture()
+	slf.OnComplete(next.complete)
+	if slf.done == false && next.rpcHandler != nil {
+		rpcHandler := next.rpcHandler
+		next.timer = time.AfterFunc(timeout,func() {
+			rpcHandler.postCallback("Future",0,func() {
+				next.complete(nil,fmt.Errorf("future takes more than %v!",timeout))
+			})
+		})
+	}
+
+	return next
+}
+
+//所有Future成功后完成,返回值为按顺序排列的[]interface{};任一失败时以该错误完成
+//返回的Future属于第一个Future所属的服务,列表为空时立即完成
+func All(futureList ...*Future) *Future {
+	if len(futureList) == 0 {
+		return &Future{done:true,reply:[]interface{}{}}
+	}
+
+	next := futureList[0].rpcHandler.newFuture()
+	replyList := make([]interface{},len(futureList))
+	remain := len(futureList)
+	for i,future := range futureList {
+		idx := i
+		future.onCompleteIn(next.rpcHandler,func(reply interface{},err error) {
+			if err != nil {
+				next.complete(nil,err)
+				return
+			}
+
+			replyList[idx] = reply
+			remain--
+			if remain == 0 {
+				next.complete(replyList,nil)
+			}
+		})
+	}
+
+	return next
+}
+
+//任一Future成功后以其返回值完成,全部失败时以最后一个错误完成
+//返回的Future属于第一个Future所属的服务,列表为空时以错误完成
+func Any(futureList ...*Future) *Future {
+	if len(futureList) == 0 {
+		return &Future{done:true,err:fmt.Errorf("future list is empty!")}
+	}
+
+	next := futureList[0].rpcHandler.newFuture()
+	remain := len(futureList)
+	for _,future := range futureList {
+		future.onCompleteIn(next.rpcHandler,func(reply interface{},err error) {
+			remain--
+			if err == nil {
+				next.complete(reply,nil)
+			}else if remain == 0 {
+				next.complete(nil,err)
+			}
+		})
+	}
+
+	return next
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+//模拟服务协程,处理回调队列直到没有未完成的回调
+func newTestFutureHandler() *RpcHandler {
+	rpcHandler := &RpcHandler{callResponeCallBack:make(chan *Call,100)}
+	rpcHandler.funcRpcClient = func(nodeId int,serviceMethod string,client *[]*Client) error {
+		return fmt.Errorf("cannot find %s",serviceMethod)
+	}
+	return rpcHandler
+}
+
+func runFuture(rpcHandler *RpcHandler) {
+	for rpcHandler.GetPendingAsyncCallNum() > 0 {
+		rpcHandler.HandlerRpcResponeCB(<-rpcHandler.callResponeCallBack)
+	}
+}
+
+func TestFutureThen(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	var result []interface{}
+	future := rpcHandler.NewCompletedFuture(1,nil).Then(func(reply interface{}) *Future {
+		return rpcHandler.NewCompletedFuture(reply.(int)+1,nil)
+	}).Then(func(reply interface{}) *Future {
+		return rpcHandler.NewCompletedFuture(reply.(int)*10,nil)
+	})
+	future.OnComplete(func(reply interface{},err error) {
+		result = append(result,reply,err)
+	})
+	if len(result) != 0 {
+		t.Fatal("callback must run on the callback queue")
+	}
+	runFuture(rpcHandler)
+	if len(result) != 2 || result[0] != 20 || result[1] != nil {
+		t.Fatalf("unexpected result %+v",result)
+	}
+
+	//失败时跳过后续的Then,fn崩溃时以错误完成
+	called := false
+	future = rpcHandler.NewCompletedFuture(nil,errors.New("fail")).Then(func(reply interface{}) *Future {
+		called = true
+		return nil
+	})
+	panicFuture := rpcHandler.NewCompletedFuture(nil,nil).Then(func(reply interface{}) *Future {
+		panic("then panic")
+	})
+	runFuture(rpcHandler)
+	if called == true || future.Err() == nil || future.Err().Error() != "fail" {
+		t.Fatalf("unexpected future %+v",future)
+	}
+	if panicFuture.IsDone() == false || panicFuture.Err() == nil {
+		t.Fatalf("unexpected future %+v",panicFuture)
+	}
+}
+
+func TestFutureAllAny(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	f1 := rpcHandler.newFuture()
+	f2 := rpcHandler.newFuture()
+	all := All(f1,f2)
+	any := Any(f1,f2)
+	f2.complete("b",nil)
+	runFuture(rpcHandler)
+	if all.IsDone() == true || any.IsDone() == false || any.Reply() != "b" {
+		t.Fatalf("unexpected all %+v any %+v",all,any)
+	}
+	f1.complete("a",nil)
+	runFuture(rpcHandler)
+	replyList,_ := all.Reply().([]interface{})
+	if len(replyList) != 2 || replyList[0] != "a" || replyList[1] != "b" {
+		t.Fatalf("unexpected all %+v",all)
+	}
+
+	//调用失败的Future
+	f3 := rpcHandler.AsyncCallFuture("TestService.RPC_Add",1,(*int)(nil))
+	all = All(f3,rpcHandler.NewCompletedFuture(1,nil))
+	any = Any(f3,rpcHandler.NewCompletedFuture(nil,errors.New("fail")))
+	runFuture(rpcHandler)
+	if f3.Err() == nil || all.Err() != f3.Err() || any.Err() == nil {
+		t.Fatalf("unexpected all %+v any %+v",all,any)
+	}
+	if All().IsDone() == false || Any().Err() == nil {
+		t.Fatal("empty list must be done")
+	}
+}
+
+func TestFutureTimeout(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	future := rpcHandler.newFuture()
+	timeout := future.Timeout(10*time.Millisecond)
+	rpcHandler.HandlerRpcResponeCB(<-rpcHandler.callResponeCallBack)
+	if timeout.Err() == nil {
+		t.Fatalf("unexpected future %+v",timeout)
+	}
+
+	//完成后超时不再生效
+	future.complete(1,nil)
+	timeout = future.Timeout(10*time.Millisecond)
+	runFuture(rpcHandler)
+	if timeout.Reply() != 1 || timeout.Err() != nil {
+		t.Fatalf("unexpected future %+v",timeout)
+	}
+}
+
+func TestFutureOtherService(t *testing.T) {
+	rpcHandler := newTestFutureHandler()
+	otherHandler := newTestFutureHandler()
+	otherFuture := otherHandler.newFuture()
+
+	//Then返回其他服务的Future,结果投递到本服务的协程中完成
+	var result []interface{}
+	future := rpcHandler.NewCompletedFuture(1,nil).Then(func(reply interface{}) *Future {
+		return otherFuture
+	})
+	all := All(rpcHandler.NewCompletedFuture(2,nil),otherFuture)
+	future.OnComplete(func(reply interface{},err error) {
+		result = append(result,reply,err)
+	})
+	rpcHandler.HandlerRpcResponeCB(<-rpcHandler.callResponeCallBack)
+	rpcHandler.HandlerRpcResponeCB(<-rpcHandler.callResponeCallBack)
+	if len(otherFuture.callbackList) != 0 {
+		t.Fatal("callback must be added on the goroutine of other service")
+	}
+
+	//其他服务完成时不能修改本服务的Future
+	runFuture(otherHandler)
+	otherFuture.complete(3,nil)
+	runFuture(otherHandler)
+	if future.IsDone() == true || all.IsDone() == true {
+		t.Fatal("future must be completed on its own service")
+	}
+	runFuture(rpcHandler)
+	if len(result) != 2 || result[0] != 3 || result[1] != nil {
+		t.Fatalf("unexpected result %+v",result)
+	}
+	replyList,_ := all.Reply().([]interface{})
+	if len(replyList) != 2 || replyList[0] != 2 || replyList[1] != 3 {
+		t.Fatalf("unexpected all %+v",all)
+	}
+}
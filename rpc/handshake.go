@@ -0,0 +1,387 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/network"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+//连接建立后的握手:调用方先发送本结点信息,被调用方检查后返回本结点信息与所有服务的rpc方法
+//握手消息使用json编码,与SetProcessor设置的序列化方式无关
+//旧版本结点不认识握手消息,调用方只在被调用方的结点信息中RpcVersion不小于RpcVersion时发送握手,
+//被调用方收到的第一条消息不是握手时按旧版本调用方处理
+type HandshakePolicy int
+
+const (
+	HP_Off    HandshakePolicy = 0 //不发送握手,被调用方仍然回应调用方的握手
+	HP_Warn   HandshakePolicy = 1 //版本或同名服务的方法不一致时输出错误日志,仍然建立连接
+	HP_Reject HandshakePolicy = 2 //版本或同名服务的方法不一致时断开连接,不支持握手的旧版本结点不检查
+)
+
+//本结点的rpc协议版本,通过结点信息的RpcVersion告知其他结点,支持握手的版本为1
+const RpcVersion = 1
+
+//长度的两个字节相同,大小端不一致时读出的长度也相同,可以读出对方的字节序
+const handshakeMsgLen = 0x0404
+const handshakeMagic = "ORIGIN_HANDSHAKE"
+
+type handshakeInfo struct {
+	NodeId int
+	Version string
+	Processor string
+	LittleEndian bool
+	Chunked bool //调用方:是否拆包发送超过65535的消息;被调用方:是否支持拆包
+	Compress uint32 //调用方:希望使用的压缩算法;被调用方:同意使用的压缩算法,不支持时为CompressNone
+	AdminToken string //调用方:运维rpc的口令,与被调用方的口令相同时可以调用运维rpc;被调用方不回应
+	Err string //被调用方拒绝连接的原因
+}
+
+//返回本结点所有服务的rpc方法,map[ServiceName][]RPC_Method
+type FuncRpcMethodSet func() map[string][]string
+
+var handshakePolicy = HP_Warn
+var buildVersion string
+var funcRpcMethodSet FuncRpcMethodSet
+
+func SetHandshakePolicy(policy HandshakePolicy) {
+	handshakePolicy = policy
+}
+
+//设置本结点的版本号,握手时双方的版本号都不为空且不相同时按HandshakePolicy处理
+func SetBuildVersion(version string) {
+	buildVersion = version
+}
+
+//设置获取本结点rpc方法的函数,由cluster设置
+func SetRpcMethodSetFun(fun FuncRpcMethodSet) {
+	funcRpcMethodSet = fun
+}
+
+func newHandshakeInfo(nodeId int) *handshakeInfo {
+	return &handshakeInfo{NodeId:nodeId,Version:buildVersion,Processor:reflect.TypeOf(processor).String(),LittleEndian:LittleEndian}
+}
+
+//固定为handshakeMsgLen长度,不足时以空格补齐
+func marshalHandshake(info *handshakeInfo) ([]byte,error) {
+	data,err := json.Marshal(info)
+	if err != nil {
+		return nil,err
+	}
+
+	if len(handshakeMagic)+len(data) > handshakeMsgLen {
+		return nil,fmt.Errorf("handshake length %d is too long",len(handshakeMagic)+len(data))
+	}
+
+	msg := make([]byte,handshakeMsgLen)
+	copy(msg,handshakeMagic)
+	copy(msg[len(handshakeMagic):],data)
+	for i:=len(handshakeMagic)+len(data);i<handshakeMsgLen;i++ {
+		msg[i] = ' '
+	}
+
+	return msg,nil
+}
+
+//不是握手消息时返回nil
+func unmarshalHandshake(msg []byte) (*handshakeInfo,error) {
+	if len(msg) != handshakeMsgLen || bytes.HasPrefix(msg,[]byte(handshakeMagic)) == false {
+		return nil,nil
+	}
+
+	info := &handshakeInfo{}
+	err := json.Unmarshal(msg[len(handshakeMagic):],info)
+	if err != nil {
+		return nil,err
+	}
+
+	return info,nil
+}
+
+//字节序与序列化方式不一致时无法通信,总是拒绝
+func (slf *handshakeInfo) checkCodec(peer *handshakeInfo) error {
+	if slf.LittleEndian != peer.LittleEndian {
+		return fmt.Errorf("LittleEndian is %t,but node %d is %t",slf.LittleEndian,peer.NodeId,peer.LittleEndian)
+	}
+	if slf.Processor != peer.Processor {
+		return fmt.Errorf("processor is %s,but node %d is %s",slf.Processor,peer.NodeId,peer.Processor)
+	}
+
+	return nil
+}
+
+//调用方拆包时被调用方必须支持,调用方不拆包时双方都按不拆包处理
+func checkChunked(client *handshakeInfo,server *handshakeInfo) error {
+	if client.Chunked == true && server.Chunked == false {
+		return fmt.Errorf("node %d sends chunked message,but node %d does not support it,check MaxRpcMsgLen",client.NodeId,server.NodeId)
+	}
+
+	return nil
+}
+
+func (slf *handshakeInfo) checkVersion(peer *handshakeInfo) error {
+	if slf.Version != "" && peer.Version != "" && slf.Version != peer.Version {
+		return fmt.Errorf("version is %s,but node %d is %s",slf.Version,peer.NodeId,peer.Version)
+	}
+
+	return nil
+}
+
+func getRpcMethodSet() map[string][]string {
+	if funcRpcMethodSet == nil {
+		return map[string][]string{}
+	}
+
+	return funcRpcMethodSet()
+}
+
+//比较两个结点都有的服务的方法
+func diffMethodSet(local map[string][]string,peer map[string][]string) []string {
+	var diffList []string
+	serviceList := make([]string,0,len(local))
+	for serviceName := range local {
+		serviceList = append(serviceList,serviceName)
+	}
+	sort.Strings(serviceList)
+
+	for _,serviceName := range serviceList {
+		peerMethodList,ok := peer[serviceName]
+		if ok == false {
+			continue
+		}
+
+		missing := subMethodList(local[serviceName],peerMethodList)
+		extra := subMethodList(peerMethodList,local[serviceName])
+		if len(missing) > 0 || len(extra) > 0 {
+			diffList = append(diffList,fmt.Sprintf("%s missing %v extra %v",serviceName,missing,extra))
+		}
+	}
+
+	return diffList
+}
+
+//在a中但不在b中的方法
+func subMethodList(a []string,b []string) []string {
+	mapMethod := make(map[string]bool,len(b))
+	for _,method := range b {
+		mapMethod[method] = true
+	}
+
+	var subList []string
+	for _,method := range a {
+		if mapMethod[method] == false {
+			subList = append(subList,method)
+		}
+	}
+
+	return subList
+}
+
+//被调用方不支持握手时不发送,避免旧版本结点断开连接
+func (slf *Client) needHandshake() bool {
+	if slf.ForceHandshake == true {
+		return true
+	}
+
+	return handshakePolicy != HP_Off && slf.PeerRpcVersion >= RpcVersion
+}
+
+//调用方:在连接可以被其他协程使用前发送握手
+func (slf *Client) sendHandshake(conn *network.TCPConn) {
+	atomic.StoreInt32(&slf.handshakeDone,0)
+	atomic.StoreUint32(&slf.compressType,CompressNone)
+	slf.setPeerMethodSet("",nil)
+	if slf.needHandshake() == false {
+		atomic.StoreInt32(&slf.handshakeDone,1)
+		return
+	}
+
+	local := newHandshakeInfo(slf.LocalNodeId)
+	local.Chunked = slf.ChunkedMsg
+	local.Compress = slf.CompressType
+	local.AdminToken = slf.AdminToken
+	msg,err := marshalHandshake(local)
+	if err != nil {
+		log.Error("rpcClient %s marshal handshake error:%+v",slf.Addr,err)
+		return
+	}
+	conn.WriteMsg(msg)
+	//被调用方读到握手后才按拆包读取,握手本身不会被拆包
+	conn.SetChunked(slf.ChunkedMsg)
+}
+
+//调用方:读取被调用方的握手与方法集,返回false时断开连接
+func (slf *Client) readHandshake() bool {
+	if slf.needHandshake() == false {
+		return true
+	}
+
+	msg,err := slf.conn.ReadMsg()
+	if err != nil {
+		log.Error("rpcClient %s read handshake error:%+v,check RpcVersion of node %d",slf.Addr,err,slf.NodeId)
+		return false
+	}
+	peer,err := unmarshalHandshake(msg)
+	if err != nil {
+		log.Error("rpcClient %s unmarshal handshake error:%+v",slf.Addr,err)
+		return false
+	}
+	if peer == nil {
+		log.Error("rpcClient %s node %d does not reply handshake,check RpcVersion of node %d",slf.Addr,slf.NodeId,slf.NodeId)
+		return false
+	}
+
+	if peer.Err != "" {
+		log.Error("rpcClient %s node %d refuses the connection:%s",slf.Addr,peer.NodeId,peer.Err)
+		return false
+	}
+	local := newHandshakeInfo(slf.LocalNodeId)
+	local.Chunked = slf.ChunkedMsg
+	if err = local.checkCodec(peer);err == nil {
+		err = checkChunked(local,peer)
+	}
+	if err != nil {
+		log.Error("rpcClient %s handshake is fail,%+v",slf.Addr,err)
+		return false
+	}
+
+	msg,err = slf.conn.ReadMsg()
+	if err != nil {
+		log.Error("rpcClient %s read method set error:%+v",slf.Addr,err)
+		return false
+	}
+	mapMethodSet := map[string][]string{}
+	err = json.Unmarshal(msg,&mapMethodSet)
+	if err != nil {
+		log.Error("rpcClient %s unmarshal method set error:%+v",slf.Addr,err)
+		return false
+	}
+
+	var errList []string
+	if err = local.checkVersion(peer);err != nil {
+		errList = append(errList,err.Error())
+	}
+	for _,diff := range diffMethodSet(getRpcMethodSet(),mapMethodSet) {
+		errList = append(errList,fmt.Sprintf("service %s on node %d",diff,peer.NodeId))
+	}
+	if len(errList) > 0 {
+		log.Error("rpcClient %s node %d is incompatible:%s",slf.Addr,peer.NodeId,strings.Join(errList,";"))
+		if handshakePolicy == HP_Reject {
+			return false
+		}
+	}
+
+	//被调用方同意后才压缩,旧版本的被调用方不回应该字段
+	if slf.CompressType != CompressNone && peer.Compress != slf.CompressType {
+		log.Release("rpcClient %s node %d does not support compress type %d,send uncompressed.",slf.Addr,peer.NodeId,slf.CompressType)
+	}else{
+		atomic.StoreUint32(&slf.compressType,peer.Compress)
+	}
+
+	slf.setPeerMethodSet(peer.Version,mapMethodSet)
+	atomic.StoreInt32(&slf.handshakeDone,1)
+	return true
+}
+
+func (slf *Client) setPeerMethodSet(version string,mapMethodSet map[string][]string) {
+	var mapMethod map[string]map[string]bool
+	if mapMethodSet != nil {
+		mapMethod = make(map[string]map[string]bool,len(mapMethodSet))
+		for serviceName,methodList := range mapMethodSet {
+			mapMethod[serviceName] = make(map[string]bool,len(methodList))
+			for _,method := range methodList {
+				mapMethod[serviceName][method] = true
+			}
+		}
+	}
+
+	slf.methodSetLocker.Lock()
+	slf.peerVersion = version
+	slf.mapPeerMethod = mapMethod
+	slf.methodSetLocker.Unlock()
+}
+
+//被调用方握手时有该服务但没有该方法时返回错误,不需要等到调用返回。握手后安装的服务不检查
+func (slf *Client) checkPeerMethod(serviceMethod string) error {
+	idx := strings.Index(serviceMethod,".")
+	if idx < 0 {
+		return nil
+	}
+
+	slf.methodSetLocker.RLock()
+	defer slf.methodSetLocker.RUnlock()
+	mapMethod,ok := slf.mapPeerMethod[serviceMethod[:idx]]
+	if ok == false || mapMethod[serviceMethod[idx+1:]] == true {
+		return nil
+	}
+
+	return fmt.Errorf("call %s is fail,node %d(version %s) has no method %s",serviceMethod,slf.NodeId,slf.peerVersion,serviceMethod)
+}
+
+//被调用方:处理连接上的第一条消息,是握手时返回true;不是握手时为旧版本或关闭了握手的调用方,由调用者继续按请求处理
+func (agent *RpcAgent) handleHandshake(msg []byte) (isHandshake bool,ok bool) {
+	peer,err := unmarshalHandshake(msg)
+	if err != nil {
+		log.Error("rpc agent %s unmarshal handshake error:%+v",agent.conn.RemoteAddr(),err)
+		return true,false
+	}
+	if peer == nil {
+		log.Debug("rpc agent %s does not send handshake.",agent.conn.RemoteAddr())
+		return false,true
+	}
+
+	local := newHandshakeInfo(agent.rpcserver.nodeId)
+	local.Chunked = agent.rpcserver.maxMsgLen > math.MaxUint16
+	//不支持调用方指定的压缩算法时回应CompressNone,调用方不再压缩
+	if isCompressSupported(peer.Compress) == true {
+		local.Compress = peer.Compress
+	}else if peer.Compress != CompressNone {
+		log.Error("rpc agent %s node %d compress type %d is not supported.",agent.conn.RemoteAddr(),peer.NodeId,peer.Compress)
+	}
+	err = local.checkCodec(peer)
+	if err == nil {
+		err = checkChunked(peer,local)
+	}
+	if err == nil && handshakePolicy == HP_Reject {
+		err = local.checkVersion(peer)
+	}
+	if err != nil {
+		log.Error("rpc agent %s node %d handshake is fail,%+v",agent.conn.RemoteAddr(),peer.NodeId,err)
+		local.Err = err.Error()
+	}else if verErr := local.checkVersion(peer);verErr != nil && handshakePolicy != HP_Off {
+		log.Error("rpc agent %s node %d is incompatible:%+v",agent.conn.RemoteAddr(),peer.NodeId,verErr)
+	}
+
+	reply,mErr := marshalHandshake(local)
+	if mErr != nil {
+		log.Error("rpc agent %s marshal handshake error:%+v",agent.conn.RemoteAddr(),mErr)
+		return true,false
+	}
+	if err != nil {
+		agent.conn.WriteMsg(reply)
+		return true,false
+	}
+	agent.peerNodeId = peer.NodeId
+	agent.peerAdmin = agent.rpcserver.checkAdminToken(peer.AdminToken)
+	//调用方发送握手后即按拆包读写,回应握手前开启
+	agent.conn.SetChunked(peer.Chunked)
+	agent.conn.WriteMsg(reply)
+
+	methodSet,mErr := json.Marshal(getRpcMethodSet())
+	if mErr == nil && uint32(len(methodSet)) > agent.conn.GetMaxMsgLen() {
+		log.Error("rpc agent %s method set length %d exceeds max message length,send empty method set.",agent.conn.RemoteAddr(),len(methodSet))
+		methodSet = []byte("{}")
+	}
+	if mErr != nil {
+		log.Error("rpc agent %s marshal method set error:%+v",agent.conn.RemoteAddr(),mErr)
+		return true,false
+	}
+	agent.conn.WriteMsg(methodSet)
+
+	return true,true
+}
@@ -0,0 +1,340 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/duanhf2012/origin/network"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandshakeMsg(t *testing.T) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:],handshakeMsgLen)
+	if binary.BigEndian.Uint16(b[:]) != handshakeMsgLen {
+		t.Fatal("handshake length must be the same in both byte orders")
+	}
+
+	local := &handshakeInfo{NodeId:1,Version:"1.0.0",Processor:"*rpc.JsonProcessor",LittleEndian:true}
+	msg,err := marshalHandshake(local)
+	if err != nil || len(msg) != handshakeMsgLen {
+		t.Fatalf("marshal handshake error:%+v",err)
+	}
+	peer,err := unmarshalHandshake(msg)
+	if err != nil || peer == nil || *peer != *local {
+		t.Fatalf("unmarshal handshake error:%+v %+v",peer,err)
+	}
+	if req,_ := unmarshalHandshake([]byte(`{"Seq":1}`));req != nil {
+		t.Fatal("request must not be a handshake")
+	}
+
+	peer.LittleEndian = false
+	if local.checkCodec(peer) == nil {
+		t.Fatal("different byte order must be incompatible")
+	}
+	peer.LittleEndian = true
+	peer.Version = "1.0.1"
+	if local.checkCodec(peer) != nil || local.checkVersion(peer) == nil {
+		t.Fatal("different version must only fail checkVersion")
+	}
+}
+
+func TestPeerMethodSet(t *testing.T) {
+	local := map[string][]string{"TestService":{"RPC_A","RPC_B"},"LocalService":{"RPC_C"}}
+	peer := map[string][]string{"TestService":{"RPC_A","RPC_D"},"PeerService":{"RPC_E"}}
+	diffList := diffMethodSet(local,peer)
+	if len(diffList) != 1 || diffList[0] != "TestService missing [RPC_B] extra [RPC_D]" {
+		t.Fatalf("diff method set is error:%v",diffList)
+	}
+
+	client := &Client{NodeId:2}
+	client.setPeerMethodSet("1.0.1",peer)
+	if client.checkPeerMethod("TestService.RPC_A") != nil || client.checkPeerMethod("OtherService.RPC_A") != nil {
+		t.Fatal("method in peer or service unknown to peer must pass")
+	}
+	if client.checkPeerMethod("TestService.RPC_B") == nil {
+		t.Fatal("method missing in peer must fail")
+	}
+
+	client.setPeerMethodSet("",nil)
+	if client.checkPeerMethod("TestService.RPC_B") != nil {
+		t.Fatal("peer without handshake must not be checked")
+	}
+}
+
+type testPeerHandler struct {
+	RpcHandler
+}
+
+func (slf *testPeerHandler) GetName() string {
+	return "TestPeerService"
+}
+
+func (slf *testPeerHandler) RPC_Echo(req *string,ret *string) error {
+	*ret = *req
+	return nil
+}
+
+func (slf *testPeerHandler) RPC_Admin(req *string,ret *string) error {
+	*ret = *req
+	return nil
+}
+
+type testPeerFinder struct {
+	rpcHandler IRpcHandler
+}
+
+func (slf *testPeerFinder) FindRpcHandler(serviceName string) IRpcHandler {
+	return slf.rpcHandler
+}
+
+//模拟旧版本被调用方:不认识握手,收到无法解析的消息时断开连接
+type testOldServerAgent struct {
+	conn *network.TCPConn
+	firstMsg chan []byte
+}
+
+func (slf *testOldServerAgent) Run() {
+	for {
+		data,err := slf.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		select {
+		case slf.firstMsg <- data:
+		default:
+		}
+
+		req := processor.MakeRpcRequest(0,"",false,nil,nil,0,nil,0)
+		if processor.Unmarshal(data,req) != nil || req.GetSeq() == 0 {
+			return
+		}
+		bytes,_ := processor.Marshal(processor.MakeRpcResponse(req.GetSeq(),nil,nil,0))
+		slf.conn.WriteMsg(bytes)
+	}
+}
+
+func (slf *testOldServerAgent) OnClose() {
+}
+
+func getTestAddr(t *testing.T) string {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func startTestPeerServer(t *testing.T,maxMsgLen uint32) (*Server,string) {
+	rpcHandler := &testPeerHandler{}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	go func() {
+		for req := range rpcHandler.GetRpcRequestChan() {
+			rpcHandler.HandlerRpcRequest(req)
+		}
+	}()
+
+	addr := getTestAddr(t)
+	server := &Server{}
+	server.Init(&testPeerFinder{rpcHandler:rpcHandler})
+	server.SetNodeId(1)
+	server.Start(addr,maxMsgLen,0)
+	return server,addr
+}
+
+func waitTestPeer(client *Client,addr string,peerRpcVersion int,maxMsgLen uint32) bool {
+	client.NodeId = 1
+	client.LocalNodeId = 2
+	client.PeerRpcVersion = peerRpcVersion
+	client.MaxMsgLen = maxMsgLen
+	client.Connect(addr)
+	for i:=0;i<100 && client.IsConnected() == false;i++ {
+		time.Sleep(10*time.Millisecond)
+	}
+	return client.IsConnected()
+}
+
+func connectTestPeer(t *testing.T,addr string,peerRpcVersion int) *Client {
+	client := &Client{}
+	if waitTestPeer(client,addr,peerRpcVersion,0) == false {
+		t.Fatalf("client to %s with RpcVersion %d must be connected",addr,peerRpcVersion)
+	}
+	return client
+}
+
+func TestHandshakeOldPeer(t *testing.T) {
+	SetRpcMethodSetFun(func() map[string][]string {
+		return map[string][]string{"TestPeerService":{"RPC_Echo"}}
+	})
+	defer SetRpcMethodSetFun(nil)
+
+	//新版本调用方不向旧版本被调用方发送握手
+	oldServer := &network.TCPServer{Addr:getTestAddr(t),LenMsgLen:2,MinMsgLen:2,MaxMsgLen:Default_MaxRpcMsgLen,MaxConnNum:10,PendingWriteNum:100,LittleEndian:LittleEndian}
+	firstMsg := make(chan []byte,1)
+	oldServer.NewAgent = func(conn *network.TCPConn) network.Agent {
+		return &testOldServerAgent{conn:conn,firstMsg:firstMsg}
+	}
+	oldServer.Start()
+	defer oldServer.Close()
+	client := connectTestPeer(t,oldServer.Addr,0)
+	defer client.Close()
+	if call := client.Go(false,"TestPeerService.RPC_Echo",&[]string{"old"},nil).Done();call.Err != nil {
+		t.Fatalf("call old server is fail:%+v",call.Err)
+	}
+	if info,_ := unmarshalHandshake(<-firstMsg);info != nil {
+		t.Fatal("handshake must not be sent to old server")
+	}
+
+	//新版本被调用方按请求处理旧版本调用方的第一条消息
+	server,addr := startTestPeerServer(t,0)
+	defer server.Stop()
+	oldClient := connectTestPeer(t,addr,0)
+	defer oldClient.Close()
+	var reply string
+	if call := oldClient.Go(false,"TestPeerService.RPC_Echo","old",&reply).Done();call.Err != nil || reply != "old" {
+		t.Fatalf("old client call is fail:%+v %s",call.Err,reply)
+	}
+
+	//新版本之间握手并交换方法集
+	newClient := connectTestPeer(t,addr,RpcVersion)
+	defer newClient.Close()
+	if call := newClient.Go(false,"TestPeerService.RPC_Echo","new",&reply).Done();call.Err != nil || reply != "new" {
+		t.Fatalf("new client call is fail:%+v %s",call.Err,reply)
+	}
+	if newClient.checkPeerMethod("TestPeerService.RPC_Missing") == nil {
+		t.Fatal("method set must be exchanged by handshake")
+	}
+}
+
+func TestHandshakeChunked(t *testing.T) {
+	const maxMsgLen = 1024*1024
+	server,addr := startTestPeerServer(t,maxMsgLen)
+	defer server.Stop()
+
+	//握手协商后拆包发送,包括长度字段最大值的边界
+	client := &Client{}
+	if waitTestPeer(client,addr,RpcVersion,maxMsgLen) == false {
+		t.Fatal("chunked client must be connected")
+	}
+	defer client.Close()
+	for _,argLen := range []int{math.MaxUint16-100,math.MaxUint16,300000} {
+		arg := strings.Repeat("a",argLen)
+		var reply string
+		if call := client.Go(false,"TestPeerService.RPC_Echo",arg,&reply).Done();call.Err != nil || reply != arg {
+			t.Fatalf("chunked call with length %d is fail:%+v",argLen,call.Err)
+		}
+	}
+
+	//旧版本调用方没有握手,不能拆包
+	oldClient := &Client{}
+	if waitTestPeer(oldClient,addr,0,maxMsgLen) == false {
+		t.Fatal("old client must be connected")
+	}
+	defer oldClient.Close()
+	if call := oldClient.Go(false,"TestPeerService.RPC_Echo",strings.Repeat("a",300000),nil);call.Err == nil {
+		t.Fatal("old client must not send chunked message")
+	}
+
+	//被调用方不支持拆包时拒绝连接
+	plainServer,plainAddr := startTestPeerServer(t,0)
+	defer plainServer.Stop()
+	mismatchClient := &Client{}
+	defer mismatchClient.Close()
+	if waitTestPeer(mismatchClient,plainAddr,RpcVersion,maxMsgLen) == true {
+		t.Fatal("chunked client must be refused by the server without chunked")
+	}
+}
+
+func TestRequestFilter(t *testing.T) {
+	SetRequestFilter(func(peerNodeId int,peerAdmin bool,serviceMethod string) error {
+		if peerNodeId != 2 {
+			return fmt.Errorf("node %d is not allowed to call %s",peerNodeId,serviceMethod)
+		}
+		if strings.HasSuffix(serviceMethod,"RPC_Admin") == true && peerAdmin == false {
+			return fmt.Errorf("node %d has no admin token",peerNodeId)
+		}
+		return nil
+	})
+	defer SetRequestFilter(nil)
+	server,addr := startTestPeerServer(t,0)
+	defer server.Stop()
+	server.SetAdminToken("secret")
+
+	//握手后按调用方的NodeId过滤
+	client := connectTestPeer(t,addr,RpcVersion)
+	defer client.Close()
+	var reply string
+	if call := client.Go(false,"TestPeerService.RPC_Echo","allowed",&reply).Done();call.Err != nil || reply != "allowed" {
+		t.Fatalf("call from node 2 is fail:%+v",call.Err)
+	}
+
+	//口令不一致时不能调用运维rpc
+	for _,token := range []string{"","secre","wrong!"} {
+		tokenClient := &Client{AdminToken:token}
+		if waitTestPeer(tokenClient,addr,RpcVersion,0) == false {
+			t.Fatalf("client with token %s must be connected",token)
+		}
+		call := tokenClient.Go(false,"TestPeerService.RPC_Admin","denied",&reply).Done()
+		tokenClient.Close()
+		if call.Err == nil || strings.Contains(call.Err.Error(),"admin token") == false {
+			t.Fatalf("call with token %s must be refused by the filter:%+v",token,call.Err)
+		}
+	}
+	adminClient := &Client{AdminToken:"secret"}
+	if waitTestPeer(adminClient,addr,RpcVersion,0) == false {
+		t.Fatal("admin client must be connected")
+	}
+	defer adminClient.Close()
+	if call := adminClient.Go(false,"TestPeerService.RPC_Admin","admin",&reply).Done();call.Err != nil || reply != "admin" {
+		t.Fatalf("call with admin token is fail:%+v",call.Err)
+	}
+
+	//没有握手的调用方NodeId为0
+	oldClient := connectTestPeer(t,addr,0)
+	defer oldClient.Close()
+	if call := oldClient.Go(false,"TestPeerService.RPC_Echo","denied",&reply).Done();call.Err == nil {
+		t.Fatal("call without handshake must be refused by the filter")
+	}
+}
+
+func TestHandshakeCompress(t *testing.T) {
+	server,addr := startTestPeerServer(t,0)
+	defer server.Stop()
+
+	//握手时被调用方支持该算法才压缩,不支持或没有握手时不压缩
+	arg := strings.Repeat("abcd",10000)
+	for _,c := range []struct{
+		compressType uint32
+		peerRpcVersion int
+		expect uint32
+	}{
+		{CompressSnappy,RpcVersion,CompressSnappy},
+		{CompressGzip,RpcVersion,CompressGzip},
+		{CompressZstd,RpcVersion,CompressZstd},
+		{99,RpcVersion,CompressNone},
+		{CompressZstd,0,CompressNone},
+	} {
+		client := &Client{CompressType:c.compressType}
+		if waitTestPeer(client,addr,c.peerRpcVersion,0) == false {
+			t.Fatalf("client with compress type %d must be connected",c.compressType)
+		}
+		if compressType := atomic.LoadUint32(&client.compressType);compressType != c.expect {
+			t.Fatalf("compress type %d is negotiated as %d,expect %d",c.compressType,compressType,c.expect)
+		}
+
+		var reply string
+		call := client.Go(false,"TestPeerService.RPC_Echo",arg,&reply)
+		if call.Err == nil {
+			call.Done()
+		}
+		if call.Err != nil || reply != arg {
+			t.Fatalf("call with compress type %d is fail:%+v",c.compressType,call.Err)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"github.com/duanhf2012/origin/trace"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//一次rpc调用的信息,客户端与服务端的拦截器共用
+type Invocation struct {
+	ServiceMethod string
+	NodeId int                 //客户端为被调用的结点,广播时为0;服务端为0
+	Args interface{}
+	Reply interface{}          //AfterCall时有效,不需要返回的调用为nil
+	AdditionParam interface{}
+	Header map[string]string   //客户端拦截器设置的请求头会随请求发送给服务端
+	Err error                  //AfterCall时有效,服务端拦截器修改后将作为调用结果返回
+	StartTime time.Time
+	Latency time.Duration      //AfterCall时有效
+
+	traceContext *trace.Context //发起调用或被调用服务的trace上下文
+	traceSpan *trace.Span
+	traceParent trace.SpanContext
+}
+
+func (slf *Invocation) SetHeader(key string,value string) {
+	if slf.Header == nil {
+		slf.Header = map[string]string{}
+	}
+	slf.Header[key] = value
+}
+
+func (slf *Invocation) GetHeader(key string) string {
+	return slf.Header[key]
+}
+
+//rpc拦截器,客户端在发出调用时执行,服务端在调用RPC函数时执行
+type IRpcInterceptor interface {
+	//调用前执行,返回错误时不再执行后续拦截器与调用,并以该错误作为调用结果,可以返回rpc.Errorf(...)
+	BeforeCall(inv *Invocation) error
+	//调用结束后按注册的逆序执行,只执行BeforeCall已执行过的拦截器
+	AfterCall(inv *Invocation)
+}
+
+var globalClientInterceptor []IRpcInterceptor
+var globalServerInterceptor []IRpcInterceptor
+//拦截器列表只整体替换,调用中取到的列表不会再被修改
+var interceptorLocker sync.RWMutex
+
+//返回添加了interceptor的新列表,不修改原列表
+func appendInterceptor(interceptorList []IRpcInterceptor,interceptor IRpcInterceptor) []IRpcInterceptor {
+	newList := make([]IRpcInterceptor,len(interceptorList),len(interceptorList)+1)
+	copy(newList,interceptorList)
+	return append(newList,interceptor)
+}
+
+//添加对所有服务生效的客户端拦截器,建议在结点启动前添加
+func AddClientInterceptor(interceptor IRpcInterceptor) {
+	interceptorLocker.Lock()
+	globalClientInterceptor = appendInterceptor(globalClientInterceptor,interceptor)
+	interceptorLocker.Unlock()
+}
+
+//添加对所有服务生效的服务端拦截器,建议在结点启动前添加
+func AddServerInterceptor(interceptor IRpcInterceptor) {
+	interceptorLocker.Lock()
+	globalServerInterceptor = appendInterceptor(globalServerInterceptor,interceptor)
+	interceptorLocker.Unlock()
+}
+
+//添加只对本服务发出的调用生效的拦截器,在全局拦截器之后执行,建议在OnInit中添加
+func (slf *RpcHandler) AddClientInterceptor(interceptor IRpcInterceptor) {
+	interceptorLocker.Lock()
+	slf.clientInterceptor = appendInterceptor(slf.clientInterceptor,interceptor)
+	interceptorLocker.Unlock()
+}
+
+//添加只对本服务RPC函数生效的拦截器,在全局拦截器之后执行,建议在OnInit中添加
+func (slf *RpcHandler) AddServerInterceptor(interceptor IRpcInterceptor) {
+	interceptorLocker.Lock()
+	slf.serverInterceptor = appendInterceptor(slf.serverInterceptor,interceptor)
+	interceptorLocker.Unlock()
+}
+
+//依次执行的拦截器
+type interceptorChain struct {
+	global []IRpcInterceptor
+	local []IRpcInterceptor
+	done int //已执行BeforeCall的数量
+	traceContext *trace.Context
+}
+
+func (slf *interceptorChain) isEmpty() bool {
+	return len(slf.global) == 0 && len(slf.local) == 0
+}
+
+func (slf *interceptorChain) get(i int) IRpcInterceptor {
+	if i < len(slf.global) {
+		return slf.global[i]
+	}
+
+	return slf.local[i-len(slf.global)]
+}
+
+func (slf *interceptorChain) before(inv *Invocation) error {
+	inv.StartTime = time.Now()
+	inv.traceContext = slf.traceContext
+	for slf.done < len(slf.global)+len(slf.local) {
+		err := slf.get(slf.done).BeforeCall(inv)
+		slf.done++
+		if isNilError(err) == false {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (slf *interceptorChain) after(inv *Invocation,err error) error {
+	if isNilError(err) == false {
+		inv.Err = err
+	}
+	inv.Latency = time.Since(inv.StartTime)
+	for i:=slf.done-1;i>=0;i-- {
+		slf.get(i).AfterCall(inv)
+	}
+
+	if isNilError(inv.Err) {
+		return nil
+	}
+	return inv.Err
+}
+
+func (slf *RpcHandler) newClientChain() *interceptorChain {
+	interceptorLocker.RLock()
+	defer interceptorLocker.RUnlock()
+	return &interceptorChain{global:globalClientInterceptor,local:slf.clientInterceptor,traceContext:&slf.traceContext}
+}
+
+func (slf *RpcHandler) newServerChain() *interceptorChain {
+	interceptorLocker.RLock()
+	defer interceptorLocker.RUnlock()
+	return &interceptorChain{global:globalServerInterceptor,local:slf.serverInterceptor,traceContext:&slf.traceContext}
+}
+
+//包装异步调用的回调,在回调前执行AfterCall
+func (slf *interceptorChain) wrapCallback(inv *Invocation,fVal reflect.Value) reflect.Value {
+	return reflect.MakeFunc(fVal.Type(),func(args []reflect.Value) []reflect.Value {
+		inv.Reply = args[0].Interface()
+		var err error
+		if args[1].IsNil() == false {
+			err = args[1].Interface().(error)
+		}
+
+		err = slf.after(inv,err)
+		if err == nil {
+			return fVal.Call([]reflect.Value{args[0],NilError})
+		}
+		return fVal.Call([]reflect.Value{args[0],reflect.ValueOf(&err).Elem()})
+	})
+}
+
+//*RpcError(nil)作为error时不为nil
+func isNilError(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	rpcErr,ok := err.(*RpcError)
+	return ok == true && rpcErr == nil
+}
@@ -18,6 +18,9 @@ type JsonRpcRequestData struct {
 	//packbody
 	InParam []byte
 	AdditionParam interface{}
+	Timeout int64          //调用方剩余的超时时间(毫秒),0表示不限制
+	Header map[string]string //拦截器等附加的请求头
+	Flag uint32            //FlagCompressed等标记位
 }
 
 
@@ -28,6 +31,7 @@ type JsonRpcResponseData struct {
 
 	//returns
 	Reply []byte
+	Flag uint32
 }
 
 
@@ -55,21 +59,25 @@ func (slf *JsonProcessor) Unmarshal(data []byte, v interface{}) error{
 }
 
 
-func (slf *JsonProcessor) MakeRpcRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,additionParam interface{}) IRpcRequestData{
+func (slf *JsonProcessor) MakeRpcRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,additionParam interface{},timeout int64,header map[string]string,flag uint32) IRpcRequestData{
 	jsonRpcRequestData := rpcJsonRequestDataPool.Get().(*JsonRpcRequestData)
 	jsonRpcRequestData.Seq = seq
 	jsonRpcRequestData.ServiceMethod = serviceMethod
 	jsonRpcRequestData.NoReply = noReply
 	jsonRpcRequestData.InParam = inParam
 	jsonRpcRequestData.AdditionParam = additionParam
+	jsonRpcRequestData.Timeout = timeout
+	jsonRpcRequestData.Header = header
+	jsonRpcRequestData.Flag = flag
 	return jsonRpcRequestData
 }
 
-func (slf *JsonProcessor) MakeRpcResponse(seq uint64,err *RpcError,reply []byte) IRpcResponseData {
+func (slf *JsonProcessor) MakeRpcResponse(seq uint64,err *RpcError,reply []byte,flag uint32) IRpcResponseData {
 	jsonRpcResponseData := rpcJsonResponeDataPool.Get().(*JsonRpcResponseData)
 	jsonRpcResponseData.Seq = seq
 	jsonRpcResponseData.Err = err.Error()
 	jsonRpcResponseData.Reply = reply
+	jsonRpcResponseData.Flag = flag
 	return jsonRpcResponseData
 }
 
@@ -105,6 +113,18 @@ func (slf *JsonRpcRequestData) GetAdditionParams() IRawAdditionParam{
 	return slf
 }
 
+func (slf *JsonRpcRequestData) GetTimeout() int64{
+	return slf.Timeout
+}
+
+func (slf *JsonRpcRequestData) GetHeader() map[string]string{
+	return slf.Header
+}
+
+func (slf *JsonRpcRequestData) GetFlag() uint32{
+	return slf.Flag
+}
+
 
 func (slf *JsonRpcResponseData)	GetSeq() uint64 {
 	return slf.Seq
@@ -123,6 +143,10 @@ func (slf *JsonRpcResponseData)		GetReply() []byte{
 	return slf.Reply
 }
 
+func (slf *JsonRpcResponseData)		GetFlag() uint32{
+	return slf.Flag
+}
+
 
 
 
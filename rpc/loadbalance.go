@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//服务部署在多个结点时Call、AsyncCall与Go选择结点的方式
+type LoadBalanceType int
+
+const (
+	LB_Default        LoadBalanceType = 0 //使用service.json中配置的方式,未配置时为LB_RoundRobin
+	LB_RoundRobin     LoadBalanceType = 1 //轮询
+	LB_Random         LoadBalanceType = 2 //随机
+	LB_LeastPending   LoadBalanceType = 3 //未返回调用最少的结点
+	LB_ConsistentHash LoadBalanceType = 4 //一致性哈希,需要通过WithHashKey指定key,相同的key总是调用同一结点
+)
+
+var mapLoadBalanceName = map[string]LoadBalanceType{
+	"RoundRobin":LB_RoundRobin,
+	"Random":LB_Random,
+	"LeastPending":LB_LeastPending,
+	"ConsistentHash":LB_ConsistentHash,
+}
+
+var loadBalanceLocker sync.RWMutex
+var mapServiceLoadBalance = map[string]LoadBalanceType{} //map[ServiceName]负载均衡方式
+var mapRoundRobinSeq = map[string]*uint32{}               //map[ServiceName]轮询序号
+
+func ParseLoadBalance(name string) (LoadBalanceType,error) {
+	loadBalance,ok := mapLoadBalanceName[name]
+	if ok == false {
+		return LB_Default,fmt.Errorf("invalid load balance %s",name)
+	}
+
+	return loadBalance,nil
+}
+
+//设置各服务的负载均衡方式,由cluster读取service.json后设置
+func SetServiceLoadBalance(mapLoadBalance map[string]LoadBalanceType) {
+	loadBalanceLocker.Lock()
+	defer loadBalanceLocker.Unlock()
+	mapServiceLoadBalance = mapLoadBalance
+}
+
+func getServiceLoadBalance(serviceName string) LoadBalanceType {
+	loadBalanceLocker.RLock()
+	defer loadBalanceLocker.RUnlock()
+	loadBalance,ok := mapServiceLoadBalance[serviceName]
+	if ok == false {
+		return LB_RoundRobin
+	}
+
+	return loadBalance
+}
+
+func nextRoundRobinSeq(serviceName string) uint32 {
+	loadBalanceLocker.RLock()
+	seq,ok := mapRoundRobinSeq[serviceName]
+	loadBalanceLocker.RUnlock()
+	if ok == false {
+		loadBalanceLocker.Lock()
+		seq,ok = mapRoundRobinSeq[serviceName]
+		if ok == false {
+			seq = new(uint32)
+			mapRoundRobinSeq[serviceName] = seq
+		}
+		loadBalanceLocker.Unlock()
+	}
+
+	return atomic.AddUint32(seq,1)
+}
+
+//从部署了该服务的结点中选择一个,跳过熔断中的结点
+func selectClient(serviceMethod string,clientList []*Client,opt *callOption) (*Client,error) {
+	if len(clientList) == 0 {
+		return nil,fmt.Errorf("Cannot find %s in any node!",serviceMethod)
+	}
+
+	clientList,err := filterBreakerClient(serviceMethod,clientList)
+	if err != nil {
+		return nil,err
+	}
+	pClient,err := selectLoadBalanceClient(serviceMethod,clientList,opt)
+	if err != nil {
+		return nil,err
+	}
+	if err = pClient.acquireBreaker(serviceMethod);err != nil {
+		return nil,err
+	}
+
+	return pClient,nil
+}
+
+//所有结点都熔断时返回BreakerOpenError
+func filterBreakerClient(serviceMethod string,clientList []*Client) ([]*Client,error) {
+	var openClient *Client
+	for _,c := range clientList {
+		if c.isBreakerOpen(serviceMethod) == true {
+			openClient = c
+			break
+		}
+	}
+	if openClient == nil {
+		return clientList,nil
+	}
+
+	availList := make([]*Client,0,len(clientList)-1)
+	for _,c := range clientList {
+		if c.isBreakerOpen(serviceMethod) == false {
+			availList = append(availList,c)
+		}
+	}
+	if len(availList) == 0 {
+		return nil,&BreakerOpenError{NodeId:openClient.NodeId,ServiceName:getServiceName(serviceMethod)}
+	}
+
+	return availList,nil
+}
+
+func selectLoadBalanceClient(serviceMethod string,clientList []*Client,opt *callOption) (*Client,error) {
+
+	if len(clientList) == 1 && opt.loadBalance != LB_ConsistentHash {
+		return clientList[0],nil
+	}
+
+	serviceName := serviceMethod
+	if idx := strings.Index(serviceMethod,".");idx>=0 {
+		serviceName = serviceMethod[:idx]
+	}
+
+	loadBalance := opt.loadBalance
+	if loadBalance == LB_Default {
+		loadBalance = getServiceLoadBalance(serviceName)
+	}
+
+	switch loadBalance {
+	case LB_Random:
+		return clientList[rand.Intn(len(clientList))],nil
+	case LB_LeastPending:
+		//从轮询位置开始查找,未返回调用数相同时依次选择
+		start := int(nextRoundRobinSeq(serviceName))
+		var pClient *Client
+		minPending := 0
+		for i:=0;i<len(clientList);i++ {
+			c := clientList[(start+i)%len(clientList)]
+			pendingNum := c.GetPendingNum()
+			if pClient == nil || pendingNum < minPending {
+				pClient = c
+				minPending = pendingNum
+			}
+		}
+		return pClient,nil
+	case LB_ConsistentHash:
+		if opt.hashKey == "" {
+			return nil,fmt.Errorf("call %s need a hash key!",serviceMethod)
+		}
+		return hashClient(opt.hashKey,clientList),nil
+	default:
+		return clientList[int(nextRoundRobinSeq(serviceName))%len(clientList)],nil
+	}
+}
+
+//按最高随机权重(rendezvous)哈希选择,结点增减时只影响该结点上的key
+func hashClient(key string,clientList []*Client) *Client {
+	var pClient *Client
+	var maxWeight uint64
+	for _,c := range clientList {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(strconv.Itoa(c.NodeId)))
+		weight := h.Sum64()
+		if pClient == nil || weight > maxWeight {
+			pClient = c
+			maxWeight = weight
+		}
+	}
+
+	return pClient
+}
@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"bytes"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+)
+
+//MessagePack格式,参数可以是普通的结构体,没有msgpack标签的字段按json标签命名
+type MsgPackProcessor struct {
+}
+
+type MsgPackRpcRequestData struct {
+	//packhead
+	Seq uint64             // sequence number chosen by client
+	ServiceMethod string   // format: "Service.Method"
+	NoReply bool           //是否需要返回
+	//packbody
+	InParam []byte
+	AdditionParam interface{}
+	Timeout int64          //调用方剩余的超时时间(毫秒),0表示不限制
+	Header map[string]string //拦截器等附加的请求头
+	Flag uint32            //FlagCompressed等标记位
+}
+
+type MsgPackRpcResponseData struct {
+	//head
+	Seq           uint64   // sequence number chosen by client
+	Err string
+
+	//returns
+	Reply []byte
+	Flag uint32
+}
+
+var rpcMsgPackResponeDataPool sync.Pool
+var rpcMsgPackRequestDataPool sync.Pool
+
+func init(){
+	rpcMsgPackResponeDataPool.New = func()interface{}{
+		return &MsgPackRpcResponseData{}
+	}
+
+	rpcMsgPackRequestDataPool.New = func()interface{}{
+		return &MsgPackRpcRequestData{}
+	}
+}
+
+func (slf *MsgPackProcessor) Marshal(v interface{}) ([]byte, error){
+	var buff bytes.Buffer
+	enc := msgpack.GetEncoder()
+	enc.Reset(&buff)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	err := enc.Encode(v)
+	msgpack.PutEncoder(enc)
+	if err != nil {
+		return nil,err
+	}
+
+	return buff.Bytes(),nil
+}
+
+func (slf *MsgPackProcessor) Unmarshal(data []byte, v interface{}) error{
+	dec := msgpack.GetDecoder()
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	err := dec.Decode(v)
+	msgpack.PutDecoder(dec)
+	return err
+}
+
+func (slf *MsgPackProcessor) MakeRpcRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,additionParam interface{},timeout int64,header map[string]string,flag uint32) IRpcRequestData{
+	msgPackRpcRequestData := rpcMsgPackRequestDataPool.Get().(*MsgPackRpcRequestData)
+	msgPackRpcRequestData.Seq = seq
+	msgPackRpcRequestData.ServiceMethod = serviceMethod
+	msgPackRpcRequestData.NoReply = noReply
+	msgPackRpcRequestData.InParam = inParam
+	msgPackRpcRequestData.AdditionParam = additionParam
+	msgPackRpcRequestData.Timeout = timeout
+	msgPackRpcRequestData.Header = header
+	msgPackRpcRequestData.Flag = flag
+	return msgPackRpcRequestData
+}
+
+func (slf *MsgPackProcessor) MakeRpcResponse(seq uint64,err *RpcError,reply []byte,flag uint32) IRpcResponseData {
+	msgPackRpcResponseData := rpcMsgPackResponeDataPool.Get().(*MsgPackRpcResponseData)
+	msgPackRpcResponseData.Seq = seq
+	msgPackRpcResponseData.Err = err.Error()
+	msgPackRpcResponseData.Reply = reply
+	msgPackRpcResponseData.Flag = flag
+	return msgPackRpcResponseData
+}
+
+func (slf *MsgPackProcessor) ReleaseRpcRequest(rpcRequestData IRpcRequestData){
+	rpcMsgPackRequestDataPool.Put(rpcRequestData)
+}
+
+func (slf *MsgPackProcessor) ReleaseRpcRespose(rpcRequestData IRpcResponseData){
+	rpcMsgPackResponeDataPool.Put(rpcRequestData)
+}
+
+func (slf *MsgPackRpcRequestData) IsNoReply() bool{
+	return slf.NoReply
+}
+
+func (slf *MsgPackRpcRequestData) GetSeq() uint64{
+	return slf.Seq
+}
+
+func (slf *MsgPackRpcRequestData) GetServiceMethod() string{
+	return slf.ServiceMethod
+}
+
+func (slf *MsgPackRpcRequestData) GetInParam() []byte{
+	return slf.InParam
+}
+
+func (slf *MsgPackRpcRequestData) GetParamValue() interface{}{
+	return slf.AdditionParam
+}
+
+func (slf *MsgPackRpcRequestData) GetAdditionParams() IRawAdditionParam{
+	return slf
+}
+
+func (slf *MsgPackRpcRequestData) GetTimeout() int64{
+	return slf.Timeout
+}
+
+func (slf *MsgPackRpcRequestData) GetHeader() map[string]string{
+	return slf.Header
+}
+
+func (slf *MsgPackRpcRequestData) GetFlag() uint32{
+	return slf.Flag
+}
+
+func (slf *MsgPackRpcResponseData) GetSeq() uint64 {
+	return slf.Seq
+}
+
+func (slf *MsgPackRpcResponseData) GetErr() *RpcError {
+	if slf.Err == ""{
+		return nil
+	}
+
+	return Errorf("%s",slf.Err)
+}
+
+func (slf *MsgPackRpcResponseData) GetReply() []byte{
+	return slf.Reply
+}
+
+func (slf *MsgPackRpcResponseData) GetFlag() uint32{
+	return slf.Flag
+}
@@ -50,12 +50,22 @@ func (m *PBRpcRequestData) GetAdditionParams() IRawAdditionParam{
 	return m
 }
 
-func (slf *PBRpcRequestData) MakeRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,inAdditionParam interface{}) *PBRpcRequestData{
+func (slf *PBRpcRequestData) MakeRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,inAdditionParam interface{},timeout int64,header map[string]string,flag uint32) *PBRpcRequestData{
 	slf.Seq = proto.Uint64(seq)
 	slf.ServiceMethod = proto.String(serviceMethod)
 	slf.NoReply = proto.Bool(noReply)
 	slf.InParam = inParam
+	slf.Timeout = nil
+	if timeout > 0 {
+		slf.Timeout = proto.Int64(timeout)
+	}
+	slf.Header = header
+	slf.Flag = nil
+	if flag != 0 {
+		slf.Flag = proto.Uint32(flag)
+	}
 
+	slf.AddtionParam = nil
 	if inAdditionParam == nil {
 		return slf
 	}
@@ -88,12 +98,17 @@ func (slf *PBRpcRequestData) MakeRequest(seq uint64,serviceMethod string,noReply
 	return slf
 }
 
-func (slf *PBRpcResponseData) MakeRespone(seq uint64,err *RpcError,reply []byte) *PBRpcResponseData{
+func (slf *PBRpcResponseData) MakeRespone(seq uint64,err *RpcError,reply []byte,flag uint32) *PBRpcResponseData{
 	slf.Seq = proto.Uint64(seq)
+	slf.Error = nil
 	if err != nil {
 		slf.Error = proto.String(err.Error())
 	}
 	slf.Reply = reply
+	slf.Flag = nil
+	if flag != 0 {
+		slf.Flag = proto.Uint32(flag)
+	}
 
 	return slf
 }
@@ -108,15 +123,15 @@ func (slf *PBProcessor) Unmarshal(data []byte, msg interface{}) error{
 }
 
 
-func (slf *PBProcessor) MakeRpcRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,inAdditionParam interface{}) IRpcRequestData{
+func (slf *PBProcessor) MakeRpcRequest(seq uint64,serviceMethod string,noReply bool,inParam []byte,inAdditionParam interface{},timeout int64,header map[string]string,flag uint32) IRpcRequestData{
 	pPbRpcRequestData := rpcPbRequestDataPool.Get().(*PBRpcRequestData)
-	pPbRpcRequestData.MakeRequest(seq,serviceMethod,noReply,inParam,inAdditionParam)
+	pPbRpcRequestData.MakeRequest(seq,serviceMethod,noReply,inParam,inAdditionParam,timeout,header,flag)
 	return pPbRpcRequestData
 }
 
-func (slf *PBProcessor) MakeRpcResponse(seq uint64,err *RpcError,reply []byte) IRpcResponseData {
+func (slf *PBProcessor) MakeRpcResponse(seq uint64,err *RpcError,reply []byte,flag uint32) IRpcResponseData {
 	pPBRpcResponseData := rpcPbResponeDataPool.Get().(*PBRpcResponseData)
-	pPBRpcResponseData.MakeRespone(seq,err,reply)
+	pPBRpcResponseData.MakeRespone(seq,err,reply,flag)
 	return pPBRpcResponseData
 }
 
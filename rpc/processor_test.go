@@ -0,0 +1,177 @@
+package rpc
+
+import (
+	"github.com/golang/protobuf/proto"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+//同时作为普通结构体与protobuf消息,三种格式使用相同的数据比较
+type benchItem struct {
+	Id    int64    `protobuf:"varint,1,opt,name=Id" json:"id"`
+	Name  string   `protobuf:"bytes,2,opt,name=Name" json:"name"`
+	Count int32    `protobuf:"varint,3,opt,name=Count" json:"count"`
+	Tags  []string `protobuf:"bytes,4,rep,name=Tags" json:"tags"`
+}
+
+type benchPayload struct {
+	UserId int64        `protobuf:"varint,1,opt,name=UserId" json:"userId"`
+	Name   string       `protobuf:"bytes,2,opt,name=Name" json:"name"`
+	Score  float64      `protobuf:"fixed64,3,opt,name=Score" json:"score"`
+	Items  []*benchItem `protobuf:"bytes,4,rep,name=Items" json:"items"`
+}
+
+func (m *benchItem) Reset()         { *m = benchItem{} }
+func (m *benchItem) String() string { return proto.CompactTextString(m) }
+func (*benchItem) ProtoMessage()    {}
+
+func (m *benchPayload) Reset()         { *m = benchPayload{} }
+func (m *benchPayload) String() string { return proto.CompactTextString(m) }
+func (*benchPayload) ProtoMessage()    {}
+
+var benchProcessorList = []struct {
+	name string
+	processor IRpcProcessor
+}{
+	{"json",&JsonProcessor{}},
+	{"pb",&PBProcessor{}},
+	{"msgpack",&MsgPackProcessor{}},
+}
+
+//small为单个对象,medium与large为带10个与1000个元素的列表
+var benchPayloadList = []struct {
+	name string
+	payload *benchPayload
+}{
+	{"small",newBenchPayload(0)},
+	{"medium",newBenchPayload(10)},
+	{"large",newBenchPayload(1000)},
+}
+
+func newBenchPayload(itemNum int) *benchPayload {
+	payload := &benchPayload{UserId:10086,Name:"origin",Score:99.5}
+	for i := 0;i < itemNum;i++ {
+		payload.Items = append(payload.Items,&benchItem{Id:int64(i),Name:"item"+strconv.Itoa(i),Count:int32(i%100),Tags:[]string{"a","b"}})
+	}
+
+	return payload
+}
+
+func TestMsgPackProcessor(t *testing.T) {
+	processor := &MsgPackProcessor{}
+	for _,p := range benchPayloadList {
+		data,err := processor.Marshal(p.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload benchPayload
+		if err = processor.Unmarshal(data,&payload);err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(&payload,p.payload) == false {
+			t.Errorf("%s payload mismatch:%+v",p.name,payload)
+		}
+	}
+
+	inParam := []byte{1,2,3}
+	header := map[string]string{"traceparent":"00-1-2-01"}
+	request := processor.MakeRpcRequest(1,"TestService.RPC_Add",true,inParam,nil,500,header,FlagCompressed)
+	data,err := processor.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeRequest := processor.MakeRpcRequest(0,"",false,nil,nil,0,nil,0)
+	if err = processor.Unmarshal(data,decodeRequest);err != nil {
+		t.Fatal(err)
+	}
+	if decodeRequest.GetSeq() != 1 || decodeRequest.GetServiceMethod() != "TestService.RPC_Add" || decodeRequest.IsNoReply() == false ||
+		reflect.DeepEqual(decodeRequest.GetInParam(),inParam) == false || decodeRequest.GetTimeout() != 500 ||
+		reflect.DeepEqual(decodeRequest.GetHeader(),header) == false || decodeRequest.GetFlag() != FlagCompressed {
+		t.Errorf("request mismatch:%+v",decodeRequest)
+	}
+
+	rpcErr := RpcError("fail")
+	response := processor.MakeRpcResponse(2,&rpcErr,nil,0)
+	data,err = processor.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeResponse := processor.MakeRpcResponse(0,nil,nil,0)
+	if err = processor.Unmarshal(data,decodeResponse);err != nil {
+		t.Fatal(err)
+	}
+	if decodeResponse.GetSeq() != 2 || decodeResponse.GetErr() == nil || decodeResponse.GetErr().Error() != "fail" {
+		t.Errorf("response mismatch:%+v",decodeResponse)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for _,proc := range benchProcessorList {
+		for _,p := range benchPayloadList {
+			b.Run(proc.name+"/"+p.name,func(b *testing.B) {
+				data,_ := proc.processor.Marshal(p.payload)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0;i < b.N;i++ {
+					proc.processor.Marshal(p.payload)
+				}
+				b.ReportMetric(float64(len(data)),"bytes/msg")
+			})
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _,proc := range benchProcessorList {
+		for _,p := range benchPayloadList {
+			b.Run(proc.name+"/"+p.name,func(b *testing.B) {
+				data,err := proc.processor.Marshal(p.payload)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0;i < b.N;i++ {
+					var payload benchPayload
+					proc.processor.Unmarshal(data,&payload)
+				}
+			})
+		}
+	}
+}
+
+//一次调用的完整编解码:参数,请求,返回值与返回
+func BenchmarkRoundTrip(b *testing.B) {
+	for _,proc := range benchProcessorList {
+		for _,p := range benchPayloadList {
+			b.Run(proc.name+"/"+p.name,func(b *testing.B) {
+				processor := proc.processor
+				b.ReportAllocs()
+				for i := 0;i < b.N;i++ {
+					inParam,_ := processor.Marshal(p.payload)
+					request := processor.MakeRpcRequest(uint64(i),"TestService.RPC_Add",false,inParam,nil,15000,nil,0)
+					requestData,_ := processor.Marshal(request)
+					processor.ReleaseRpcRequest(request)
+
+					request = processor.MakeRpcRequest(0,"",false,nil,nil,0,nil,0)
+					processor.Unmarshal(requestData,request)
+					var arg benchPayload
+					processor.Unmarshal(request.GetInParam(),&arg)
+					processor.ReleaseRpcRequest(request)
+
+					reply,_ := processor.Marshal(&arg)
+					response := processor.MakeRpcResponse(uint64(i),nil,reply,0)
+					responseData,_ := processor.Marshal(response)
+					processor.ReleaseRpcRespose(response)
+
+					response = processor.MakeRpcResponse(0,nil,nil,0)
+					processor.Unmarshal(responseData,response)
+					var ret benchPayload
+					processor.Unmarshal(response.GetReply(),&ret)
+					processor.ReleaseRpcRespose(response)
+				}
+			})
+		}
+	}
+}
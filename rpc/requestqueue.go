@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//请求队列满时的处理方式
+type OverloadPolicy int
+
+const (
+	OP_Reject     OverloadPolicy = 0 //立即返回队列已满的错误
+	OP_Block      OverloadPolicy = 1 //等待BlockTimeout,仍然没有空位时返回错误
+	OP_DropOldest OverloadPolicy = 2 //丢弃队列中最早的不需要返回的Go调用,没有可丢弃的请求时返回错误
+)
+
+var mapOverloadPolicyName = map[string]OverloadPolicy{
+	"Reject":OP_Reject,
+	"Block":OP_Block,
+	"DropOldest":OP_DropOldest,
+}
+
+//未配置时的队列长度,回调队列满时会阻塞连接的读协程,所以比请求队列大
+var Default_RequestQueueSize = 10000
+var Default_ResponeQueueSize = 100000
+
+//服务的队列配置,在service.json的Queue中配置
+type QueueConfig struct {
+	RequestQueueSize int `validate:"min=0"`                          //rpc请求队列长度,安装服务时生效,为0时使用Default_RequestQueueSize
+	ResponeQueueSize int `validate:"min=0"`                          //异步调用回调队列长度,安装服务时生效,为0时使用Default_ResponeQueueSize
+	Overload string `default:"Reject" validate:"oneof=Reject Block DropOldest"` //请求队列满时的处理方式
+	BlockTimeout time.Duration `default:"100ms"`                    //Overload为Block时的最长等待时间
+	DrainTimeout time.Duration                                         //服务退出时处理剩余队列的最长时间,为0时使用service.Default_DrainTimeout
+
+	overloadPolicy OverloadPolicy
+}
+
+//请求队列的运行状态
+type QueueStats struct {
+	RequestLen int   //请求队列中的请求数量
+	RequestCap int
+	ResponeLen int   //回调队列中的回调数量
+	ResponeCap int
+	RejectNum int64  //队列满返回错误的请求数量,包括Block超时
+	DropNum int64    //DropOldest丢弃的请求数量
+	BlockNum int64   //Block等待过的请求数量
+}
+
+var queueConfigLocker sync.RWMutex
+var mapQueueConfig = map[string]*QueueConfig{} //map[ServiceName]队列配置
+
+func ParseOverloadPolicy(name string) (OverloadPolicy,error) {
+	overloadPolicy,ok := mapOverloadPolicyName[name]
+	if ok == false {
+		return OP_Reject,fmt.Errorf("invalid overload policy %s",name)
+	}
+
+	return overloadPolicy,nil
+}
+
+//设置各服务的队列配置,由cluster读取service.json后设置。队列长度只在安装服务时生效,Overload与BlockTimeout立即生效
+func SetQueueConfig(mapConfig map[string]*QueueConfig) {
+	for _,cfg := range mapConfig {
+		cfg.overloadPolicy,_ = ParseOverloadPolicy(cfg.Overload)
+	}
+
+	queueConfigLocker.Lock()
+	defer queueConfigLocker.Unlock()
+	mapQueueConfig = mapConfig
+}
+
+//未配置时返回默认配置
+func getQueueConfig(serviceName string) *QueueConfig {
+	queueConfigLocker.RLock()
+	defer queueConfigLocker.RUnlock()
+	cfg,ok := mapQueueConfig[serviceName]
+	if ok == false {
+		return &QueueConfig{}
+	}
+
+	return cfg
+}
+
+//service.json中配置的退出时处理剩余队列的最长时间,未配置时返回0
+func (slf *RpcHandler) GetQueueDrainTimeout() time.Duration {
+	return getQueueConfig(slf.rpcHandler.GetName()).DrainTimeout
+}
+
+func (slf *RpcHandler) makeQueue() {
+	cfg := getQueueConfig(slf.rpcHandler.GetName())
+	requestQueueSize := cfg.RequestQueueSize
+	if requestQueueSize <= 0 {
+		requestQueueSize = Default_RequestQueueSize
+	}
+	responeQueueSize := cfg.ResponeQueueSize
+	if responeQueueSize <= 0 {
+		responeQueueSize = Default_ResponeQueueSize
+	}
+
+	slf.callRequest = make(chan *RpcRequest,requestQueueSize)
+	slf.callResponeCallBack = make(chan *Call,responeQueueSize)
+}
+
+func (slf *RpcHandler) PushRequest(req *RpcRequest) error{
+	cfg := getQueueConfig(slf.GetName())
+	if cfg.overloadPolicy == OP_DropOldest {
+		return slf.pushDropOldest(req)
+	}
+
+	select {
+	case slf.callRequest <- req:
+		return nil
+	default:
+	}
+
+	if cfg.overloadPolicy == OP_Block {
+		atomic.AddInt64(&slf.blockNum,1)
+		timer := time.NewTimer(cfg.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case slf.callRequest <- req:
+			return nil
+		case <-timer.C:
+		}
+	}
+
+	return slf.rejectRequest()
+}
+
+//队列满时取出所有请求,丢弃最早的Go调用后按原顺序放回。入队都在dropLocker中进行,放回时不会被其他协程占用空位
+func (slf *RpcHandler) pushDropOldest(req *RpcRequest) error {
+	slf.dropLocker.Lock()
+	defer slf.dropLocker.Unlock()
+	select {
+	case slf.callRequest <- req:
+		return nil
+	default:
+	}
+
+	var dropReq *RpcRequest
+	reqList := make([]*RpcRequest,0,len(slf.callRequest))
+	for bEmpty := false;bEmpty == false; {
+		select {
+		case oldReq := <-slf.callRequest:
+			if dropReq == nil && oldReq.requestHandle == nil && oldReq.RpcRequestData.IsNoReply() == true {
+				dropReq = oldReq
+			}else{
+				reqList = append(reqList,oldReq)
+			}
+		default:
+			bEmpty = true
+		}
+	}
+
+	for _,oldReq := range reqList {
+		slf.callRequest <- oldReq
+	}
+	if dropReq == nil {
+		return slf.rejectRequest()
+	}
+
+	slf.dropRequest(dropReq)
+	slf.callRequest <- req
+	return nil
+}
+
+func (slf *RpcHandler) rejectRequest() error {
+	atomic.AddInt64(&slf.rejectNum,1)
+	return fmt.Errorf("RpcHandler %s Rpc Channel is full.",slf.GetName())
+}
+
+//丢弃不需要返回的Go调用
+func (slf *RpcHandler) dropRequest(req *RpcRequest) {
+	atomic.AddInt64(&slf.dropNum,1)
+	processor.ReleaseRpcRequest(req.RpcRequestData)
+	ReleaseRpcRequest(req)
+}
+
+func (slf *RpcHandler) GetQueueStats() QueueStats {
+	return QueueStats{
+		RequestLen:len(slf.callRequest),
+		RequestCap:cap(slf.callRequest),
+		ResponeLen:len(slf.callResponeCallBack),
+		ResponeCap:cap(slf.callResponeCallBack),
+		RejectNum:atomic.LoadInt64(&slf.rejectNum),
+		DropNum:atomic.LoadInt64(&slf.dropNum),
+		BlockNum:atomic.LoadInt64(&slf.blockNum),
+	}
+}
@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testQueueHandler struct {
+	RpcHandler
+}
+
+func (slf *testQueueHandler) GetName() string {
+	return "TestQueueService"
+}
+
+func newTestQueueHandler(cfg *QueueConfig) *testQueueHandler {
+	SetQueueConfig(map[string]*QueueConfig{"TestQueueService":cfg})
+	rpcHandler := &testQueueHandler{}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	return rpcHandler
+}
+
+func makeTestQueueRequest(noReply bool,requestHandle RequestHandler) *RpcRequest {
+	req := MakeRpcRequest()
+	req.RpcRequestData = processor.MakeRpcRequest(0,"TestQueueService.RPC_Test",noReply,nil,nil,0,nil,0)
+	req.requestHandle = requestHandle
+	return req
+}
+
+func TestPushRequestReject(t *testing.T) {
+	defer SetQueueConfig(map[string]*QueueConfig{})
+	rpcHandler := newTestQueueHandler(&QueueConfig{RequestQueueSize:1,ResponeQueueSize:1,Overload:"Reject"})
+	if rpcHandler.PushRequest(makeTestQueueRequest(true,nil)) != nil {
+		t.Fatal("push request must succeed")
+	}
+	if rpcHandler.PushRequest(makeTestQueueRequest(true,nil)) == nil {
+		t.Fatal("push request must fail when queue is full")
+	}
+
+	stats := rpcHandler.GetQueueStats()
+	if stats.RequestLen != 1 || stats.RequestCap != 1 || stats.RejectNum != 1 {
+		t.Fatalf("queue stats is error:%+v",stats)
+	}
+}
+
+func TestPushRequestBlock(t *testing.T) {
+	defer SetQueueConfig(map[string]*QueueConfig{})
+	rpcHandler := newTestQueueHandler(&QueueConfig{RequestQueueSize:1,ResponeQueueSize:1,Overload:"Block",BlockTimeout:time.Second})
+	rpcHandler.PushRequest(makeTestQueueRequest(true,nil))
+	time.AfterFunc(10*time.Millisecond,func() {
+		<-rpcHandler.GetRpcRequestChan()
+	})
+	if rpcHandler.PushRequest(makeTestQueueRequest(true,nil)) != nil {
+		t.Fatal("push request must succeed after the queue has room")
+	}
+
+	SetQueueConfig(map[string]*QueueConfig{"TestQueueService":{RequestQueueSize:1,ResponeQueueSize:1,Overload:"Block",BlockTimeout:10*time.Millisecond}})
+	if rpcHandler.PushRequest(makeTestQueueRequest(true,nil)) == nil {
+		t.Fatal("push request must fail after BlockTimeout")
+	}
+	stats := rpcHandler.GetQueueStats()
+	if stats.BlockNum != 2 || stats.RejectNum != 1 {
+		t.Fatalf("queue stats is error:%+v",stats)
+	}
+}
+
+func TestPushRequestDropOldest(t *testing.T) {
+	defer SetQueueConfig(map[string]*QueueConfig{})
+	rpcHandler := newTestQueueHandler(&QueueConfig{RequestQueueSize:2,ResponeQueueSize:1,Overload:"DropOldest"})
+	var replyErr *RpcError
+	replyReq := makeTestQueueRequest(false,func(Returns interface{},Err *RpcError) {
+		replyErr = Err
+	})
+	rpcHandler.PushRequest(replyReq)
+	rpcHandler.PushRequest(makeTestQueueRequest(true,nil))
+	newReq := makeTestQueueRequest(false,func(Returns interface{},Err *RpcError) {})
+	if rpcHandler.PushRequest(newReq) != nil {
+		t.Fatal("push request must succeed by dropping the oldest Go request")
+	}
+	if replyErr != nil {
+		t.Fatal("request waiting for reply must not be dropped")
+	}
+	if <-rpcHandler.GetRpcRequestChan() != replyReq || <-rpcHandler.GetRpcRequestChan() != newReq {
+		t.Fatal("queue must keep the order of the remaining requests")
+	}
+
+	rpcHandler.PushRequest(replyReq)
+	rpcHandler.PushRequest(newReq)
+	if rpcHandler.PushRequest(makeTestQueueRequest(true,nil)) == nil {
+		t.Fatal("push request must fail when there is no Go request to drop")
+	}
+	if replyErr != nil || len(rpcHandler.GetRpcRequestChan()) != 2 {
+		t.Fatal("requests waiting for reply must stay in the queue")
+	}
+	if stats := rpcHandler.GetQueueStats();stats.DropNum != 1 || stats.RejectNum != 1 {
+		t.Fatalf("queue stats is error:%+v",stats)
+	}
+}
+
+func TestPostCallbackQueueFull(t *testing.T) {
+	defer SetQueueConfig(map[string]*QueueConfig{})
+	rpcHandler := newTestQueueHandler(&QueueConfig{RequestQueueSize:1,ResponeQueueSize:1})
+	runNum := 0
+	rpcHandler.postCallback("Test",0,func() {
+		runNum++
+	})
+	rpcHandler.postCallback("Test",0,func() {
+		runNum++
+	})
+
+	for i := 0;i < 2;i++ {
+		select {
+		case call := <-rpcHandler.GetRpcResponeChan():
+			call.Reply.(func())()
+		case <-time.After(time.Second):
+			t.Fatal("callback must be posted when the respone queue is full")
+		}
+	}
+	if runNum != 2 {
+		t.Fatalf("callback run %d times",runNum)
+	}
+}
+
+//远程调用的请求队列已满时立即返回错误,不需要等到超时
+func TestRemoteRequestQueueFull(t *testing.T) {
+	defer SetQueueConfig(map[string]*QueueConfig{})
+	rpcHandler := newTestQueueHandler(&QueueConfig{RequestQueueSize:1,ResponeQueueSize:1,Overload:"Reject"})
+	addr := getTestAddr(t)
+	server := &Server{}
+	server.Init(&testPeerFinder{rpcHandler:rpcHandler})
+	server.Start(addr,0,0)
+	defer server.Stop()
+
+	client := connectTestPeer(t,addr,0)
+	defer client.Close()
+	arg := "test"
+	pCall := client.Go(true,"TestQueueService.RPC_Test",&arg,nil)
+	if pCall.Err != nil {
+		t.Fatalf("go is fail:%+v",pCall.Err)
+	}
+	ReleaseCall(pCall)
+
+	var reply string
+	begin := time.Now()
+	pCall = client.Go(false,"TestQueueService.RPC_Test",&arg,&reply).Done()
+	if pCall.Err == nil || strings.Contains(pCall.Err.Error(),"full") == false {
+		t.Fatalf("call must return queue full error:%+v",pCall.Err)
+	}
+	if time.Since(begin) > time.Second {
+		t.Fatalf("queue full error is returned after %s",time.Since(begin))
+	}
+}
@@ -13,8 +13,10 @@ type RpcRequest struct {
 	localReply interface{}
 	localParam interface{} //本地调用的参数列表
 	localRawParam []byte
+	inParam []byte //解压后的参数
 	requestHandle RequestHandler
 	callback *reflect.Value
+	deadline time.Time //调用方的截止时间,超过后不再处理
 }
 
 type RpcResponse struct {
@@ -23,13 +25,22 @@ type RpcResponse struct {
 
 func (slf *RpcRequest) Clear() *RpcRequest{
 	slf.RpcRequestData = nil
+	slf.bLocalRequest = false
 	slf.localReply = nil
 	slf.localParam = nil
+	slf.localRawParam = nil
+	slf.inParam = nil
 	slf.requestHandle = nil
 	slf.callback = nil
+	slf.deadline = time.Time{}
 	return slf
 }
 
+//调用方已超时
+func (slf *RpcRequest) IsTimeout() bool {
+	return slf.deadline.IsZero() == false && time.Now().After(slf.deadline)
+}
+
 func (slf *RpcResponse) Clear() *RpcResponse{
 	slf.RpcResponeData = nil
 	return slf
@@ -45,12 +56,16 @@ type IRpcRequestData interface {
 	GetInParam() []byte
 	IsNoReply() bool
 	GetAdditionParams() IRawAdditionParam
+	GetTimeout() int64
+	GetHeader() map[string]string
+	GetFlag() uint32
 }
 
 type IRpcResponseData interface {
 	GetSeq() uint64
 	GetErr() *RpcError
 	GetReply() []byte
+	GetFlag() uint32
 }
 
 type RequestHandler func(Returns interface{},Err *RpcError)
@@ -75,6 +90,9 @@ type Call struct {
 	callback *reflect.Value
 	rpcHandler IRpcHandler
 	calltime time.Time
+	deadline time.Time       //截止时间,零值表示不限制
+	heapIndex int            //在Client超时堆中的位置,-1表示不在堆中
+	cancelSig chan struct{}  //调用结束时关闭,用于结束WithContext的监听
 }
 
 func (slf *Call) Clear() *Call{
@@ -87,6 +105,9 @@ func (slf *Call) Clear() *Call{
 	slf.connid = 0
 	slf.callback = nil
 	slf.rpcHandler = nil
+	slf.deadline = time.Time{}
+	slf.heapIndex = -1
+	slf.cancelSig = nil
 	return slf
 }
 
@@ -215,14 +215,17 @@ func _AdditionParam_OneofSizer(msg proto.Message) (n int) {
 }
 
 type PBRpcRequestData struct {
-	Seq                  *uint64        `protobuf:"varint,1,opt,name=Seq" json:"Seq,omitempty"`
-	ServiceMethod        *string        `protobuf:"bytes,2,opt,name=ServiceMethod" json:"ServiceMethod,omitempty"`
-	NoReply              *bool          `protobuf:"varint,3,opt,name=NoReply" json:"NoReply,omitempty"`
-	InParam              []byte         `protobuf:"bytes,4,opt,name=InParam" json:"InParam,omitempty"`
-	AddtionParam         *AdditionParam `protobuf:"bytes,5,opt,name=addtionParam" json:"addtionParam,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	Seq                  *uint64           `protobuf:"varint,1,opt,name=Seq" json:"Seq,omitempty"`
+	ServiceMethod        *string           `protobuf:"bytes,2,opt,name=ServiceMethod" json:"ServiceMethod,omitempty"`
+	NoReply              *bool             `protobuf:"varint,3,opt,name=NoReply" json:"NoReply,omitempty"`
+	InParam              []byte            `protobuf:"bytes,4,opt,name=InParam" json:"InParam,omitempty"`
+	AddtionParam         *AdditionParam    `protobuf:"bytes,5,opt,name=addtionParam" json:"addtionParam,omitempty"`
+	Timeout              *int64            `protobuf:"varint,6,opt,name=Timeout" json:"Timeout,omitempty"`
+	Header               map[string]string `protobuf:"bytes,7,rep,name=Header" json:"Header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Flag                 *uint32           `protobuf:"varint,8,opt,name=Flag" json:"Flag,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *PBRpcRequestData) Reset()         { *m = PBRpcRequestData{} }
@@ -285,10 +288,32 @@ func (m *PBRpcRequestData) GetAddtionParam() *AdditionParam {
 	return nil
 }
 
+func (m *PBRpcRequestData) GetTimeout() int64 {
+	if m != nil && m.Timeout != nil {
+		return *m.Timeout
+	}
+	return 0
+}
+
+func (m *PBRpcRequestData) GetHeader() map[string]string {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+func (m *PBRpcRequestData) GetFlag() uint32 {
+	if m != nil && m.Flag != nil {
+		return *m.Flag
+	}
+	return 0
+}
+
 type PBRpcResponseData struct {
 	Seq                  *uint64  `protobuf:"varint,1,opt,name=Seq" json:"Seq,omitempty"`
 	Error                *string  `protobuf:"bytes,2,opt,name=Error" json:"Error,omitempty"`
 	Reply                []byte   `protobuf:"bytes,3,opt,name=Reply" json:"Reply,omitempty"`
+	Flag                 *uint32  `protobuf:"varint,4,opt,name=Flag" json:"Flag,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -340,32 +365,45 @@ func (m *PBRpcResponseData) GetReply() []byte {
 	return nil
 }
 
+func (m *PBRpcResponseData) GetFlag() uint32 {
+	if m != nil && m.Flag != nil {
+		return *m.Flag
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*AdditionParam)(nil), "rpc.AdditionParam")
 	proto.RegisterType((*PBRpcRequestData)(nil), "rpc.PBRpcRequestData")
+	proto.RegisterMapType((map[string]string)(nil), "rpc.PBRpcRequestData.HeaderEntry")
 	proto.RegisterType((*PBRpcResponseData)(nil), "rpc.PBRpcResponseData")
 }
 
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor_77a6da22d6a3feb1) }
 
 var fileDescriptor_77a6da22d6a3feb1 = []byte{
-	// 274 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x90, 0xb1, 0x4e, 0xc3, 0x30,
-	0x10, 0x86, 0x6b, 0xd2, 0x42, 0x7b, 0x4d, 0x50, 0xb0, 0x18, 0x3c, 0x30, 0x58, 0x11, 0x83, 0xa7,
-	0x0e, 0x0c, 0xec, 0x44, 0x20, 0x95, 0x01, 0x54, 0x2e, 0x62, 0x46, 0x56, 0x62, 0x44, 0x24, 0x88,
-	0x5d, 0xc7, 0x20, 0xf1, 0x10, 0xbc, 0x0e, 0xcf, 0x87, 0x0e, 0xa7, 0x45, 0x19, 0xd8, 0xee, 0xbb,
-	0xcf, 0xa7, 0xfb, 0x7d, 0xb0, 0xf0, 0xae, 0x5e, 0x39, 0x6f, 0x83, 0xe5, 0x89, 0x77, 0x75, 0xf1,
-	0xc5, 0x20, 0xbb, 0x6a, 0x9a, 0x36, 0xb4, 0xb6, 0xdb, 0x68, 0xaf, 0xdf, 0xb8, 0x80, 0xc3, 0xea,
-	0xb7, 0x12, 0x20, 0x99, 0x4a, 0xd6, 0x13, 0x1c, 0x98, 0xcc, 0x63, 0x34, 0x4b, 0xc9, 0xd4, 0x94,
-	0x4c, 0x64, 0x7e, 0x06, 0xf3, 0x2a, 0xf8, 0xe8, 0x52, 0xc9, 0xd4, 0x62, 0x3d, 0xc1, 0x7d, 0x87,
-	0xe6, 0xca, 0xe8, 0x32, 0xc9, 0x54, 0x4a, 0x73, 0x91, 0xcb, 0x1c, 0x8e, 0xf5, 0xb0, 0xfc, 0xc9,
-	0x76, 0xc6, 0x3e, 0x17, 0xdf, 0x0c, 0xf2, 0x4d, 0x89, 0xae, 0x46, 0xb3, 0x7d, 0x37, 0x7d, 0xb8,
-	0xd6, 0x41, 0xf3, 0x1c, 0x92, 0xca, 0x6c, 0x05, 0xa3, 0xad, 0x48, 0x25, 0x3f, 0x87, 0xac, 0x32,
-	0xfe, 0xa3, 0xad, 0xcd, 0x9d, 0x09, 0x2f, 0xb6, 0x11, 0x07, 0xb4, 0x15, 0xc7, 0x4d, 0x2e, 0xe0,
-	0xe8, 0xde, 0xa2, 0x71, 0xaf, 0x9f, 0x22, 0x91, 0x4c, 0xcd, 0x71, 0x87, 0x64, 0x6e, 0xe3, 0x7f,
-	0xc5, 0x94, 0x32, 0xe1, 0x0e, 0xf9, 0x25, 0xa4, 0xba, 0x69, 0xf6, 0xe7, 0x10, 0x33, 0xc9, 0xd4,
-	0xf2, 0x82, 0xaf, 0xe8, 0x6e, 0xa3, 0x43, 0xe1, 0xe8, 0x5d, 0xf1, 0x00, 0x27, 0x43, 0xee, 0xde,
-	0xd9, 0xae, 0x37, 0xff, 0x04, 0x3f, 0x85, 0xd9, 0x8d, 0xf7, 0xd6, 0x0f, 0x81, 0x23, 0x50, 0xf7,
-	0x2f, 0x66, 0x8a, 0x11, 0x7e, 0x02, 0x00, 0x00, 0xff, 0xff, 0x00, 0x25, 0x65, 0x17, 0xac, 0x01,
-	0x00, 0x00,
+	// 363 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x90, 0x4f, 0x6f, 0xda, 0x30,
+	0x18, 0xc6, 0x31, 0x09, 0x01, 0xde, 0x24, 0x13, 0xb3, 0x38, 0x58, 0xd3, 0x0e, 0x1e, 0xda, 0xc1,
+	0x27, 0x0e, 0x1c, 0xa6, 0xb1, 0xdb, 0xd0, 0x98, 0xe8, 0xa1, 0x15, 0x72, 0xda, 0x73, 0x65, 0x25,
+	0x6e, 0x1b, 0x15, 0xe2, 0x60, 0x0c, 0x12, 0x1f, 0xa2, 0x5f, 0xb0, 0x9f, 0xa6, 0x72, 0x9c, 0x84,
+	0x52, 0xa9, 0xb7, 0xf7, 0x79, 0x9f, 0xf7, 0xef, 0x0f, 0x86, 0xba, 0x4c, 0xa7, 0xa5, 0x56, 0x46,
+	0x61, 0x4f, 0x97, 0xe9, 0xe4, 0x05, 0x41, 0xfc, 0x37, 0xcb, 0x72, 0x93, 0xab, 0x62, 0x2d, 0xb4,
+	0xd8, 0x62, 0x02, 0x41, 0x52, 0x45, 0x04, 0x28, 0x62, 0xde, 0xaa, 0xc3, 0x83, 0xa4, 0x75, 0xee,
+	0x9c, 0x13, 0x52, 0xc4, 0x7c, 0xeb, 0x38, 0x8d, 0xbf, 0xc3, 0x20, 0x31, 0xda, 0x79, 0x11, 0x45,
+	0x6c, 0xb8, 0xea, 0xf0, 0x36, 0x63, 0xfb, 0x16, 0xce, 0x8b, 0x29, 0x62, 0x91, 0xed, 0x73, 0x7a,
+	0x31, 0x82, 0x2f, 0xa2, 0x5e, 0x7e, 0xaf, 0x0a, 0xa9, 0x1e, 0x26, 0xaf, 0x5d, 0x18, 0xad, 0x17,
+	0xbc, 0x4c, 0xb9, 0xdc, 0x1d, 0xe4, 0xde, 0xfc, 0x13, 0x46, 0xe0, 0x11, 0x78, 0x89, 0xdc, 0x11,
+	0x64, 0xb7, 0x72, 0x1b, 0xe2, 0x9f, 0x10, 0x27, 0x52, 0x1f, 0xf3, 0x54, 0x5e, 0x4b, 0xf3, 0xa4,
+	0x32, 0xd2, 0xb5, 0x5b, 0xf9, 0x65, 0x12, 0x13, 0xe8, 0xdf, 0x28, 0x2e, 0xcb, 0xcd, 0x89, 0x78,
+	0x14, 0xb1, 0x01, 0x6f, 0xa4, 0x75, 0xae, 0xdc, 0xbf, 0xc4, 0xb7, 0x37, 0xf1, 0x46, 0xe2, 0x5f,
+	0x10, 0x89, 0x2c, 0x6b, 0x71, 0x90, 0x1e, 0x45, 0x2c, 0x9c, 0xe1, 0xa9, 0xe5, 0x76, 0x01, 0x8a,
+	0x5f, 0xd4, 0xd9, 0x89, 0xb7, 0xf9, 0x56, 0xaa, 0x83, 0x21, 0x81, 0xe5, 0xc6, 0x1b, 0x89, 0xe7,
+	0x10, 0xac, 0xa4, 0xc8, 0xa4, 0x26, 0x7d, 0xea, 0xb1, 0x70, 0xf6, 0xa3, 0x9a, 0xf5, 0xf1, 0xc9,
+	0xa9, 0xab, 0x59, 0x16, 0x46, 0x9f, 0x78, 0xdd, 0x80, 0x31, 0xf8, 0xff, 0x37, 0xe2, 0x91, 0x0c,
+	0x28, 0x62, 0x31, 0xaf, 0xe2, 0x6f, 0x73, 0x08, 0xdf, 0x95, 0x5a, 0x36, 0xcf, 0xf2, 0x54, 0xb1,
+	0x19, 0x72, 0x1b, 0xe2, 0x31, 0xf4, 0x8e, 0x62, 0x73, 0x90, 0x35, 0x13, 0x27, 0xfe, 0x74, 0x7f,
+	0xa3, 0x89, 0x84, 0xaf, 0xf5, 0xda, 0x7d, 0xa9, 0x8a, 0xbd, 0xfc, 0x04, 0xee, 0x18, 0x7a, 0x4b,
+	0xad, 0x95, 0x6e, 0x06, 0x54, 0xc2, 0x66, 0xcf, 0x28, 0x23, 0xee, 0x44, 0x7b, 0xa1, 0x7f, 0xbe,
+	0xf0, 0x6d, 0x00, 0xd5, 0x0c, 0x35, 0x3d, 0x64, 0x02, 0x00, 0x00,
 }
@@ -3,9 +3,12 @@ package rpc
 import (
 	"fmt"
 	"github.com/duanhf2012/origin/log"
+	"github.com/duanhf2012/origin/trace"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
 )
@@ -56,6 +59,15 @@ type RpcHandler struct {
 	funcRpcServer FuncRpcServer
 
 	callResponeCallBack chan *Call //异步返回的回调
+	pendingAsyncCallNum int32 //已发出未返回的异步调用数量
+	rejectNum int64 //请求队列满返回错误的数量
+	dropNum int64   //请求队列满丢弃的数量
+	blockNum int64  //请求队列满等待的数量
+	dropLocker sync.Mutex //DropOldest时入队互斥,取出的请求可以按原顺序放回
+
+	clientInterceptor []IRpcInterceptor //本服务发出调用的拦截器
+	serverInterceptor []IRpcInterceptor //本服务RPC函数的拦截器
+	traceContext trace.Context //本服务当前的trace上下文
 }
 
 type IRpcHandler interface {
@@ -68,16 +80,23 @@ type IRpcHandler interface {
 
 	GetRpcRequestChan() chan *RpcRequest
 	GetRpcResponeChan() chan *Call
+	GetPendingAsyncCallNum() int32
+	GetQueueStats() QueueStats
+	GetRpcMethodDesc() []MethodDesc
 	CallMethod(ServiceMethod string,param interface{},reply interface{}) error
 	
-	AsyncCall(serviceMethod string,args interface{},callback interface{}) error
-	Call(serviceMethod string,args interface{},reply interface{}) error
-	Go(serviceMethod string,args interface{}) error
-	AsyncCallNode(nodeId int,serviceMethod string,args interface{},callback interface{}) error
-	CallNode(nodeId int,serviceMethod string,args interface{},reply interface{}) error
+	AsyncCall(serviceMethod string,args interface{},callback interface{},opts ...CallOption) error
+	Call(serviceMethod string,args interface{},reply interface{},opts ...CallOption) error
+	Go(serviceMethod string,args interface{},opts ...CallOption) error
+	AsyncCallNode(nodeId int,serviceMethod string,args interface{},callback interface{},opts ...CallOption) error
+	CallNode(nodeId int,serviceMethod string,args interface{},reply interface{},opts ...CallOption) error
 	GoNode(nodeId int,serviceMethod string,args interface{}) error
-	RawGoNode(nodeId int,serviceMethod string,args []byte,additionParam interface{}) error
-	RawCastGo(serviceMethod string,args []byte,additionParam interface{})
+	RawGoNode(nodeId int,serviceMethod string,args []byte,additionParam interface{},opts ...CallOption) error
+	RawCastGo(serviceMethod string,args []byte,additionParam interface{},opts ...CallOption)
+	CastCall(serviceMethod string,args interface{},reply interface{},opts ...CallOption) ([]CastResult,error)
+	AsyncCastCall(serviceMethod string,args interface{},reply interface{},callback func(resultList []CastResult),opts ...CallOption) error
+	AsyncCallFuture(serviceMethod string,args interface{},reply interface{},opts ...CallOption) *Future
+	AsyncCallNodeFuture(nodeId int,serviceMethod string,args interface{},reply interface{},opts ...CallOption) *Future
 }
 
 var rawAdditionParamValueNull reflect.Value
@@ -88,11 +107,14 @@ func (slf *RpcHandler) GetRpcHandler() IRpcHandler{
 	return slf.rpcHandler
 }
 
-func (slf *RpcHandler) InitRpcHandler(rpcHandler IRpcHandler,getClientFun FuncRpcClient,getServerFun FuncRpcServer) {
-	slf.callRequest = make(chan *RpcRequest,1000000)
-	slf.callResponeCallBack = make(chan *Call,1000000)
+//返回本服务当前的trace上下文,只能在服务协程中使用
+func (slf *RpcHandler) GetTraceContext() *trace.Context {
+	return &slf.traceContext
+}
 
+func (slf *RpcHandler) InitRpcHandler(rpcHandler IRpcHandler,getClientFun FuncRpcClient,getServerFun FuncRpcServer) {
 	slf.rpcHandler = rpcHandler
+	slf.makeQueue()
 	slf.mapfunctons = map[string]RpcMethodInfo{}
 	slf.funcRpcClient = getClientFun
 	slf.funcRpcServer = getServerFun
@@ -175,15 +197,6 @@ func  (slf *RpcHandler) RegisterRpc(rpcHandler IRpcHandler) error {
 	return nil
 }
 
-func (slf *RpcHandler) PushRequest(req *RpcRequest) error{
-	if len(slf.callRequest) >= cap(slf.callRequest){
-		return fmt.Errorf("RpcHandler %s Rpc Channel is full.",slf.GetName())
-	}
-
-	slf.callRequest <- req
-	return nil
-}
-
 func (slf *RpcHandler) GetRpcRequestChan() (chan *RpcRequest) {
 	return slf.callRequest
 }
@@ -192,13 +205,18 @@ func (slf *RpcHandler) GetRpcResponeChan() chan *Call{
 	return slf.callResponeCallBack
 }
 
+func (slf *RpcHandler) GetPendingAsyncCallNum() int32{
+	return atomic.LoadInt32(&slf.pendingAsyncCallNum)
+}
+
 func (slf *RpcHandler) HandlerRpcResponeCB(call *Call){
+	atomic.AddInt32(&slf.pendingAsyncCallNum,-1)
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, 4096)
 			l := runtime.Stack(buf, false)
 			err := fmt.Errorf("%v: %s\n", r, buf[:l])
-			log.Error("core dump info:%+v",err)
+			slf.logError("core dump info:%+v",err)
 		}
 	}()
 
@@ -227,6 +245,12 @@ func (slf *RpcHandler) HandlerRpcRequest(request *RpcRequest) {
 	defer ReleaseRpcRequest(request)
 	defer processor.ReleaseRpcRequest(request.RpcRequestData)
 
+	//调用方已超时,不再处理也不需要返回
+	if request.IsTimeout() == true {
+		log.Debug("RpcHandler %s skip %s,the caller is timeout",slf.rpcHandler.GetName(),request.RpcRequestData.GetServiceMethod())
+		return
+	}
+
 	v,ok := slf.mapfunctons[request.RpcRequestData.GetServiceMethod()]
 	if ok == false {
 		err := Errorf("RpcHandler %s cannot find %s",slf.rpcHandler.GetName(),request.RpcRequestData.GetServiceMethod())
@@ -241,7 +265,11 @@ func (slf *RpcHandler) HandlerRpcRequest(request *RpcRequest) {
 	var err error
 	iparam := reflect.New(v.iparam.Type().Elem()).Interface()
 	if request.bLocalRequest == false {
-		err = processor.Unmarshal(request.RpcRequestData.GetInParam(),iparam)
+		inParam := request.RpcRequestData.GetInParam()
+		if request.inParam != nil {
+			inParam = request.inParam
+		}
+		err = processor.Unmarshal(inParam,iparam)
 		if err!=nil {
 			rerr := Errorf("Call Rpc %s Param error %+v",request.RpcRequestData.GetServiceMethod(),err)
 			log.Error("%s",rerr.Error())
@@ -292,10 +320,22 @@ func (slf *RpcHandler) HandlerRpcRequest(request *RpcRequest) {
 		request.requestHandle(nil, rerr)
 		return
 	}
-	returnValues := v.method.Func.Call(paramList)
-	errInter := returnValues[0].Interface()
-	if errInter != nil {
-		err = errInter.(error)
+	chain := slf.newServerChain()
+	if chain.isEmpty() == true {
+		err = callRpcMethod(v.method,paramList)
+	}else{
+		inv := &Invocation{ServiceMethod:request.RpcRequestData.GetServiceMethod(),Args:iparam,Header:request.RpcRequestData.GetHeader()}
+		if additionParams != nil {
+			inv.AdditionParam = additionParams.GetParamValue()
+		}
+		if oParam.IsValid() {
+			inv.Reply = oParam.Interface()
+		}
+		err = chain.before(inv)
+		if err == nil {
+			err = callRpcMethod(v.method,paramList)
+		}
+		err = chain.after(inv,err)
 	}
 
 	if request.requestHandle!=nil {
@@ -303,6 +343,16 @@ func (slf *RpcHandler) HandlerRpcRequest(request *RpcRequest) {
 	}
 }
 
+func callRpcMethod(method reflect.Method,paramList []reflect.Value) error {
+	returnValues := method.Func.Call(paramList)
+	errInter := returnValues[0].Interface()
+	if errInter != nil {
+		return errInter.(error)
+	}
+
+	return nil
+}
+
 func (slf *RpcHandler) CallMethod(ServiceMethod string,param interface{},reply interface{}) error{
 	var err error
 	v,ok := slf.mapfunctons[ServiceMethod]
@@ -327,18 +377,57 @@ func (slf *RpcHandler) CallMethod(ServiceMethod string,param interface{},reply i
 	return err
 }
 
-func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args interface{}) error {
+//发起调用时的错误日志带上当前trace id,在服务协程中处理请求或回调时调用
+func (slf *RpcHandler) logError(format string,a ...interface{}) {
+	log.ErrorPrefix(1,slf.GetTraceContext().LogPrefix(),format,a...)
+}
+
+//跨子网调用时serviceMethod格式为subnet/Service.Method,找到结点后去掉子网名
+func trimSubNet(serviceMethod string) string {
+	if idx := strings.Index(serviceMethod,"/");idx>=0 {
+		return serviceMethod[idx+1:]
+	}
+
+	return serviceMethod
+}
+
+func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args interface{},opts []CallOption) error {
 	var pClientList []*Client
 	err := slf.funcRpcClient(nodeId,serviceMethod,&pClientList)
 	if err != nil {
-		log.Error("Call serviceMethod is error:%+v!",err)
+		slf.logError("Call serviceMethod is error:%+v!",err)
 		return err
 	}
-	if len(pClientList) > 1 && bCast == false{
-		log.Error("Cannot call more then 1 node!")
-		return fmt.Errorf("Cannot call more then 1 node!")
+	serviceMethod = trimSubNet(serviceMethod)
+	opt := makeCallOption(opts)
+	if bCast == false {
+		pClient,err := selectClient(serviceMethod,pClientList,opt)
+		if err != nil {
+			slf.logError("%+v",err)
+			return err
+		}
+		pClientList = []*Client{pClient}
+	}
+
+	chain := slf.newClientChain()
+	if chain.isEmpty() == true {
+		return slf.goClient(opt,pClientList,serviceMethod,args)
+	}
+
+	inv := &Invocation{ServiceMethod:serviceMethod,Args:args,Header:opt.header}
+	if bCast == false {
+		inv.NodeId = pClientList[0].NodeId
+	}
+	err = chain.before(inv)
+	if err == nil {
+		opt.header = inv.Header
+		err = slf.goClient(opt,pClientList,serviceMethod,args)
 	}
+	return chain.after(inv,err)
+}
 
+func (slf *RpcHandler) goClient(opt *callOption,pClientList []*Client,serviceMethod string,args interface{}) error {
+	var err error
 	//2.rpcclient调用
 	//如果调用本结点服务
 	for _,pClient := range pClientList {
@@ -348,7 +437,7 @@ func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args int
 			sMethod := strings.Split(serviceMethod,".")
 			if len(sMethod)!=2 {
 				serr := fmt.Errorf("Call serviceMethod %s is error!",serviceMethod)
-				log.Error("%+v",serr)
+				slf.logError("%+v",serr)
 				if serr!= nil {
 					err = serr
 				}
@@ -357,10 +446,10 @@ func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args int
 			//调用自己rpcHandler处理器
 			if sMethod[0] == slf.rpcHandler.GetName() { //自己服务调用
 				//
-				return pLocalRpcServer.myselfRpcHandlerGo(sMethod[0],sMethod[1],args,nil)
+				return pLocalRpcServer.myselfRpcHandlerGo(pClient,sMethod[0],sMethod[1],args,nil)
 			}
 			//其他的rpcHandler的处理器
-			pCall := pLocalRpcServer.selfNodeRpcHandlerGo(pClient,true,sMethod[0],sMethod[1],args,nil,nil,nil)
+			pCall := pLocalRpcServer.selfNodeRpcHandlerGo(opt,pClient,true,sMethod[0],sMethod[1],args,nil,nil,nil)
 			if pCall.Err!=nil {
 				err = pCall.Err
 			}
@@ -369,7 +458,7 @@ func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args int
 		}
 
 		//跨node调用
-		pCall := pClient.Go(true,serviceMethod,args,nil)
+		pCall := pClient.goCall(opt,true,serviceMethod,args,nil)
 		if pCall.Err!=nil {
 			err = pCall.Err
 		}
@@ -381,18 +470,43 @@ func (slf *RpcHandler) goRpc(bCast bool,nodeId int,serviceMethod string,args int
 
 
 
-func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args []byte,additionParam interface{}) error {
+func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args []byte,additionParam interface{},opts []CallOption) error {
 	var pClientList []*Client
 	err := slf.funcRpcClient(nodeId,serviceMethod,&pClientList)
 	if err != nil {
-		log.Error("Call serviceMethod is error:%+v!",err)
+		slf.logError("Call serviceMethod is error:%+v!",err)
 		return err
 	}
-	if len(pClientList) > 1 && bCast == false {
-		log.Error("Cannot call more then 1 node!")
-		return fmt.Errorf("Cannot call more then 1 node!")
+	serviceMethod = trimSubNet(serviceMethod)
+	opt := makeCallOption(opts)
+	if bCast == false {
+		pClient,err := selectClient(serviceMethod,pClientList,opt)
+		if err != nil {
+			slf.logError("%+v",err)
+			return err
+		}
+		pClientList = []*Client{pClient}
 	}
 
+	chain := slf.newClientChain()
+	if chain.isEmpty() == true {
+		return slf.rawGoClient(opt,pClientList,serviceMethod,args,additionParam)
+	}
+
+	inv := &Invocation{ServiceMethod:serviceMethod,Args:args,AdditionParam:additionParam,Header:opt.header}
+	if bCast == false {
+		inv.NodeId = pClientList[0].NodeId
+	}
+	err = chain.before(inv)
+	if err == nil {
+		opt.header = inv.Header
+		err = slf.rawGoClient(opt,pClientList,serviceMethod,args,additionParam)
+	}
+	return chain.after(inv,err)
+}
+
+func (slf *RpcHandler) rawGoClient(opt *callOption,pClientList []*Client,serviceMethod string,args []byte,additionParam interface{}) error {
+	var err error
 	//2.rpcclient调用
 	//如果调用本结点服务
 	for _,pClient := range pClientList {
@@ -402,7 +516,7 @@ func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args
 			sMethod := strings.Split(serviceMethod,".")
 			if len(sMethod)!=2 {
 				serr := fmt.Errorf("Call serviceMethod %s is error!",serviceMethod)
-				log.Error("%+v",serr)
+				slf.logError("%+v",serr)
 				if serr!= nil {
 					err = serr
 				}
@@ -411,10 +525,10 @@ func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args
 			//调用自己rpcHandler处理器
 			if sMethod[0] == slf.rpcHandler.GetName() { //自己服务调用
 				//
-				return pLocalRpcServer.myselfRpcHandlerGo(sMethod[0],sMethod[1],args,nil)
+				return pLocalRpcServer.myselfRpcHandlerGo(pClient,sMethod[0],sMethod[1],args,nil)
 			}
 			//其他的rpcHandler的处理器
-			pCall := pLocalRpcServer.selfNodeRpcHandlerGo(pClient,true,sMethod[0],sMethod[1],nil,args,nil,additionParam)
+			pCall := pLocalRpcServer.selfNodeRpcHandlerGo(opt,pClient,true,sMethod[0],sMethod[1],nil,args,nil,additionParam)
 			if pCall.Err!=nil {
 				err = pCall.Err
 			}
@@ -423,7 +537,7 @@ func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args
 		}
 
 		//跨node调用
-		pCall := pClient.RawGo(true,serviceMethod,args,additionParam,nil)
+		pCall := pClient.rawGo(opt,true,serviceMethod,args,additionParam,nil)
 		if pCall.Err!=nil {
 			err = pCall.Err
 		}
@@ -434,37 +548,63 @@ func (slf *RpcHandler) rawGoRpc(bCast bool,nodeId int,serviceMethod string,args
 }
 
 
-func (slf *RpcHandler) callRpc(nodeId int,serviceMethod string,args interface{},reply interface{}) error {
+func (slf *RpcHandler) callRpc(nodeId int,serviceMethod string,args interface{},reply interface{},opts []CallOption) error {
+	if policy := getCallPolicy(trimSubNet(serviceMethod));policy.canRetry() == true {
+		return slf.callRpcWithRetry(policy,nodeId,serviceMethod,args,reply,opts)
+	}
+
+	return slf.callRpcOnce(nodeId,serviceMethod,args,reply,opts)
+}
+
+func (slf *RpcHandler) callRpcOnce(nodeId int,serviceMethod string,args interface{},reply interface{},opts []CallOption) error {
 	var pClientList []*Client
 	err := slf.funcRpcClient(nodeId,serviceMethod,&pClientList)
 	if err != nil {
-		log.Error("Call serviceMethod is error:%+v!",err)
+		slf.logError("Call serviceMethod is error:%+v!",err)
 		return err
 	}
-	if len(pClientList) > 1 {
-		log.Error("Cannot call more then 1 node!")
-		return fmt.Errorf("Cannot call more then 1 node!")
+	serviceMethod = trimSubNet(serviceMethod)
+	opt := makeCallOption(opts)
+	pClient,err := selectClient(serviceMethod,pClientList,opt)
+	if err != nil {
+		slf.logError("%+v",err)
+		return err
 	}
 
+	chain := slf.newClientChain()
+	if chain.isEmpty() == true {
+		return slf.callClient(opt,pClient,serviceMethod,args,reply)
+	}
+
+	inv := &Invocation{ServiceMethod:serviceMethod,NodeId:pClient.NodeId,Args:args,Reply:reply,Header:opt.header}
+	err = chain.before(inv)
+	if err == nil {
+		opt.header = inv.Header
+		err = slf.callClient(opt,pClient,serviceMethod,args,reply)
+	}
+	return chain.after(inv,err)
+}
+
+func (slf *RpcHandler) callClient(opt *callOption,pClient *Client,serviceMethod string,args interface{},reply interface{}) error {
+	var err error
 	//2.rpcclient调用
 	//如果调用本结点服务
-	pClient := pClientList[0]
 	if pClient.bSelfNode == true {
 		pLocalRpcServer:=slf.funcRpcServer()
 		//判断是否是同一服务
 		sMethod := strings.Split(serviceMethod,".")
 		if len(sMethod)!=2 {
 			err := fmt.Errorf("Call serviceMethod %s is error!",serviceMethod)
-			log.Error("%+v",err)
+			slf.logError("%+v",err)
 			return err
 		}
 		//调用自己rpcHandler处理器
 		if sMethod[0] == slf.rpcHandler.GetName() { //自己服务调用
 			//
-			return pLocalRpcServer.myselfRpcHandlerGo(sMethod[0],sMethod[1],args,reply)
+			return pLocalRpcServer.myselfRpcHandlerGo(pClient,sMethod[0],sMethod[1],args,reply)
 		}
 		//其他的rpcHandler的处理器
-		pCall := pLocalRpcServer.selfNodeRpcHandlerGo(pClient,false,sMethod[0],sMethod[1],args,nil,reply,nil)
+		pCall := pLocalRpcServer.selfNodeRpcHandlerGo(opt,pClient,false,sMethod[0],sMethod[1],args,nil,reply,nil)
 		err = pCall.Done().Err
 		pClient.RemovePending(pCall.Seq)
 		ReleaseCall(pCall)
@@ -472,7 +612,7 @@ func (slf *RpcHandler) callRpc(nodeId int,serviceMethod string,args interface{},
 	}
 
 	//跨node调用
-	pCall := pClient.Go(false,serviceMethod,args,reply)
+	pCall := pClient.goCall(opt,false,serviceMethod,args,reply)
 	if pCall.Err != nil {
 		ReleaseCall(pCall)
 		return pCall.Err
@@ -482,45 +622,68 @@ func (slf *RpcHandler) callRpc(nodeId int,serviceMethod string,args interface{},
 	return err
 }
 
-func (slf *RpcHandler) asyncCallRpc(nodeid int,serviceMethod string,args interface{},callback interface{}) error {
+func (slf *RpcHandler) asyncCallRpc(nodeid int,serviceMethod string,args interface{},callback interface{},opts []CallOption) error {
 	fVal := reflect.ValueOf(callback)
 	if fVal.Kind()!=reflect.Func{
 		err := fmt.Errorf("call %s input callback param is error!",serviceMethod)
-		log.Error("+v",err)
+		slf.logError("+v",err)
 		return err
 	}
 
     if fVal.Type().NumIn()!= 2 {
     	err := fmt.Errorf("call %s callback param function is error!",serviceMethod)
-		log.Error("%+v",err)
+		slf.logError("%+v",err)
 		return err
 	}
 
 	if  fVal.Type().In(0).Kind() != reflect.Ptr || fVal.Type().In(1).String() != "error"{
 		err :=  fmt.Errorf("call %s callback  function param is error!",serviceMethod)
-		log.Error("%+v",err)
+		slf.logError("%+v",err)
 		return err
 	}
 
+	//需要重试时,重试结束后才回调
+	if policy := getCallPolicy(trimSubNet(serviceMethod));policy.canRetry() == true {
+		opts = sharedDeadlineOption(opts)
+		fVal = slf.wrapRetryCallback(policy,nodeid,serviceMethod,args,fVal,opts)
+	}
+
+	return slf.asyncCallClient(nodeid,serviceMethod,args,fVal,opts)
+}
+
+func (slf *RpcHandler) asyncCallClient(nodeid int,serviceMethod string,args interface{},fVal reflect.Value,opts []CallOption) error {
 	reply := reflect.New(fVal.Type().In(0).Elem()).Interface()
 	var pClientList []*Client
 	err := slf.funcRpcClient(nodeid,serviceMethod,&pClientList)
 	if err != nil {
 		fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
-		log.Error("Call serviceMethod is error:%+v!",err)
+		slf.logError("Call serviceMethod is error:%+v!",err)
 		return nil
 	}
+	serviceMethod = trimSubNet(serviceMethod)
 
-	if pClientList== nil || len(pClientList) > 1 {
-		err := fmt.Errorf("Cannot call more then 1 node!")
+	opt := makeCallOption(opts)
+	pClient,err := selectClient(serviceMethod,pClientList,opt)
+	if err != nil {
 		fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
-		log.Error("Cannot call more then 1 node!")
+		slf.logError("%+v",err)
 		return nil
 	}
 
+	//回调前执行拦截器的AfterCall
+	if chain := slf.newClientChain();chain.isEmpty() == false {
+		inv := &Invocation{ServiceMethod:serviceMethod,NodeId:pClient.NodeId,Args:args,Header:opt.header}
+		fVal = chain.wrapCallback(inv,fVal)
+		err = chain.before(inv)
+		if err != nil {
+			fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
+			return nil
+		}
+		opt.header = inv.Header
+	}
+
 	//2.rpcclient调用
 	//如果调用本结点服务
-	pClient := pClientList[0]
 	if pClient.bSelfNode == true {
 		pLocalRpcServer:=slf.funcRpcServer()
 		//判断是否是同一服务
@@ -528,28 +691,31 @@ func (slf *RpcHandler) asyncCallRpc(nodeid int,serviceMethod string,args interfa
 		if len(sMethod)!=2 {
 			err := fmt.Errorf("Call serviceMethod %s is error!",serviceMethod)
 			fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
-			log.Error("%+v",err)
+			slf.logError("%+v",err)
 			return nil
 		}
 		//调用自己rpcHandler处理器
 		if sMethod[0] == slf.rpcHandler.GetName() { //自己服务调用
-			err := pLocalRpcServer.myselfRpcHandlerGo(sMethod[0],sMethod[1],args,reply)
+			err := pLocalRpcServer.myselfRpcHandlerGo(pClient,sMethod[0],sMethod[1],args,reply)
 			if err == nil {
 				fVal.Call([]reflect.Value{reflect.ValueOf(reply),NilError})
 			}else{
 				fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
 			}
+			return nil
 		}
 
 		//其他的rpcHandler的处理器
-		if callback!=nil {
-			err =  pLocalRpcServer.selfNodeRpcHandlerAsyncGo(pClient,slf,false,sMethod[0],sMethod[1],args,reply,fVal)
+		if fVal.IsValid() == true {
+			err =  pLocalRpcServer.selfNodeRpcHandlerAsyncGo(opt,pClient,slf,false,sMethod[0],sMethod[1],args,reply,fVal)
 			if err != nil {
 				fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
+			}else{
+				atomic.AddInt32(&slf.pendingAsyncCallNum,1)
 			}
 			return nil
 		}
-		pCall := pLocalRpcServer.selfNodeRpcHandlerGo(pClient,false,sMethod[0],sMethod[1],args,nil,reply,nil)
+		pCall := pLocalRpcServer.selfNodeRpcHandlerGo(opt,pClient,false,sMethod[0],sMethod[1],args,nil,reply,nil)
 		err = pCall.Done().Err
 		pClient.RemovePending(pCall.Seq)
 		ReleaseCall(pCall)
@@ -558,9 +724,11 @@ func (slf *RpcHandler) asyncCallRpc(nodeid int,serviceMethod string,args interfa
 	}
 
 	//跨node调用
-	err =  pClient.AsycCall(slf,serviceMethod,fVal,args,reply)
+	err =  pClient.asyncCall(opt,slf,serviceMethod,fVal,args,reply)
 	if err != nil {
 		fVal.Call([]reflect.Value{reflect.ValueOf(reply),reflect.ValueOf(err)})
+	}else{
+		atomic.AddInt32(&slf.pendingAsyncCallNum,1)
 	}
 	return nil
 }
@@ -574,40 +742,42 @@ func (slf *RpcHandler) GetName() string{
 //func (slf *RpcHandler) callRpc(serviceMethod string,reply interface{},mutiCoroutine bool,args ...interface{}) error {
 //func (slf *RpcHandler) goRpc(serviceMethod string,mutiCoroutine bool,args ...interface{}) error {
 //(reply *int,err error) {}
-func (slf *RpcHandler) AsyncCall(serviceMethod string,args interface{},callback interface{}) error {
-	return slf.asyncCallRpc(0,serviceMethod,args,callback)
+//服务部署在多个结点时,按负载均衡方式选择一个结点,可以通过opts指定本次调用的方式
+//opts也可以指定本次调用的超时时间(WithTimeout)与取消调用的ctx(WithContext),默认超时时间为Default_CallTimeout
+func (slf *RpcHandler) AsyncCall(serviceMethod string,args interface{},callback interface{},opts ...CallOption) error {
+	return slf.asyncCallRpc(0,serviceMethod,args,callback,opts)
 }
 
-func (slf *RpcHandler) Call(serviceMethod string,args interface{},reply interface{}) error {
-	return slf.callRpc(0,serviceMethod,args,reply)
+func (slf *RpcHandler) Call(serviceMethod string,args interface{},reply interface{},opts ...CallOption) error {
+	return slf.callRpc(0,serviceMethod,args,reply,opts)
 }
 
 
-func (slf *RpcHandler) Go(serviceMethod string,args interface{}) error {
-	return slf.goRpc(false,0,serviceMethod,args)
+func (slf *RpcHandler) Go(serviceMethod string,args interface{},opts ...CallOption) error {
+	return slf.goRpc(false,0,serviceMethod,args,opts)
 }
 
-func (slf *RpcHandler) AsyncCallNode(nodeId int,serviceMethod string,args interface{},callback interface{}) error {
-	return slf.asyncCallRpc(nodeId,serviceMethod,args,callback)
+func (slf *RpcHandler) AsyncCallNode(nodeId int,serviceMethod string,args interface{},callback interface{},opts ...CallOption) error {
+	return slf.asyncCallRpc(nodeId,serviceMethod,args,callback,opts)
 }
 
-func (slf *RpcHandler) CallNode(nodeId int,serviceMethod string,args interface{},reply interface{}) error {
-	return slf.callRpc(nodeId,serviceMethod,args,reply)
+func (slf *RpcHandler) CallNode(nodeId int,serviceMethod string,args interface{},reply interface{},opts ...CallOption) error {
+	return slf.callRpc(nodeId,serviceMethod,args,reply,opts)
 }
 
 func (slf *RpcHandler) GoNode(nodeId int,serviceMethod string,args interface{}) error {
-	return slf.goRpc(false,nodeId,serviceMethod,args)
+	return slf.goRpc(false,nodeId,serviceMethod,args,nil)
 }
 
 func (slf *RpcHandler) CastGo(serviceMethod string,args interface{})  {
-	slf.goRpc(true,0,serviceMethod,args)
+	slf.goRpc(true,0,serviceMethod,args,nil)
 }
 
-func (slf *RpcHandler) RawGoNode(nodeId int,serviceMethod string,args []byte,additionParam interface{}) error {
-	return slf.rawGoRpc(false,nodeId,serviceMethod,args,additionParam)
+func (slf *RpcHandler) RawGoNode(nodeId int,serviceMethod string,args []byte,additionParam interface{},opts ...CallOption) error {
+	return slf.rawGoRpc(false,nodeId,serviceMethod,args,additionParam,opts)
 }
 
-func (slf *RpcHandler) RawCastGo(serviceMethod string,args []byte,additionParam interface{})  {
-	slf.goRpc(true,0,serviceMethod,args)
+func (slf *RpcHandler) RawCastGo(serviceMethod string,args []byte,additionParam interface{},opts ...CallOption)  {
+	slf.rawGoRpc(true,0,serviceMethod,args,additionParam,opts)
 }
 
@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+//服务的rpc方法描述,用于运维工具查看与校验调用
+type ServiceDesc struct {
+	Name string
+	MethodList []MethodDesc
+}
+
+type MethodDesc struct {
+	Name string          //如RPC_Add
+	AdditionParam bool   //有rpc.IRawAdditionParam参数
+	Param *TypeDesc
+	Reply *TypeDesc      //没有返回参数时为nil
+}
+
+//类似JSON Schema的类型描述,字段名与JsonProcessor序列化的名称相同
+type TypeDesc struct {
+	Type string `json:"type"`                                      //object,array,string,integer,number,boolean,any
+	Format string `json:"format,omitempty"`                        //[]byte为byte,time.Time为date-time
+	GoType string `json:"goType,omitempty"`                        //结构体与命名类型的Go类型名
+	Properties map[string]*TypeDesc `json:"properties,omitempty"`  //结构体的字段
+	Items *TypeDesc `json:"items,omitempty"`                       //数组的元素
+	AdditionalProperties *TypeDesc `json:"additionalProperties,omitempty"` //map的值
+	Ref string `json:"$ref,omitempty"`                             //递归引用的结构体,值为GoType
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//返回本服务所有rpc方法的描述,按方法名排序
+func (slf *RpcHandler) GetRpcMethodDesc() []MethodDesc {
+	methodList := make([]MethodDesc,0,len(slf.mapfunctons))
+	for serviceMethod,v := range slf.mapfunctons {
+		methodDesc := MethodDesc{Name:serviceMethod[strings.Index(serviceMethod,".")+1:],AdditionParam:v.hashAdditionParam}
+		methodDesc.Param = DescribeType(v.iparam.Type())
+		if v.oParam.IsValid() {
+			methodDesc.Reply = DescribeType(v.oParam.Type())
+		}
+		methodList = append(methodList,methodDesc)
+	}
+	sort.Slice(methodList,func(i, j int) bool {
+		return methodList[i].Name < methodList[j].Name
+	})
+
+	return methodList
+}
+
+//生成类型的描述,指针描述为其指向的类型
+func DescribeType(t reflect.Type) *TypeDesc {
+	return describeType(t,map[reflect.Type]bool{})
+}
+
+func describeType(t reflect.Type,visiting map[reflect.Type]bool) *TypeDesc {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	desc := &TypeDesc{}
+	if t.Name() != "" && t.PkgPath() != "" {
+		desc.GoType = t.String()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		desc.Type = "boolean"
+	case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,
+		reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64,reflect.Uintptr:
+		desc.Type = "integer"
+	case reflect.Float32,reflect.Float64:
+		desc.Type = "number"
+	case reflect.String:
+		desc.Type = "string"
+	case reflect.Slice,reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			desc.Type = "string"
+			desc.Format = "byte"
+			break
+		}
+		desc.Type = "array"
+		desc.Items = describeType(t.Elem(),visiting)
+	case reflect.Map:
+		desc.Type = "object"
+		desc.AdditionalProperties = describeType(t.Elem(),visiting)
+	case reflect.Struct:
+		if t == timeType {
+			desc.Type = "string"
+			desc.Format = "date-time"
+			break
+		}
+		desc.Type = "object"
+		if visiting[t] == true {
+			desc.Ref = desc.GoType
+			break
+		}
+		visiting[t] = true
+		desc.Properties = map[string]*TypeDesc{}
+		describeFields(t,desc.Properties,visiting)
+		delete(visiting,t)
+	default:
+		desc.Type = "any"
+	}
+
+	return desc
+}
+
+//与json序列化一致:跳过未导出与json:"-"的字段,展开没有json名称的嵌入结构体
+func describeFields(t reflect.Type,properties map[string]*TypeDesc,visiting map[reflect.Type]bool) {
+	for i:=0;i<t.NumField();i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || strings.HasPrefix(field.Name,"XXX_") == true {
+			continue
+		}
+		name := strings.Split(tag,",")[0]
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous == true && name == "" && fieldType.Kind() == reflect.Struct && visiting[fieldType] == false {
+			visiting[fieldType] = true
+			describeFields(fieldType,properties,visiting)
+			delete(visiting,fieldType)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = describeType(field.Type,visiting)
+	}
+}
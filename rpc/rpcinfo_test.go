@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type TestInfoBase struct {
+	Id int64
+}
+
+type TestInfoNode struct {
+	TestInfoBase
+	Name string `json:"name,omitempty"`
+	Data []byte
+	Time time.Time
+	Tags map[string][]int32
+	Next *TestInfoNode
+	Skip int `json:"-"`
+	hide int
+}
+
+type testInfoHandler struct {
+	RpcHandler
+}
+
+func (slf *testInfoHandler) GetName() string {
+	return "TestInfoService"
+}
+
+func (slf *testInfoHandler) RPC_Get(req *TestInfoBase,ret *TestInfoNode) error {
+	return nil
+}
+
+func (slf *testInfoHandler) RPC_Notify(req *TestInfoBase,ret *int32) error {
+	return nil
+}
+
+func TestDescribeType(t *testing.T) {
+	desc := DescribeType(reflect.TypeOf((*TestInfoNode)(nil))).Properties
+	if len(desc) != 6 {
+		t.Fatalf("properties is error:%+v",desc)
+	}
+	if desc["Id"].Type != "integer" || desc["name"].Type != "string" {
+		t.Fatal("embedded struct and json tag must be used as json does")
+	}
+	if desc["Data"].Format != "byte" || desc["Time"].Format != "date-time" {
+		t.Fatal("[]byte and time.Time must be described as string")
+	}
+	if desc["Tags"].Type != "object" || desc["Tags"].AdditionalProperties.Items.Type != "integer" {
+		t.Fatal("map of array is error")
+	}
+	if desc["Next"].Ref != "rpc.TestInfoNode" || desc["Next"].Properties != nil {
+		t.Fatal("recursive struct must be referenced")
+	}
+}
+
+func TestGetRpcMethodDesc(t *testing.T) {
+	rpcHandler := &testInfoHandler{}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	methodList := rpcHandler.GetRpcMethodDesc()
+	if len(methodList) != 2 || methodList[0].Name != "RPC_Get" || methodList[1].Name != "RPC_Notify" {
+		t.Fatalf("method list is error:%+v",methodList)
+	}
+	if methodList[0].Param.GoType != "rpc.TestInfoBase" || methodList[0].Reply.GoType != "rpc.TestInfoNode" || methodList[1].Reply.Type != "integer" {
+		t.Fatalf("method desc is error:%+v",methodList)
+	}
+}
@@ -1,6 +1,8 @@
 package rpc
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/network"
@@ -8,16 +10,26 @@ import (
 	"net"
 	"reflect"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var processor IRpcProcessor = &JsonProcessor{}
 var LittleEndian bool
 
+//结点间消息的默认最大长度,超过65535时拆包发送
+var Default_MaxRpcMsgLen uint32 = math.MaxUint16
+
 type Server struct {
 	functions map[interface{}]interface{}
 	cmdchannel chan *Call
 	rpcHandleFinder RpcHandleFinder
 	rpcserver *network.TCPServer
+	stopAccept int32 //不再接受新的rpc请求
+	maxMsgLen uint32
+	compressMinLen int
+	nodeId int //本结点的NodeId,握手时发送给调用方
+	adminToken string //运维rpc的口令,为空时拒绝所有运维rpc
 }
 
 func SetProcessor(proc IRpcProcessor) {
@@ -30,15 +42,26 @@ func (slf *Server) Init(rpcHandleFinder RpcHandleFinder) {
 	slf.rpcserver = &network.TCPServer{}
 }
 
-func (slf *Server) Start(listenAddr string) {
+//maxMsgLen为连接到本结点的连接上允许的最大消息长度,为0时使用Default_MaxRpcMsgLen
+//compressMinLen为压缩返回值的最小长度,为0时使用Default_CompressMinLen
+func (slf *Server) Start(listenAddr string,maxMsgLen uint32,compressMinLen int) {
 	 splitAddr := strings.Split(listenAddr,":")
 	 if len(splitAddr)!=2{
 	 	log.Fatal("listen addr is error :%s",listenAddr)
 	 }
+	if maxMsgLen == 0 {
+		maxMsgLen = Default_MaxRpcMsgLen
+	}
+	slf.maxMsgLen = maxMsgLen
+	if compressMinLen == 0 {
+		compressMinLen = Default_CompressMinLen
+	}
+	slf.compressMinLen = compressMinLen
 	slf.rpcserver.Addr = ":"+splitAddr[1]
 	slf.rpcserver.LenMsgLen = 2 //uint16
 	slf.rpcserver.MinMsgLen = 2
-	slf.rpcserver.MaxMsgLen = math.MaxUint16
+	slf.rpcserver.MaxMsgLen = maxMsgLen
+	slf.rpcserver.ChunkedMsg = maxMsgLen > math.MaxUint16
 	slf.rpcserver.MaxConnNum = 10000
 	slf.rpcserver.PendingWriteNum = 2000000
 	slf.rpcserver.NewAgent =slf.NewAgent
@@ -46,26 +69,77 @@ func (slf *Server) Start(listenAddr string) {
 	slf.rpcserver.Start()
 }
 
+//开启TLS,需要在Start前设置
+func (slf *Server) SetTLSConfig(config *tls.Config) {
+	slf.rpcserver.TLSConfig = config
+}
+
+//设置本结点的NodeId,需要在Start前设置
+func (slf *Server) SetNodeId(nodeId int) {
+	slf.nodeId = nodeId
+}
+
+//设置运维rpc的口令,调用方握手时出示相同的口令才能调用运维rpc,需要在Start前设置
+func (slf *Server) SetAdminToken(token string) {
+	slf.adminToken = token
+}
+
+func (slf *Server) checkAdminToken(token string) bool {
+	if slf.adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(slf.adminToken),[]byte(token)) == 1
+}
+
+//停止监听并拒绝新的rpc请求,已建立的连接保留用于返回处理中的请求
+func (slf *Server) StopAccept() {
+	atomic.StoreInt32(&slf.stopAccept,1)
+	slf.rpcserver.CloseListener()
+}
+
+func (slf *Server) Stop() {
+	slf.rpcserver.Close()
+}
+
 
 func (gate *RpcAgent) OnDestroy() {}
 
 type RpcAgent struct {
-	conn     network.Conn
+	conn     *network.TCPConn
 	rpcserver     *Server
 	userData interface{}
+	peerNodeId int //握手中调用方的NodeId,没有握手时为0
+	peerAdmin bool //调用方握手时出示了正确的运维口令
+}
+
+//检查调用方是否可以调用serviceMethod,返回错误时拒绝请求。peerNodeId为0表示调用方没有握手
+//peerNodeId是调用方握手时自称的NodeId,peerAdmin表示调用方握手时出示了SetAdminToken设置的口令
+type FuncRequestFilter func(peerNodeId int,peerAdmin bool,serviceMethod string) error
+
+var requestFilter FuncRequestFilter
+
+//设置其他结点请求的过滤函数,由cluster设置,本结点内的调用不过滤
+func SetRequestFilter(filter FuncRequestFilter) {
+	requestFilter = filter
 }
 
 
-func (agent *RpcAgent) WriteRespone(serviceMethod string,seq uint64,reply interface{},err *RpcError) {
+//compressType为调用方请求中带的压缩算法
+func (agent *RpcAgent) WriteRespone(serviceMethod string,seq uint64,reply interface{},err *RpcError,compressType uint32) {
 	var mReply []byte
 	var rpcError *RpcError
 	var errM error
+	var flag uint32
 
 	if err != nil {
 		rpcError = err
 	} else {
 		if reply!=nil {
 			mReply,errM = processor.Marshal(reply)
+			if errM == nil {
+				mReply,flag,errM = compressData(compressType,agent.rpcserver.compressMinLen,mReply)
+			}
 			if errM != nil {
 				rpcError = ConvertError(errM)
 			}
@@ -73,7 +147,7 @@ func (agent *RpcAgent) WriteRespone(serviceMethod string,seq uint64,reply interf
 	}
 
 	var rpcResponse RpcResponse
-	rpcResponse.RpcResponeData = processor.MakeRpcResponse(seq,rpcError,mReply)
+	rpcResponse.RpcResponeData = processor.MakeRpcResponse(seq,rpcError,mReply,flag)
 	bytes,errM :=  processor.Marshal(rpcResponse.RpcResponeData)
 	defer processor.ReleaseRpcRespose(rpcResponse.RpcResponeData)
 
@@ -82,6 +156,20 @@ func (agent *RpcAgent) WriteRespone(serviceMethod string,seq uint64,reply interf
 		return
 	}
 
+	//返回值超过最大长度时返回错误,调用方不需要等到超时
+	//连接没有协商拆包时不能超过65535
+	if maxMsgLen := agent.conn.GetMaxMsgLen();uint32(len(bytes)) > maxMsgLen {
+		rpcError = Errorf("service method %s reply length %d exceeds max message length %d",serviceMethod,len(bytes),maxMsgLen)
+		log.Error("%s",rpcError.Error())
+		errResponse := processor.MakeRpcResponse(seq,rpcError,nil,0)
+		defer processor.ReleaseRpcRespose(errResponse)
+		bytes,errM = processor.Marshal(errResponse)
+		if errM != nil {
+			log.Error("service method %s Marshal error:%+v!",serviceMethod,errM)
+			return
+		}
+	}
+
 
 	errM = agent.conn.WriteMsg(bytes)
 	if errM != nil {
@@ -91,6 +179,7 @@ func (agent *RpcAgent) WriteRespone(serviceMethod string,seq uint64,reply interf
 
 
 func (agent *RpcAgent) Run() {
+	bFirstMsg := true
 	for {
 		data,err := agent.conn.ReadMsg()
 		if err != nil {
@@ -98,15 +187,26 @@ func (agent *RpcAgent) Run() {
 			//will close tcpconn
 			break
 		}
+		//第一条消息可能是握手
+		if bFirstMsg == true {
+			bFirstMsg = false
+			isHandshake,ok := agent.handleHandshake(data)
+			if ok == false {
+				break
+			}
+			if isHandshake == true {
+				continue
+			}
+		}
 		//解析head
 		req := MakeRpcRequest()
-		req.RpcRequestData = processor.MakeRpcRequest(0,"",false,nil,nil)
+		req.RpcRequestData = processor.MakeRpcRequest(0,"",false,nil,nil,0,nil,0)
 		err = processor.Unmarshal(data,req.RpcRequestData)
 		if err != nil {
 			log.Error("rpc Unmarshal request is error: %v", err)
 			if req.RpcRequestData.GetSeq()>0 {
 				rpcError := RpcError(err.Error())
-				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError)
+				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
 				processor.ReleaseRpcRequest(req.RpcRequestData)
 				ReleaseRpcRequest(req)
 				continue
@@ -122,26 +222,69 @@ func (agent *RpcAgent) Run() {
 		serviceMethod := strings.Split(req.RpcRequestData.GetServiceMethod(),".")
 		if len(serviceMethod)!=2 {
 			rpcError := RpcError("rpc request req.ServiceMethod is error")
-			agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError)
+			agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
 			processor.ReleaseRpcRequest(req.RpcRequestData)
 			ReleaseRpcRequest(req)
 			log.Debug("rpc request req.ServiceMethod is error")
 			continue
 		}
 
+		if atomic.LoadInt32(&agent.rpcserver.stopAccept) == 1 {
+			rpcError := RpcError(fmt.Sprintf("service method %s is refused,node is stopping!", req.RpcRequestData.GetServiceMethod()))
+			if req.RpcRequestData.IsNoReply() == false {
+				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
+			}
+			processor.ReleaseRpcRequest(req.RpcRequestData)
+			ReleaseRpcRequest(req)
+			continue
+		}
+
+		if requestFilter != nil {
+			if err = requestFilter(agent.peerNodeId,agent.peerAdmin,req.RpcRequestData.GetServiceMethod());err != nil {
+				rpcError := RpcError(err.Error())
+				log.Error("rpc agent %s %s",agent.conn.RemoteAddr(),err.Error())
+				if req.RpcRequestData.IsNoReply() == false {
+					agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
+				}
+				processor.ReleaseRpcRequest(req.RpcRequestData)
+				ReleaseRpcRequest(req)
+				continue
+			}
+		}
+
 		rpcHandler := agent.rpcserver.rpcHandleFinder.FindRpcHandler(serviceMethod[0])
 		if rpcHandler== nil {
 			rpcError := RpcError(fmt.Sprintf("service method %s not config!", req.RpcRequestData.GetServiceMethod()))
-			agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError)
+			agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
 			processor.ReleaseRpcRequest(req.RpcRequestData)
 			ReleaseRpcRequest(req)
 			log.Error("service method %s not config!", req.RpcRequestData.GetServiceMethod())
 			continue
 		}
 
+		//按收到请求的时间计算截止时间,不受结点间时钟差异影响
+		if timeout := req.RpcRequestData.GetTimeout();timeout > 0 {
+			req.deadline = time.Now().Add(time.Duration(timeout)*time.Millisecond)
+		}
+
+		compressType := getFlagCompressType(req.RpcRequestData.GetFlag())
+		if req.RpcRequestData.GetFlag()&FlagCompressed != 0 {
+			req.inParam,err = decompress(compressType,req.RpcRequestData.GetInParam())
+			if err != nil {
+				rpcError := RpcError(fmt.Sprintf("service method %s decompress param error:%+v", req.RpcRequestData.GetServiceMethod(),err))
+				log.Error("%s",rpcError.Error())
+				if req.RpcRequestData.IsNoReply() == false {
+					agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
+				}
+				processor.ReleaseRpcRequest(req.RpcRequestData)
+				ReleaseRpcRequest(req)
+				continue
+			}
+		}
+
 		if req.RpcRequestData.IsNoReply()==false {
 			req.requestHandle = func(Returns interface{},Err *RpcError){
-				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),Returns,Err)
+				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),Returns,Err,compressType)
 			}
 		}
 
@@ -149,8 +292,8 @@ func (agent *RpcAgent) Run() {
 		if err != nil {
 			rpcError := RpcError(err.Error())
 
-			if req.RpcRequestData.IsNoReply() {
-				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError)
+			if req.RpcRequestData.IsNoReply() == false {
+				agent.WriteRespone(req.RpcRequestData.GetServiceMethod(),req.RpcRequestData.GetSeq(),nil,&rpcError,0)
 			}
 
 			processor.ReleaseRpcRequest(req.RpcRequestData)
@@ -188,7 +331,8 @@ func (slf *Server) NewAgent(conn *network.TCPConn) network.Agent {
 	return agent
 }
 
-func (slf *Server) myselfRpcHandlerGo(handlerName string,methodName string, args interface{},reply interface{}) error {
+//服务调用自己时在调用方协程中直接执行,执行期间计入client的未返回调用数量
+func (slf *Server) myselfRpcHandlerGo(client *Client,handlerName string,methodName string, args interface{},reply interface{}) error {
 	rpcHandler := slf.rpcHandleFinder.FindRpcHandler(handlerName)
 	if rpcHandler== nil {
 		err := fmt.Errorf("service method %s.%s not config!", handlerName,methodName)
@@ -196,35 +340,68 @@ func (slf *Server) myselfRpcHandlerGo(handlerName string,methodName string, args
 		return err
 	}
 
+	atomic.AddInt32(&client.selfCallNum,1)
+	defer atomic.AddInt32(&client.selfCallNum,-1)
 	return rpcHandler.CallMethod(fmt.Sprintf("%s.%s",handlerName,methodName),args,reply)
 }
 
 
-func (slf *Server) selfNodeRpcHandlerGo(client *Client,noReply bool,handlerName string,methodName string, args interface{},rawArgs []byte,reply interface{},additionParam interface{}) *Call {
+//本结点调用时被调用方写入的返回值,与调用方的reply类型相同。reply不是指针时直接使用
+func makeLocalReply(reply interface{}) interface{} {
+	replyType := reflect.TypeOf(reply)
+	if replyType == nil || replyType.Kind() != reflect.Ptr {
+		return reply
+	}
+
+	return reflect.New(replyType.Elem()).Interface()
+}
+
+func copyLocalReply(reply interface{},localReply interface{}) {
+	if localReply == nil {
+		return
+	}
+
+	replyVal := reflect.ValueOf(reply)
+	localVal := reflect.ValueOf(localReply)
+	if replyVal.Kind() != reflect.Ptr || replyVal.IsNil() == true || replyVal.Type() != localVal.Type() || replyVal.Pointer() == localVal.Pointer() {
+		return
+	}
+	replyVal.Elem().Set(localVal.Elem())
+}
+
+//需要返回的调用通过pCall.done返回结果
+func (slf *Server) selfNodeRpcHandlerGo(opt *callOption,client *Client,noReply bool,handlerName string,methodName string, args interface{},rawArgs []byte,reply interface{},additionParam interface{}) *Call {
 	pCall := MakeCall()
 	pCall.Seq = client.generateSeq()
+	pCall.ServiceMethod = handlerName+"."+methodName
+	pCall.deadline = opt.getDeadline(noReply)
 
 	rpcHandler := slf.rpcHandleFinder.FindRpcHandler(handlerName)
 	if rpcHandler== nil {
 		pCall.Err = fmt.Errorf("service method %s.%s not config!", handlerName,methodName)
 		log.Error("%s",pCall.Err.Error())
-		pCall.done <- pCall
+		if noReply == false {
+			pCall.done <- pCall
+		}
 		return pCall
 	}
 	req :=  MakeRpcRequest()
 
 	req.bLocalRequest = true
 	req.localParam = args
-	req.localReply = reply
 	req.localRawParam = rawArgs
-	req.RpcRequestData = processor.MakeRpcRequest(0,fmt.Sprintf("%s.%s",handlerName,methodName),noReply,nil,additionParam)
+	req.deadline = pCall.deadline
+	req.RpcRequestData = processor.MakeRpcRequest(0,pCall.ServiceMethod,noReply,nil,additionParam,0,opt.header,0)
 	if noReply == false {
-		client.AddPending(pCall)
+		//被调用方写入私有的返回值,未超时时才复制给调用方,超时后调用方的reply不会再被修改
+		req.localReply = makeLocalReply(reply)
+		//pCall超时后可能被释放并重用,不能在回调中读取pCall.Seq
+		seq := pCall.Seq
+		client.addPendingWithContext(pCall,opt.ctx)
 		req.requestHandle = func(Returns interface{},Err *RpcError){
-			v := client.RemovePending(pCall.Seq)
+			//已超时或被取消,调用方已释放pCall
+			v := client.RemovePending(seq)
 			if v == nil {
-				log.Error("rpcClient cannot find seq %d in pending",pCall.Seq)
-				ReleaseCall(pCall)
 				return
 			}
 
@@ -232,6 +409,7 @@ func (slf *Server) selfNodeRpcHandlerGo(client *Client,noReply bool,handlerName
 				pCall.Err = Err
 			}else{
 				pCall.Err = nil
+				copyLocalReply(reply,Returns)
 			}
 
 			pCall.done <- pCall
@@ -242,19 +420,25 @@ func (slf *Server) selfNodeRpcHandlerGo(client *Client,noReply bool,handlerName
 	if err != nil {
 		processor.ReleaseRpcRequest(req.RpcRequestData)
 		ReleaseRpcRequest(req)
-		pCall.Err = err
-		pCall.done <- pCall
+		if noReply == true {
+			pCall.Err = err
+		}else if client.RemovePending(pCall.Seq) != nil {
+			pCall.Err = err
+			pCall.done <- pCall
+		}
 	}
 
 	return pCall
 }
 
-func (slf *Server) selfNodeRpcHandlerAsyncGo(client *Client,callerRpcHandler IRpcHandler,noReply bool,handlerName string,methodName string,args interface{},reply interface{},callback reflect.Value) error {
+func (slf *Server) selfNodeRpcHandlerAsyncGo(opt *callOption,client *Client,callerRpcHandler IRpcHandler,noReply bool,handlerName string,methodName string,args interface{},reply interface{},callback reflect.Value) error {
 	pCall := MakeCall()
 	pCall.Seq = client.generateSeq()
 	pCall.rpcHandler = callerRpcHandler
 	pCall.callback = &callback
 	pCall.Reply = reply
+	pCall.ServiceMethod = handlerName+"."+methodName
+	pCall.deadline = opt.getDeadline(noReply)
 	rpcHandler := slf.rpcHandleFinder.FindRpcHandler(handlerName)
 	if rpcHandler== nil {
 		err := fmt.Errorf("service method %s.%s not config!", handlerName,methodName)
@@ -265,18 +449,18 @@ func (slf *Server) selfNodeRpcHandlerAsyncGo(client *Client,callerRpcHandler IRp
 
 	req := MakeRpcRequest()
 	req.localParam = args
-	req.localReply = reply
 	req.bLocalRequest = true
-	req.RpcRequestData = processor.MakeRpcRequest(0,fmt.Sprintf("%s.%s",handlerName,methodName),noReply,nil,nil)
+	req.deadline = pCall.deadline
+	req.RpcRequestData = processor.MakeRpcRequest(0,pCall.ServiceMethod,noReply,nil,nil,0,opt.header,0)
 	if noReply == false {
-		client.AddPending(pCall)
+		//超时后回调使用调用方的reply,被调用方写入私有的返回值,返回时交给回调
+		req.localReply = makeLocalReply(reply)
+		seq := pCall.Seq
+		client.addPendingWithContext(pCall,opt.ctx)
 		req.requestHandle = func(Returns interface{},Err *RpcError){
-			v := client.RemovePending(pCall.Seq)
+			//已超时或被取消,pCall已交给回调处理
+			v := client.RemovePending(seq)
 			if v == nil {
-				log.Error("rpcClient cannot find seq %d in pending",pCall.Seq)
-				ReleaseCall(pCall)
-				processor.ReleaseRpcRequest(req.RpcRequestData)
-				ReleaseRpcRequest(req)
 				return
 			}
 
@@ -289,13 +473,20 @@ func (slf *Server) selfNodeRpcHandlerAsyncGo(client *Client,callerRpcHandler IRp
 			if Returns!=nil {
 				pCall.Reply = Returns
 			}
-			pCall.rpcHandler.(*RpcHandler).callResponeCallBack<-pCall
+			//在被调用方的服务协程中投递
+			pCall.rpcHandler.(*RpcHandler).pushResponeCB(pCall)
 		}
 	}
 
 
 	err := rpcHandler.PushRequest(req)
 	if err != nil {
+		if noReply == false && client.RemovePending(pCall.Seq) == nil {
+			processor.ReleaseRpcRequest(req.RpcRequestData)
+			ReleaseRpcRequest(req)
+			return nil
+		}
+		ReleaseCall(pCall)
 		processor.ReleaseRpcRequest(req.RpcRequestData)
 		ReleaseRpcRequest(req)
 
@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+type testLocalHandler struct {
+	RpcHandler
+	delay time.Duration
+	done chan bool
+}
+
+func (slf *testLocalHandler) GetName() string {
+	return "TestLocalService"
+}
+
+func (slf *testLocalHandler) RPC_Slow(req *int,ret *int) error {
+	time.Sleep(slf.delay)
+	*ret = *req
+	slf.done <- true
+	return nil
+}
+
+type testLocalFinder struct {
+	rpcHandler IRpcHandler
+}
+
+func (slf *testLocalFinder) FindRpcHandler(serviceName string) IRpcHandler {
+	return slf.rpcHandler
+}
+
+func TestSelfNodeCallTimeout(t *testing.T) {
+	rpcHandler := &testLocalHandler{delay:300*time.Millisecond,done:make(chan bool,2)}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	go func() {
+		for req := range rpcHandler.GetRpcRequestChan() {
+			rpcHandler.HandlerRpcRequest(req)
+		}
+	}()
+	server := &Server{}
+	server.Init(&testLocalFinder{rpcHandler:rpcHandler})
+	client := &Client{}
+	client.Connect("")
+	defer client.Close()
+
+	//超时后被调用方不能再写入调用方的reply
+	req,reply := 1,0
+	pCall := server.selfNodeRpcHandlerGo(makeCallOption([]CallOption{WithTimeout(10*time.Millisecond)}),client,false,"TestLocalService","RPC_Slow",&req,nil,&reply,nil)
+	if pCall.Done().Err == nil {
+		t.Fatal("call must be timeout")
+	}
+	ReleaseCall(pCall)
+	<-rpcHandler.done
+	if reply != 0 {
+		t.Fatalf("reply is modified after timeout:%d",reply)
+	}
+
+	//未超时时返回被调用方的结果
+	req = 2
+	pCall = server.selfNodeRpcHandlerGo(makeCallOption([]CallOption{WithTimeout(time.Second)}),client,false,"TestLocalService","RPC_Slow",&req,nil,&reply,nil)
+	if pCall.Done().Err != nil || reply != 2 {
+		t.Fatalf("call is fail:%+v %d",pCall.Err,reply)
+	}
+	ReleaseCall(pCall)
+}
+
+//服务调用自己时在调用方协程中执行,执行期间LeastPending也需要计入
+func TestLeastPendingSelfCall(t *testing.T) {
+	rpcHandler := &testLocalHandler{delay:300*time.Millisecond,done:make(chan bool,1)}
+	rpcHandler.InitRpcHandler(rpcHandler,nil,nil)
+	server := &Server{}
+	server.Init(&testLocalFinder{rpcHandler:rpcHandler})
+	selfClient := &Client{NodeId:1,bSelfNode:true}
+	otherClient := &Client{NodeId:2}
+
+	go func() {
+		req,reply := 1,0
+		server.myselfRpcHandlerGo(selfClient,"TestLocalService","RPC_Slow",&req,&reply)
+	}()
+	for i:=0;i<100 && selfClient.GetPendingNum() == 0;i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if selfClient.GetPendingNum() != 1 {
+		t.Fatalf("expect 1 pending call,but %d",selfClient.GetPendingNum())
+	}
+
+	opt := makeCallOption([]CallOption{WithLoadBalance(LB_LeastPending)})
+	for i:=0;i<2;i++ {
+		pClient,err := selectLoadBalanceClient("TestLocalService.RPC_Slow",[]*Client{selfClient,otherClient},opt)
+		if err != nil || pClient != otherClient {
+			t.Fatalf("expect node 2 without pending call,but %+v %+v",pClient,err)
+		}
+	}
+
+	<-rpcHandler.done
+	for i:=0;i<100 && selfClient.GetPendingNum() != 0;i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if selfClient.GetPendingNum() != 0 {
+		t.Fatalf("expect no pending call after returned,but %d",selfClient.GetPendingNum())
+	}
+}
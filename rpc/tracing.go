@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"github.com/duanhf2012/origin/trace"
+	"strconv"
+)
+
+//发出调用时创建client span,并将上下文写入请求头
+type traceClientInterceptor struct {
+}
+
+//收到带有trace上下文的请求时创建server span,RPC函数中打印的日志与发出的调用都属于该span
+type traceServerInterceptor struct {
+}
+
+//添加trace拦截器,需要先调用trace.Open
+func OpenTrace() {
+	AddClientInterceptor(&traceClientInterceptor{})
+	AddServerInterceptor(&traceServerInterceptor{})
+}
+
+func (slf *traceClientInterceptor) BeforeCall(inv *Invocation) error {
+	//只跟踪已在trace中的调用
+	inv.traceParent = inv.traceContext.Current()
+	if inv.traceParent.IsValid() == false {
+		return nil
+	}
+
+	inv.traceSpan = trace.NewSpan(inv.traceParent,inv.ServiceMethod,trace.SpanKindClient)
+	if inv.NodeId > 0 {
+		inv.traceSpan.SetAttribute("rpc.node_id",strconv.Itoa(inv.NodeId))
+	}
+	inv.SetHeader(trace.HeaderTraceParent,inv.traceSpan.Context().TraceParent())
+	return nil
+}
+
+func (slf *traceClientInterceptor) AfterCall(inv *Invocation) {
+	if inv.traceSpan == nil {
+		return
+	}
+
+	inv.traceSpan.SetError(inv.Err)
+	inv.traceSpan.End()
+	//异步调用的回调中恢复发起调用时的上下文
+	inv.traceContext.SetCurrent(inv.traceParent)
+}
+
+func (slf *traceServerInterceptor) BeforeCall(inv *Invocation) error {
+	sc,ok := trace.Extract(inv.Header)
+	if ok == false {
+		return nil
+	}
+
+	inv.traceSpan = trace.NewSpan(sc,inv.ServiceMethod,trace.SpanKindServer).Attach(inv.traceContext)
+	return nil
+}
+
+func (slf *traceServerInterceptor) AfterCall(inv *Invocation) {
+	inv.traceSpan.SetError(inv.Err)
+	inv.traceSpan.End()
+}
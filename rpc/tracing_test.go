@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"github.com/duanhf2012/origin/trace"
+	"testing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	trace.Open("test",1,nil)
+	defer trace.Close()
+
+	caller := &testLocalHandler{}
+	caller.InitRpcHandler(caller,nil,nil)
+	callee := &testLocalHandler{}
+	callee.InitRpcHandler(callee,nil,nil)
+	rootSpan := caller.GetTraceContext().StartSpan("root",trace.SpanKindInternal)
+
+	//调用方把上下文写入请求头
+	clientChain := &interceptorChain{global:[]IRpcInterceptor{&traceClientInterceptor{}},traceContext:caller.GetTraceContext()}
+	clientInv := &Invocation{ServiceMethod:"TestLocalService.RPC_Slow",NodeId:2}
+	if err := clientChain.before(clientInv);err != nil {
+		t.Fatal(err)
+	}
+	if clientInv.traceSpan.ParentSpanId != rootSpan.SpanId || clientInv.GetHeader(trace.HeaderTraceParent) == "" {
+		t.Fatal("client span is not the child of root span")
+	}
+
+	//被调用方从请求头恢复上下文,只影响被调用服务的上下文
+	serverChain := &interceptorChain{global:[]IRpcInterceptor{&traceServerInterceptor{}},traceContext:callee.GetTraceContext()}
+	serverInv := &Invocation{ServiceMethod:clientInv.ServiceMethod,Header:clientInv.Header}
+	if err := serverChain.before(serverInv);err != nil {
+		t.Fatal(err)
+	}
+	sc := callee.GetTraceContext().Current()
+	if sc.TraceId != rootSpan.TraceId || serverInv.traceSpan.ParentSpanId != clientInv.traceSpan.SpanId {
+		t.Fatalf("callee context is %s",sc.TraceParent())
+	}
+	if caller.GetTraceContext().Current() != rootSpan.Context() {
+		t.Fatal("caller context is modified by callee")
+	}
+
+	serverChain.after(serverInv,nil)
+	if callee.GetTraceContext().Current().IsValid() == true {
+		t.Fatal("callee context must be cleared after call")
+	}
+	clientChain.after(clientInv,nil)
+	if caller.GetTraceContext().Current() != rootSpan.Context() {
+		t.Fatal("caller context must be restored after call")
+	}
+	rootSpan.End()
+}
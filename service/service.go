@@ -6,16 +6,19 @@ import (
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/profiler"
 	"github.com/duanhf2012/origin/rpc"
+	"github.com/duanhf2012/origin/trace"
+	"github.com/duanhf2012/origin/util/cfgbind"
 	"github.com/duanhf2012/origin/util/timer"
 	"reflect"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-
-var closeSig chan bool
 var timerDispatcherLen = 10
+var Default_DrainTimeout time.Duration = 10*time.Second
+var funcServiceCfgSource func(serviceName string) string
 
 type IService interface {
 	Init(iservice IService,getClientFun rpc.FuncRpcClient,getServerFun rpc.FuncRpcServer,serviceCfg interface{})
@@ -24,10 +27,17 @@ type IService interface {
 	OnSetup(iservice IService)
 	OnInit() error
 	OnRelease()
+	Release()
 	Wait()
 	Start()
+	PreStop()
+	OnPreStop()
+	Stop()
+	GetDependService() []string
 	GetRpcHandler() rpc.IRpcHandler
 	GetServiceCfg()interface{}
+	NotifyServiceCfgChanged(serviceCfg interface{})
+	OnServiceCfgChanged(serviceCfg interface{})
 	OpenProfiler()
 	GetProfiler() *profiler.Profiler
 }
@@ -43,6 +53,11 @@ type Service struct {
 	startStatus bool
 	eventProcessor event.EventProcessor //事件接收者
 	profiler *profiler.Profiler //性能分析器
+	closeSig chan bool
+	preStopSig chan bool
+	preStopDone chan bool
+	drainTimeout time.Duration //退出时处理剩余队列的最长时间,为0时使用service.json中Queue的DrainTimeout
+	dependServiceList []string //依赖的服务,被依赖的服务先初始化与启动
 }
 
 func (slf *Service) OnSetup(iservice IService){
@@ -51,6 +66,33 @@ func (slf *Service) OnSetup(iservice IService){
 	}
 }
 
+type iBaseService interface {
+	getBaseService() *Service
+}
+
+func (slf *Service) getBaseService() *Service {
+	return slf
+}
+
+//复制s的字段创建新的服务实例,用于卸载后重新安装。s需要是没有Init过的实例,指针、map与slice字段与s共用
+func NewService(s IService) IService {
+	oldValue := reflect.Indirect(reflect.ValueOf(s))
+	newValue := reflect.New(oldValue.Type())
+	newValue.Elem().Set(oldValue)
+	newService := newValue.Interface().(IService)
+	newService.OnSetup(newService)
+	return newService
+}
+
+//声明依赖的服务,需要在node.Setup前调用
+func (slf *Service) DependOn(serviceName ...string){
+	slf.dependServiceList = append(slf.dependServiceList,serviceName...)
+}
+
+func (slf *Service) GetDependService() []string{
+	return slf.dependServiceList
+}
+
 func (slf *Service) OpenProfiler()  {
 	slf.profiler = profiler.RegProfiler(slf.GetName())
 	if slf.profiler==nil {
@@ -69,7 +111,11 @@ func (slf *Service) Init(iservice IService,getClientFun rpc.FuncRpcClient,getSer
 	slf.descendants = map[int64]IModule{}
 	slf.serviceCfg = serviceCfg
 	slf.gorouterNum = 1
+	slf.closeSig = make(chan bool)
+	slf.preStopSig = make(chan bool,1)
+	slf.preStopDone = make(chan bool,1)
 	slf.eventHandler.Init(&slf.eventProcessor)
+	slf.RegEventReciverFunc(event.Sys_Event_ServiceCfgChanged,slf.GetEventHandler(),slf.serviceCfgChangedHandler)
 }
 
 func (slf *Service) SetGoRouterNum(gorouterNum int32) bool {
@@ -85,6 +131,10 @@ func (slf *Service) SetGoRouterNum(gorouterNum int32) bool {
 
 func (slf *Service) Start() {
 	slf.startStatus = true
+	//多个协程无法共用trace上下文
+	if slf.gorouterNum > 1 {
+		slf.GetTraceContext().Disable()
+	}
 	for i:=int32(0);i<slf.gorouterNum;i++{
 		slf.wg.Add(1)
 		go func(){
@@ -101,39 +151,19 @@ func (slf *Service) Run() {
 		rpcRequestChan := slf.GetRpcRequestChan()
 		rpcResponeCallBack := slf.GetRpcResponeChan()
 		eventChan := slf.eventProcessor.GetEventChan()
-		var analyzer *profiler.Analyzer
 		select {
-		case <- closeSig:
+		case <- slf.closeSig:
 			bStop = true
+		case <- slf.preStopSig:
+			slf.runPreStop()
 		case rpcRequest :=<- rpcRequestChan:
-			if slf.profiler!=nil {
-				analyzer = slf.profiler.Push("Req_"+rpcRequest.RpcRequestData.GetServiceMethod())
-			}
-
-			slf.GetRpcHandler().HandlerRpcRequest(rpcRequest)
-			if analyzer!=nil {
-				analyzer.Pop()
-				analyzer = nil
-			}
+			slf.handlerRpcRequest(rpcRequest)
 		case rpcResponeCB := <- rpcResponeCallBack:
-			if slf.profiler!=nil {
-				analyzer = slf.profiler.Push("Res_" + rpcResponeCB.ServiceMethod)
-			}
-			slf.GetRpcHandler().HandlerRpcResponeCB(rpcResponeCB)
-			if analyzer!=nil {
-				analyzer.Pop()
-				analyzer = nil
-			}
+			slf.handlerRpcResponeCB(rpcResponeCB)
 		case ev := <- eventChan:
-			if slf.profiler!=nil {
-				analyzer = slf.profiler.Push(fmt.Sprintf("Event_%d", int(ev.Type)))
-			}
-			slf.eventProcessor.EventHandler(ev)
-			if analyzer!=nil {
-				analyzer.Pop()
-				analyzer = nil
-			}
+			slf.handlerEvent(ev)
 		case t := <- slf.dispatcher.ChanTimer:
+			var analyzer *profiler.Analyzer
 			if slf.profiler!=nil {
 				analyzer = slf.profiler.Push(fmt.Sprintf("Timer_%s", t.GetFunctionName()))
 			}
@@ -145,6 +175,7 @@ func (slf *Service) Run() {
 		}
 
 		if bStop == true {
+			slf.drain()
 			if atomic.AddInt32(&slf.gorouterNum,-1)<=0 {
 				slf.startStatus = false
 				slf.Release()
@@ -155,6 +186,154 @@ func (slf *Service) Run() {
 	}
 }
 
+func (slf *Service) handlerRpcRequest(rpcRequest *rpc.RpcRequest){
+	var analyzer *profiler.Analyzer
+	if slf.profiler!=nil {
+		analyzer = slf.profiler.Push("Req_"+rpcRequest.RpcRequestData.GetServiceMethod())
+	}
+
+	slf.GetRpcHandler().HandlerRpcRequest(rpcRequest)
+	if analyzer!=nil {
+		analyzer.Pop()
+	}
+}
+
+func (slf *Service) handlerRpcResponeCB(rpcResponeCB *rpc.Call){
+	var analyzer *profiler.Analyzer
+	if slf.profiler!=nil {
+		analyzer = slf.profiler.Push("Res_" + rpcResponeCB.ServiceMethod)
+	}
+	slf.GetRpcHandler().HandlerRpcResponeCB(rpcResponeCB)
+	//回调中恢复了发起调用时的trace上下文,处理完后清除
+	slf.GetTraceContext().SetCurrent(trace.SpanContext{})
+	if analyzer!=nil {
+		analyzer.Pop()
+	}
+}
+
+func (slf *Service) handlerEvent(ev *event.Event){
+	var analyzer *profiler.Analyzer
+	if slf.profiler!=nil {
+		analyzer = slf.profiler.Push(fmt.Sprintf("Event_%d", int(ev.Type)))
+	}
+	//在发出事件时的trace中处理事件
+	var span *trace.Span
+	if ev.TraceContext.IsValid() {
+		span = trace.NewSpan(ev.TraceContext,fmt.Sprintf("Event_%d",int(ev.Type)),trace.SpanKindConsumer).Attach(slf.GetTraceContext())
+	}
+	slf.eventProcessor.EventHandler(ev)
+	span.End()
+	if analyzer!=nil {
+		analyzer.Pop()
+	}
+}
+
+func (slf *Service) runPreStop(){
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			l := runtime.Stack(buf, false)
+			err := fmt.Errorf("%v: %s", r, buf[:l])
+			log.Error("core dump info:%+v\n",err)
+		}
+		slf.preStopDone <- true
+	}()
+
+	slf.self.(IService).OnPreStop()
+}
+
+//退出前处理完队列中的rpc请求、异步返回与事件,并等待已发出的异步调用返回,超过drainTimeout后丢弃
+func (slf *Service) drain(){
+	timeout := time.NewTimer(slf.getDrainTimeout())
+	defer timeout.Stop()
+	tick := time.NewTicker(100*time.Millisecond)
+	defer tick.Stop()
+
+	rpcRequestChan := slf.GetRpcRequestChan()
+	rpcResponeCallBack := slf.GetRpcResponeChan()
+	eventChan := slf.eventProcessor.GetEventChan()
+	for {
+		if len(rpcRequestChan)==0 && len(rpcResponeCallBack)==0 && len(eventChan)==0 && slf.GetPendingAsyncCallNum()<=0 {
+			return
+		}
+
+		select {
+		case rpcRequest :=<- rpcRequestChan:
+			slf.handlerRpcRequest(rpcRequest)
+		case rpcResponeCB := <- rpcResponeCallBack:
+			slf.handlerRpcResponeCB(rpcResponeCB)
+		case ev := <- eventChan:
+			slf.handlerEvent(ev)
+		case <- tick.C:
+		case <- timeout.C:
+			log.Error("Service %s drain timeout,drop %d rpc request,%d rpc respone,%d event,%d pending async call.",
+				slf.GetName(),len(rpcRequestChan),len(rpcResponeCallBack),len(eventChan),slf.GetPendingAsyncCallNum())
+			return
+		}
+	}
+}
+
+//在服务协程中调用OnPreStop,并等待其完成。服务协程没有运行时直接返回,OnPreStop超过退出处理时间时不再等待
+func (slf *Service) PreStop(){
+	if slf.startStatus == false {
+		return
+	}
+
+	select {
+	case slf.preStopSig <- true:
+	default:
+		//已经在等待OnPreStop完成
+		return
+	}
+
+	timeout := time.NewTimer(slf.getDrainTimeout())
+	defer timeout.Stop()
+	select {
+	case <- slf.preStopDone:
+	case <- timeout.C:
+		log.Error("Service %s OnPreStop timeout.",slf.GetName())
+	}
+}
+
+//停止对外接收新的请求,在所有服务开始退出前调用
+func (slf *Service) OnPreStop(){
+}
+
+//设置退出时处理剩余队列的最长时间,优先于service.json中Queue的DrainTimeout
+func (slf *Service) SetDrainTimeout(drainTimeout time.Duration){
+	slf.drainTimeout = drainTimeout
+}
+
+func (slf *Service) getDrainTimeout() time.Duration{
+	if slf.drainTimeout > 0 {
+		return slf.drainTimeout
+	}
+	if drainTimeout := slf.GetQueueDrainTimeout();drainTimeout > 0 {
+		return drainTimeout
+	}
+
+	return Default_DrainTimeout
+}
+
+//通知所有协程处理完剩余队列后退出,并等待服务释放完成
+func (slf *Service) Stop(){
+	close(slf.closeSig)
+	slf.Wait()
+}
+
+//打印带有当前trace id的日志,只能在服务协程中调用
+func (slf *Service) LogDebug(format string,a ...interface{}) {
+	log.DebugPrefix(1,slf.GetTraceContext().LogPrefix(),format,a...)
+}
+
+func (slf *Service) LogRelease(format string,a ...interface{}) {
+	log.ReleasePrefix(1,slf.GetTraceContext().LogPrefix(),format,a...)
+}
+
+func (slf *Service) LogError(format string,a ...interface{}) {
+	log.ErrorPrefix(1,slf.GetTraceContext().LogPrefix(),format,a...)
+}
+
 func (slf *Service) GetName() string{
 	return slf.name
 }
@@ -169,6 +348,12 @@ func (slf *Service) Release(){
 		}
 	}()
 	slf.self.OnRelease()
+	//取消向其他服务监听的事件
+	slf.GetEventHandler().Desctory()
+	if slf.profiler != nil {
+		profiler.UnRegProfiler(slf.GetName())
+		slf.profiler = nil
+	}
 	log.Debug("Release Service %s.",slf.GetName())
 }
 
@@ -187,6 +372,44 @@ func (slf *Service) GetServiceCfg()interface{}{
 	return slf.serviceCfg
 }
 
+//设置获取服务配置来源(配置文件与结点)的函数,由node设置,用于输出配置错误
+func SetServiceCfgSource(fun func(serviceName string) string) {
+	funcServiceCfgSource = fun
+}
+
+//将服务配置绑定到结构体指针cfg,支持default与validate tag,详见cfgbind.Bind
+func (slf *Service) BindServiceCfg(cfg interface{}) error {
+	err := cfgbind.Bind(slf.GetServiceCfg(),cfg)
+	if err == nil {
+		return nil
+	}
+
+	source := slf.GetName()
+	if funcServiceCfgSource != nil {
+		source = funcServiceCfgSource(slf.GetName())
+	}
+	return fmt.Errorf("%s config is error,%+v",source,err)
+}
+
+//通知服务配置发生变化,将在服务协程中回调OnServiceCfgChanged
+func (slf *Service) NotifyServiceCfgChanged(serviceCfg interface{}){
+	slf.NotifyEvent(&event.Event{Type:event.Sys_Event_ServiceCfgChanged,Data:serviceCfg})
+}
+
+func (slf *Service) serviceCfgChangedHandler(ev *event.Event){
+	if reflect.DeepEqual(slf.serviceCfg,ev.Data) {
+		return
+	}
+
+	slf.serviceCfg = ev.Data
+	log.Release("Service %s config is changed.",slf.GetName())
+	slf.self.(IService).OnServiceCfgChanged(ev.Data)
+}
+
+//配置重新加载后,服务配置有变化时回调
+func (slf *Service) OnServiceCfgChanged(serviceCfg interface{}){
+}
+
 func (slf *Service) GetProfiler() *profiler.Profiler{
 	return slf.profiler
 }
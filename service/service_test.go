@@ -0,0 +1,96 @@
+package service
+
+import (
+	"github.com/duanhf2012/origin/rpc"
+	"testing"
+	"time"
+)
+
+type testPreStopService struct {
+	Service
+	block chan bool
+}
+
+func (slf *testPreStopService) OnPreStop() {
+	<-slf.block
+}
+
+func newTestPreStopService() *testPreStopService {
+	s := &testPreStopService{block:make(chan bool)}
+	s.OnSetup(s)
+	s.Init(s,nil,nil,nil)
+	return s
+}
+
+func TestPreStopNotStarted(t *testing.T) {
+	s := newTestPreStopService()
+
+	done := make(chan bool)
+	go func() {
+		s.PreStop()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PreStop of the service not started is blocked")
+	}
+}
+
+func TestPreStopTimeout(t *testing.T) {
+	s := newTestPreStopService()
+	s.SetDrainTimeout(100*time.Millisecond)
+	s.Start()
+
+	begin := time.Now()
+	s.PreStop()
+	if time.Since(begin) > time.Second {
+		t.Fatalf("PreStop is not timeout,cost %s",time.Since(begin))
+	}
+
+	close(s.block)
+	s.Stop()
+}
+
+func TestDrainTimeoutConfig(t *testing.T) {
+	s := newTestPreStopService()
+	defer rpc.SetQueueConfig(nil)
+
+	if s.getDrainTimeout() != Default_DrainTimeout {
+		t.Fatalf("expect default drain timeout,but %s",s.getDrainTimeout())
+	}
+
+	rpc.SetQueueConfig(map[string]*rpc.QueueConfig{s.GetName():{DrainTimeout:30*time.Second}})
+	if s.getDrainTimeout() != 30*time.Second {
+		t.Fatalf("expect drain timeout in service.json,but %s",s.getDrainTimeout())
+	}
+
+	//代码中设置的优先
+	s.SetDrainTimeout(time.Second)
+	if s.getDrainTimeout() != time.Second {
+		t.Fatalf("expect drain timeout set by SetDrainTimeout,but %s",s.getDrainTimeout())
+	}
+}
+
+type testCopyService struct {
+	Service
+	listenAddr string
+}
+
+func TestNewServiceCopyFields(t *testing.T) {
+	s := &testCopyService{listenAddr:"127.0.0.1:9000"}
+	s.DependOn("DBService")
+	s.OnSetup(s)
+
+	newService,ok := NewService(s).(*testCopyService)
+	if ok == false || newService == s {
+		t.Fatal("expect a new *testCopyService")
+	}
+	if newService.listenAddr != s.listenAddr {
+		t.Fatalf("field of setup instance is lost:%q",newService.listenAddr)
+	}
+	if newService.GetName() != "testCopyService" || len(newService.GetDependService()) != 1 {
+		t.Fatalf("name or depend service is lost:%s %+v",newService.GetName(),newService.GetDependService())
+	}
+}
@@ -1,32 +1,110 @@
 package service
 
+import (
+	"fmt"
+	"github.com/duanhf2012/origin/profiler"
+	"github.com/duanhf2012/origin/rpc"
+	"sort"
+	"sync"
+)
+
 //本地所有的service
 var mapServiceName map[string]IService
+//按安装顺序排列的service,Init后按依赖关系排序
+var setupServiceList []IService
+var serviceLocker sync.RWMutex
 
 func init(){
 	mapServiceName = map[string]IService{}
+	profiler.AddReportFunction("ServiceQueue",queueReport)
 }
 
-func Init(chanCloseSig chan bool) {
-	closeSig=chanCloseSig
+func Init() error {
+	serviceLocker.Lock()
+	sortServiceList,err := sortServiceByDepend(setupServiceList)
+	if err != nil {
+		serviceLocker.Unlock()
+		return err
+	}
+	setupServiceList = sortServiceList
+	serviceLocker.Unlock()
 
-	for _,s := range mapServiceName {
-		s.OnInit()
+	for i,s := range sortServiceList {
+		err = s.OnInit()
+		if err != nil {
+			//释放已经初始化的服务
+			for j:=i-1;j>=0;j-- {
+				sortServiceList[j].Release()
+			}
+			return fmt.Errorf("service %s OnInit is fail:%+v",s.GetName(),err)
+		}
 	}
+
+	return nil
 }
 
+//按依赖关系排序,被依赖的服务排在前面,无依赖关系时保持原有顺序
+func sortServiceByDepend(serviceList []IService) ([]IService,error) {
+	const (
+		unVisited = iota
+		visiting
+		visited
+	)
+	mapStatus := make(map[string]int,len(serviceList))
+	sortList := make([]IService,0,len(serviceList))
+
+	var visit func(s IService,path []string) error
+	visit = func(s IService,path []string) error {
+		switch mapStatus[s.GetName()] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("service depend on cycle:%v",append(path,s.GetName()))
+		}
+
+		mapStatus[s.GetName()] = visiting
+		for _,dependName := range s.GetDependService() {
+			//不在本结点的服务不参与排序
+			dependService,ok := mapServiceName[dependName]
+			if ok == false {
+				continue
+			}
+			err := visit(dependService,append(path,s.GetName()))
+			if err != nil {
+				return err
+			}
+		}
+		mapStatus[s.GetName()] = visited
+		sortList = append(sortList,s)
+		return nil
+	}
+
+	for _,s := range serviceList {
+		err := visit(s,nil)
+		if err != nil {
+			return nil,err
+		}
+	}
+
+	return sortList,nil
+}
 
 func Setup(s IService) bool {
+	serviceLocker.Lock()
+	defer serviceLocker.Unlock()
 	_,ok := mapServiceName[s.GetName()]
 	if ok == true {
 		return false
 	}
 
 	mapServiceName[s.GetName()] = s
+	setupServiceList = append(setupServiceList,s)
 	return true
 }
 
 func GetService(servicename string) IService {
+	serviceLocker.RLock()
+	defer serviceLocker.RUnlock()
 	s,ok := mapServiceName[servicename]
 	if ok == false {
 		return nil
@@ -35,16 +113,135 @@ func GetService(servicename string) IService {
 	return s
 }
 
+func getServiceList() []IService {
+	serviceLocker.RLock()
+	defer serviceLocker.RUnlock()
+	serviceList := make([]IService,len(setupServiceList))
+	copy(serviceList,setupServiceList)
+	return serviceList
+}
+
+func removeService(serviceName string) {
+	serviceLocker.Lock()
+	defer serviceLocker.Unlock()
+	delete(mapServiceName,serviceName)
+	for i,s := range setupServiceList {
+		if s.GetName() == serviceName {
+			setupServiceList = append(setupServiceList[:i],setupServiceList[i+1:]...)
+			break
+		}
+	}
+}
+
+//运行中安装服务,服务需要先调用Init
+func Install(s IService) error {
+	if Setup(s) == false {
+		return fmt.Errorf("service %s is already installed",s.GetName())
+	}
+
+	err := s.OnInit()
+	if err != nil {
+		removeService(s.GetName())
+		s.Release()
+		return fmt.Errorf("service %s OnInit is fail:%+v",s.GetName(),err)
+	}
+
+	s.Start()
+	return nil
+}
+
+//运行中卸载服务,处理完剩余队列后释放
+func Uninstall(serviceName string) error {
+	s := GetService(serviceName)
+	if s == nil {
+		return fmt.Errorf("service %s is not installed",serviceName)
+	}
+
+	s.PreStop()
+	s.Stop()
+	removeService(serviceName)
+	return nil
+}
+
+//通知所有服务重新加载配置,配置有变化的服务将在各自协程中回调OnServiceCfgChanged
+func NotifyAllServiceCfgChanged(getServiceCfg func(serviceName string) interface{}){
+	for _,s := range getServiceList() {
+		s.NotifyServiceCfgChanged(getServiceCfg(s.GetName()))
+	}
+}
 
 func Start(){
-	for _,s := range mapServiceName {
+	for _,s := range getServiceList() {
 		s.Start()
 	}
 }
 
-func WaitStop(){
-	for _,s := range mapServiceName {
-		s.Wait()
+//按启动的相反顺序停止服务:
+//1.所有服务调用OnPreStop停止接收新的请求
+//2.逐个服务处理完剩余队列后释放
+func StopAllService(){
+	serviceList := getServiceList()
+	for i:=len(serviceList)-1;i>=0;i-- {
+		serviceList[i].PreStop()
+	}
+
+	for i:=len(serviceList)-1;i>=0;i-- {
+		serviceList[i].Stop()
+	}
+}
+
+//返回服务的rpc方法描述,serviceName为空时返回所有服务
+func GetRpcServiceDesc(serviceName string) []rpc.ServiceDesc {
+	var serviceDescList []rpc.ServiceDesc
+	for _,s := range getServiceList() {
+		if serviceName != "" && s.GetName() != serviceName {
+			continue
+		}
+		serviceDescList = append(serviceDescList,rpc.ServiceDesc{Name:s.GetName(),MethodList:s.GetRpcHandler().GetRpcMethodDesc()})
 	}
+
+	return serviceDescList
 }
 
+//按安装顺序返回所有服务名
+func GetServiceNameList() []string {
+	serviceList := getServiceList()
+	serviceNameList := make([]string,0,len(serviceList))
+	for _,s := range serviceList {
+		serviceNameList = append(serviceNameList,s.GetName())
+	}
+
+	return serviceNameList
+}
+
+//返回所有服务的队列状态,map[ServiceName]QueueStats
+func GetAllQueueStats() map[string]rpc.QueueStats {
+	mapQueueStats := map[string]rpc.QueueStats{}
+	for _,s := range getServiceList() {
+		mapQueueStats[s.GetName()] = s.GetRpcHandler().GetQueueStats()
+	}
+
+	return mapQueueStats
+}
+
+//输出队列中有积压或拒绝过请求的服务,都正常时返回空字符串
+func queueReport() string {
+	mapQueueStats := GetAllQueueStats()
+	serviceList := make([]string,0,len(mapQueueStats))
+	for serviceName := range mapQueueStats {
+		serviceList = append(serviceList,serviceName)
+	}
+	sort.Strings(serviceList)
+
+	var strReport string
+	for _,serviceName := range serviceList {
+		stats := mapQueueStats[serviceName]
+		if stats.RequestLen == 0 && stats.ResponeLen == 0 && stats.RejectNum == 0 && stats.DropNum == 0 && stats.BlockNum == 0 {
+			continue
+		}
+		strReport += fmt.Sprintf("service %s request %d/%d,respone %d/%d,reject %d,drop %d,block %d\n",serviceName,
+			stats.RequestLen,stats.RequestCap,stats.ResponeLen,stats.ResponeCap,stats.RejectNum,stats.DropNum,stats.BlockNum)
+	}
+
+	return strReport
+}
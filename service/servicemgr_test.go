@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//记录各服务回调的顺序
+type testOrderService struct {
+	Service
+	initErr error
+	record *[]string
+}
+
+func (slf *testOrderService) OnInit() error {
+	*slf.record = append(*slf.record,"init:"+slf.GetName())
+	return slf.initErr
+}
+
+func (slf *testOrderService) Release() {
+	*slf.record = append(*slf.record,"release:"+slf.GetName())
+}
+
+func (slf *testOrderService) PreStop() {
+	*slf.record = append(*slf.record,"prestop:"+slf.GetName())
+}
+
+func (slf *testOrderService) Stop() {
+	*slf.record = append(*slf.record,"stop:"+slf.GetName())
+}
+
+type testServiceDef struct {
+	name string
+	dependList []string
+	initErr error
+}
+
+//按defList的顺序Setup服务
+func setupTestServices(defList []testServiceDef) *[]string {
+	mapServiceName = map[string]IService{}
+	setupServiceList = nil
+	record := &[]string{}
+	for _,def := range defList {
+		s := &testOrderService{initErr:def.initErr,record:record}
+		s.name = def.name
+		s.DependOn(def.dependList...)
+		Setup(s)
+	}
+	return record
+}
+
+func resetTestServices() {
+	mapServiceName = map[string]IService{}
+	setupServiceList = nil
+}
+
+func TestSortServiceByDepend(t *testing.T) {
+	defer resetTestServices()
+	testList := []struct{
+		name string
+		defList []testServiceDef
+		sortList []string
+		err string
+	}{
+		{"no depend",[]testServiceDef{{"A",nil,nil},{"B",nil,nil},{"C",nil,nil}},[]string{"A","B","C"},""},
+		{"depend later service",[]testServiceDef{{"A",[]string{"C"},nil},{"B",nil,nil},{"C",nil,nil}},[]string{"C","A","B"},""},
+		{"depend chain",[]testServiceDef{{"A",[]string{"B"},nil},{"B",[]string{"C"},nil},{"C",nil,nil}},[]string{"C","B","A"},""},
+		{"shared depend",[]testServiceDef{{"A",[]string{"C"},nil},{"B",[]string{"C"},nil},{"C",nil,nil}},[]string{"C","A","B"},""},
+		{"missing depend",[]testServiceDef{{"A",[]string{"Remote"},nil},{"B",nil,nil}},[]string{"A","B"},""},
+		{"self cycle",[]testServiceDef{{"A",[]string{"A"},nil}},nil,"cycle:[A A]"},
+		{"cycle",[]testServiceDef{{"A",[]string{"B"},nil},{"B",[]string{"C"},nil},{"C",[]string{"A"},nil}},nil,"cycle:[A B C A]"},
+	}
+
+	for _,test := range testList {
+		setupTestServices(test.defList)
+		sortList,err := sortServiceByDepend(setupServiceList)
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(),test.err) == false {
+				t.Fatalf("%s:expect error %q,but %+v",test.name,test.err,err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s:sort is fail:%+v",test.name,err)
+		}
+
+		var nameList []string
+		for _,s := range sortList {
+			nameList = append(nameList,s.GetName())
+		}
+		if reflect.DeepEqual(nameList,test.sortList) == false {
+			t.Fatalf("%s:expect %v,but %v",test.name,test.sortList,nameList)
+		}
+	}
+}
+
+func TestInitByDepend(t *testing.T) {
+	defer resetTestServices()
+	testList := []struct{
+		name string
+		defList []testServiceDef
+		record []string
+		err string
+	}{
+		{"init by depend",[]testServiceDef{{"A",[]string{"B"},nil},{"B",nil,nil}},[]string{"init:B","init:A"},""},
+		{"cycle is not init",[]testServiceDef{{"A",[]string{"B"},nil},{"B",[]string{"A"},nil}},nil,"cycle"},
+		//初始化失败时按相反顺序释放已初始化的服务
+		{"rollback",[]testServiceDef{{"A",[]string{"B"},nil},{"B",nil,nil},{"C",nil,fmt.Errorf("init error")},{"D",nil,nil}},
+			[]string{"init:B","init:A","init:C","release:A","release:B"},"service C OnInit is fail"},
+	}
+
+	for _,test := range testList {
+		record := setupTestServices(test.defList)
+		err := Init()
+		if test.err == "" && err != nil {
+			t.Fatalf("%s:init is fail:%+v",test.name,err)
+		}
+		if test.err != "" && (err == nil || strings.Contains(err.Error(),test.err) == false) {
+			t.Fatalf("%s:expect error %q,but %+v",test.name,test.err,err)
+		}
+		if reflect.DeepEqual(*record,test.record) == false && (len(*record) != 0 || len(test.record) != 0) {
+			t.Fatalf("%s:expect %v,but %v",test.name,test.record,*record)
+		}
+	}
+}
+
+func TestStopAllServiceReverse(t *testing.T) {
+	defer resetTestServices()
+	record := setupTestServices([]testServiceDef{{"A",[]string{"C"},nil},{"B",nil,nil},{"C",nil,nil}})
+	if err := Init();err != nil {
+		t.Fatalf("init is fail:%+v",err)
+	}
+
+	*record = nil
+	StopAllService()
+	expect := []string{"prestop:B","prestop:A","prestop:C","stop:B","stop:A","stop:C"}
+	if reflect.DeepEqual(*record,expect) == false {
+		t.Fatalf("expect %v,but %v",expect,*record)
+	}
+}
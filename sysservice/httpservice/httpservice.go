@@ -3,8 +3,10 @@ package httpservice
 import (
 	"fmt"
 	"github.com/duanhf2012/origin/event"
+	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/network"
 	"github.com/duanhf2012/origin/service"
+	"github.com/duanhf2012/origin/trace"
 	"github.com/duanhf2012/origin/util/uuid"
 	jsoniter "github.com/json-iterator/go"
 	"io"
@@ -298,7 +300,14 @@ func (slf *HttpRouter) Router(session *HttpSession){
 
 
 func (slf *HttpService) HttpEventHandler(ev *event.Event)  {
-	ev.Data.(*HttpSession).Handle()
+	session := ev.Data.(*HttpSession)
+	//请求头中带有traceparent时加入调用方的trace,否则开始新的trace
+	if trace.IsOpen() {
+		parent,_ := trace.ParseTraceParent(session.r.Header.Get(trace.HeaderTraceParent))
+		span := trace.NewSpan(parent,session.r.Method+" "+session.r.URL.Path,trace.SpanKindServer).Attach(slf.GetTraceContext())
+		defer span.End()
+	}
+	session.Handle()
 }
 
 func (slf *HttpService) SetHttpRouter(httpRouter IHttpRouter,eventHandler event.IEventHandler) {
@@ -361,56 +370,34 @@ func (slf *HttpSession) redirects() {
 
 
 
-func (slf *HttpService) OnInit() error {
-	iConfig := slf.GetServiceCfg()
-	if iConfig == nil {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
-	tcpCfg := iConfig.(map[string]interface{})
-	addr,ok := tcpCfg["ListenAddr"]
-	if ok == false {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
-	var readTimeout time.Duration = Default_ReadTimeout
-	var writeTimeout time.Duration = Default_WriteTimeout
+//service.json中HttpService的配置,时间单位为毫秒
+type HttpCfg struct {
+	ListenAddr string `validate:"required"`
+	ReadTimeout int `validate:"min=0"`
+	WriteTimeout int `validate:"min=0"`
+	ProcessTimeout int `validate:"min=0"`
+	CAFile []network.CAFile
+}
 
-	if cfgRead,ok := tcpCfg["ReadTimeout"];ok == true {
-		readTimeout = time.Duration(cfgRead.(float64))*time.Millisecond
+func (slf *HttpService) OnInit() error {
+	httpCfg := HttpCfg{
+		ReadTimeout:int(Default_ReadTimeout/time.Millisecond),
+		WriteTimeout:int(Default_WriteTimeout/time.Millisecond),
+		ProcessTimeout:int(Default_ProcessTimeout/time.Millisecond),
 	}
-
-	if cfgWrite,ok := tcpCfg["WriteTimeout"];ok == true {
-		writeTimeout = time.Duration(cfgWrite.(float64))*time.Millisecond
+	err := slf.BindServiceCfg(&httpCfg)
+	if err != nil {
+		return err
 	}
 
-	slf.processTimeout = Default_ProcessTimeout
-	if cfgProcessTimeout,ok := tcpCfg["ProcessTimeout"];ok == true {
-		slf.processTimeout = time.Duration(cfgProcessTimeout.(float64))*time.Millisecond
-	}
+	slf.processTimeout = time.Duration(httpCfg.ProcessTimeout)*time.Millisecond
+	slf.httpServer.Init(httpCfg.ListenAddr, slf, time.Duration(httpCfg.ReadTimeout)*time.Millisecond, time.Duration(httpCfg.WriteTimeout)*time.Millisecond)
 
-	slf.httpServer.Init(addr.(string), slf, readTimeout, writeTimeout)
 	//Set CAFile
-	caFileList,ok := tcpCfg["CAFile"]
-	if ok == false {
-		return nil
-	}
-	iCaList := caFileList.([]interface{})
 	var caFile [] network.CAFile
-	for _,i := range iCaList {
-		mapCAFile := i.(map[string]interface{})
-		c,ok := mapCAFile["Certfile"]
-		if ok == false{
-			continue
-		}
-		k,ok := mapCAFile["Certfile"]
-		if ok == false{
-			continue
-		}
-
-		if c.(string)!="" && k.(string)!="" {
-			caFile = append(caFile,network.CAFile{
-				Certfile:  c.(string),
-				Keyfile:  k.(string),
-			})
+	for _,c := range httpCfg.CAFile {
+		if c.Certfile!="" && c.Keyfile!="" {
+			caFile = append(caFile,c)
 		}
 	}
 	slf.httpServer.SetCAFile(caFile)
@@ -418,6 +405,16 @@ func (slf *HttpService) OnInit() error {
 	return nil
 }
 
+func (slf *HttpService) OnPreStop() {
+	//Shutdown会等待处理中的请求,而请求需要在本服务协程中处理,所以不能在此阻塞
+	go func() {
+		err := slf.httpServer.Shutdown(slf.processTimeout)
+		if err != nil {
+			log.Error("%s shutdown http server is error:%+v",slf.GetName(),err)
+		}
+	}()
+}
+
 func (slf *HttpService) SetAllowCORS(corsHeader *CORSHeader) {
 	slf.corsHeader = corsHeader
 }
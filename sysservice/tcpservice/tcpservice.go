@@ -6,6 +6,8 @@ import (
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/network"
 	"github.com/duanhf2012/origin/service"
+	"github.com/duanhf2012/origin/trace"
+	"strconv"
 	"sync"
 )
 
@@ -40,48 +42,47 @@ const Default_LittleEndian = false
 const Default_MinMsgLen = 2
 const Default_MaxMsgLen = 65535
 
+//service.json中TcpService的配置
+type TcpCfg struct {
+	ListenAddr string `validate:"required"`
+	MaxConnNum int `validate:"min=1"`
+	PendingWriteNum int `validate:"min=1"`
+	LittleEndian bool
+	MinMsgLen uint32
+	MaxMsgLen uint32 `validate:"min=1"`
+}
+
 func (slf *TcpService) OnInit() error{
-	iConfig := slf.GetServiceCfg()
-	if iConfig == nil {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
-	tcpCfg := iConfig.(map[string]interface{})
-	addr,ok := tcpCfg["ListenAddr"]
-	if ok == false {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
-	slf.tcpServer.Addr = addr.(string)
-	slf.tcpServer.MaxConnNum = Default_MaxConnNum
-	slf.tcpServer.PendingWriteNum = Default_PendingWriteNum
-	slf.tcpServer.LittleEndian = Default_LittleEndian
-	slf.tcpServer.MinMsgLen = Default_MinMsgLen
-	slf.tcpServer.MaxMsgLen = Default_MaxMsgLen
-	MaxConnNum,ok := tcpCfg["MaxConnNum"]
-	if ok == true {
-		slf.tcpServer.MaxConnNum = int(MaxConnNum.(float64))
-	}
-	PendingWriteNum,ok := tcpCfg["PendingWriteNum"]
-	if ok == true {
-		slf.tcpServer.PendingWriteNum = int(PendingWriteNum.(float64))
-	}
-	LittleEndian,ok := tcpCfg["LittleEndian"]
-	if ok == true {
-		slf.tcpServer.LittleEndian = LittleEndian.(bool)
-	}
-	MinMsgLen,ok := tcpCfg["MinMsgLen"]
-	if ok == true {
-		slf.tcpServer.MinMsgLen = uint32(MinMsgLen.(float64))
+	tcpCfg := TcpCfg{
+		MaxConnNum:Default_MaxConnNum,
+		PendingWriteNum:Default_PendingWriteNum,
+		LittleEndian:Default_LittleEndian,
+		MinMsgLen:Default_MinMsgLen,
+		MaxMsgLen:Default_MaxMsgLen,
 	}
-	MaxMsgLen,ok := tcpCfg["MaxMsgLen"]
-	if ok == true {
-		slf.tcpServer.MaxMsgLen = uint32(MaxMsgLen.(float64))
+	err := slf.BindServiceCfg(&tcpCfg)
+	if err != nil {
+		return err
 	}
+
+	slf.tcpServer.Addr = tcpCfg.ListenAddr
+	slf.tcpServer.MaxConnNum = tcpCfg.MaxConnNum
+	slf.tcpServer.PendingWriteNum = tcpCfg.PendingWriteNum
+	slf.tcpServer.LittleEndian = tcpCfg.LittleEndian
+	slf.tcpServer.MinMsgLen = tcpCfg.MinMsgLen
+	slf.tcpServer.MaxMsgLen = tcpCfg.MaxMsgLen
 	slf.mapClient = make( map[uint64] *Client,slf.tcpServer.MaxConnNum)
 	slf.tcpServer.NewAgent =slf.NewClient
 	slf.tcpServer.Start()
 	return nil
 }
 
+//停止监听后在其他协程中断开所有客户端连接。连接关闭时向本服务投递事件,在服务协程中等待连接退出会死锁
+func (slf *TcpService) OnPreStop() {
+	slf.tcpServer.CloseListener()
+	go slf.tcpServer.Close()
+}
+
 func (slf *TcpService) TcpEventHandler(ev *event.Event) {
 	pack := ev.Data.(*TcpPack)
 	switch pack.Type {
@@ -92,6 +93,13 @@ func (slf *TcpService) TcpEventHandler(ev *event.Event) {
 	case TPT_UnknownPack:
 		pack.MsgProcessor.UnknownMsgRoute(pack.Data,pack.ClientId)
 	case TPT_Pack:
+		//每个客户端消息开始一个新的trace
+		span := trace.NewSpan(trace.SpanContext{},"TcpService.MsgRoute",trace.SpanKindServer).Attach(slf.GetTraceContext())
+		defer span.End()
+		if span != nil {
+			span.SetAttribute("tcp.client_id",strconv.FormatUint(pack.ClientId,10))
+			span.SetAttribute("tcp.msg_type",fmt.Sprintf("%T",pack.Data))
+		}
 		pack.MsgProcessor.MsgRoute(pack.Data, pack.ClientId)
 	}
 }
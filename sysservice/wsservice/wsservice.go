@@ -6,6 +6,8 @@ import (
 	"github.com/duanhf2012/origin/log"
 	"github.com/duanhf2012/origin/network"
 	"github.com/duanhf2012/origin/service"
+	"github.com/duanhf2012/origin/trace"
+	"strconv"
 	"sync"
 )
 
@@ -39,35 +41,30 @@ const Default_WS_MaxConnNum = 3000
 const Default_WS_PendingWriteNum = 10000
 const Default_WS_MaxMsgLen = 65535
 
+//service.json中WSService的配置
+type WSCfg struct {
+	ListenAddr string `validate:"required"`
+	MaxConnNum int `validate:"min=1"`
+	PendingWriteNum int `validate:"min=1"`
+	MaxMsgLen uint32 `validate:"min=1"`
+}
 
-func (slf *WSService) OnInit() error{
-	iConfig := slf.GetServiceCfg()
-	if iConfig == nil {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
-	wsCfg := iConfig.(map[string]interface{})
-	addr,ok := wsCfg["ListenAddr"]
-	if ok == false {
-		return fmt.Errorf("%s service config is error!",slf.GetName())
-	}
 
-	slf.wsServer.Addr = addr.(string)
-	slf.wsServer.MaxConnNum = Default_WS_MaxConnNum
-	slf.wsServer.PendingWriteNum = Default_WS_PendingWriteNum
-	slf.wsServer.MaxMsgLen = Default_WS_MaxMsgLen
-	MaxConnNum,ok := wsCfg["MaxConnNum"]
-	if ok == true {
-		slf.wsServer.MaxConnNum = int(MaxConnNum.(float64))
+func (slf *WSService) OnInit() error{
+	wsCfg := WSCfg{
+		MaxConnNum:Default_WS_MaxConnNum,
+		PendingWriteNum:Default_WS_PendingWriteNum,
+		MaxMsgLen:Default_WS_MaxMsgLen,
 	}
-	PendingWriteNum,ok := wsCfg["PendingWriteNum"]
-	if ok == true {
-		slf.wsServer.PendingWriteNum = int(PendingWriteNum.(float64))
+	err := slf.BindServiceCfg(&wsCfg)
+	if err != nil {
+		return err
 	}
 
-	MaxMsgLen,ok := wsCfg["MaxMsgLen"]
-	if ok == true {
-		slf.wsServer.MaxMsgLen = uint32(MaxMsgLen.(float64))
-	}
+	slf.wsServer.Addr = wsCfg.ListenAddr
+	slf.wsServer.MaxConnNum = wsCfg.MaxConnNum
+	slf.wsServer.PendingWriteNum = wsCfg.PendingWriteNum
+	slf.wsServer.MaxMsgLen = wsCfg.MaxMsgLen
 
 	slf.mapClient = make( map[uint64] *WSClient,slf.wsServer.MaxConnNum)
 	slf.wsServer.NewAgent =slf.NewWSClient
@@ -75,6 +72,12 @@ func (slf *WSService) OnInit() error{
 	return nil
 }
 
+//停止监听后在其他协程中断开所有客户端连接。连接关闭时向本服务投递事件,在服务协程中等待连接退出会死锁
+func (slf *WSService) OnPreStop() {
+	slf.wsServer.CloseListener()
+	go slf.wsServer.Close()
+}
+
 func (slf *WSService) WSEventHandler(ev *event.Event) {
 	pack := ev.Data.(*WSPack)
 	switch pack.Type {
@@ -85,6 +88,13 @@ func (slf *WSService) WSEventHandler(ev *event.Event) {
 	case WPT_UnknownPack:
 		pack.MsgProcessor.UnknownMsgRoute(pack.Data,pack.ClientId)
 	case WPT_Pack:
+		//每个客户端消息开始一个新的trace
+		span := trace.NewSpan(trace.SpanContext{},"WSService.MsgRoute",trace.SpanKindServer).Attach(slf.GetTraceContext())
+		defer span.End()
+		if span != nil {
+			span.SetAttribute("ws.client_id",strconv.FormatUint(pack.ClientId,10))
+			span.SetAttribute("ws.msg_type",fmt.Sprintf("%T",pack.Data))
+		}
 		pack.MsgProcessor.MsgRoute(pack.Data, pack.ClientId)
 	}
 }
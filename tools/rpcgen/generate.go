@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const rpcPkgPath = "github.com/duanhf2012/origin/rpc"
+
+type rpcMethod struct {
+	Name string       //去掉RPC_前缀的方法名
+	MethodName string //如RPC_Add
+	ArgType string    //如*Req
+	ReplyType string  //如*Ret
+	ReplyElem string  //如Ret
+}
+
+type rpcService struct {
+	Name string
+	MethodList []rpcMethod
+}
+
+type packageInfo struct {
+	PkgName string
+	ImportList []string
+	ServiceList []rpcService
+}
+
+//解析dir中的包,按RpcHandler.suitableMethods的规则收集RPC_方法
+func parsePackage(dir string,typeList []string,outputFile string) (*packageInfo,error) {
+	fset := token.NewFileSet()
+	mapPkg,err := parser.ParseDir(fset,dir,func(info os.FileInfo) bool {
+		return strings.HasSuffix(info.Name(),"_test.go") == false && info.Name() != outputFile
+	},0)
+	if err != nil {
+		return nil,err
+	}
+	if len(mapPkg) != 1 {
+		return nil,fmt.Errorf("expect one package in %s, found %d",dir,len(mapPkg))
+	}
+
+	var pkg *ast.Package
+	for _,p := range mapPkg {
+		pkg = p
+	}
+
+	mapType := map[string]bool{}
+	for _,typeName := range typeList {
+		mapType[typeName] = true
+	}
+
+	pkgInfo := &packageInfo{PkgName:pkg.Name}
+	mapService := map[string]*rpcService{}
+	mapImport := map[string]bool{}
+	fileNameList := make([]string,0,len(pkg.Files))
+	for fileName := range pkg.Files {
+		fileNameList = append(fileNameList,fileName)
+	}
+	sort.Strings(fileNameList)
+
+	for _,fileName := range fileNameList {
+		file := pkg.Files[fileName]
+		for _,decl := range file.Decls {
+			funcDecl,ok := decl.(*ast.FuncDecl)
+			if ok == false || funcDecl.Recv == nil || strings.HasPrefix(funcDecl.Name.Name,"RPC_") == false {
+				continue
+			}
+
+			typeName := receiverTypeName(funcDecl.Recv.List[0].Type)
+			if len(mapType) > 0 && mapType[typeName] == false {
+				continue
+			}
+
+			method,err := parseMethod(file,funcDecl,mapImport)
+			if err != nil {
+				return nil,fmt.Errorf("%s %s.%s %+v",fset.Position(funcDecl.Pos()),typeName,funcDecl.Name.Name,err)
+			}
+			if method == nil {
+				continue
+			}
+
+			service,ok := mapService[typeName]
+			if ok == false {
+				service = &rpcService{Name:typeName}
+				mapService[typeName] = service
+			}
+			service.MethodList = append(service.MethodList,*method)
+		}
+	}
+
+	for _,typeName := range typeList {
+		if _,ok := mapService[typeName];ok == false {
+			return nil,fmt.Errorf("type %s has no RPC_ method",typeName)
+		}
+	}
+	if len(mapService) == 0 {
+		return nil,fmt.Errorf("no RPC_ method found in %s",dir)
+	}
+
+	for _,service := range mapService {
+		if err = service.checkName();err != nil {
+			return nil,err
+		}
+		pkgInfo.ServiceList = append(pkgInfo.ServiceList,*service)
+	}
+	sort.Slice(pkgInfo.ServiceList,func(i, j int) bool {
+		return pkgInfo.ServiceList[i].Name < pkgInfo.ServiceList[j].Name
+	})
+	for imp := range mapImport {
+		pkgInfo.ImportList = append(pkgInfo.ImportList,imp)
+	}
+	sort.Strings(pkgInfo.ImportList)
+
+	return pkgInfo,nil
+}
+
+func receiverTypeName(expr ast.Expr) string {
+	if star,ok := expr.(*ast.StarExpr);ok == true {
+		expr = star.X
+	}
+	if ident,ok := expr.(*ast.Ident);ok == true {
+		return ident.Name
+	}
+
+	return ""
+}
+
+//返回nil表示不生成该方法,带IRawAdditionParam的方法只能通过RawGoNode调用
+func parseMethod(file *ast.File,funcDecl *ast.FuncDecl,mapImport map[string]bool) (*rpcMethod,error) {
+	results := fieldTypeList(funcDecl.Type.Results)
+	if len(results) != 1 {
+		return nil,fmt.Errorf("the number of returned arguments must be 1")
+	}
+	if ident,ok := results[0].(*ast.Ident);ok == false || ident.Name != "error" {
+		return nil,fmt.Errorf("the return parameter must be of type error")
+	}
+
+	params := fieldTypeList(funcDecl.Type.Params)
+	if len(params) < 2 || len(params) > 3 {
+		return nil,fmt.Errorf("unsupported parameter format")
+	}
+	if sel,ok := params[0].(*ast.SelectorExpr);ok == true && sel.Sel.Name == "IRawAdditionParam" {
+		return nil,nil
+	}
+	if len(params) != 2 {
+		return nil,fmt.Errorf("unsupported parameter format")
+	}
+
+	for _,param := range params {
+		if _,ok := param.(*ast.StarExpr);ok == false {
+			return nil,fmt.Errorf("parameter %s must be a pointer",types.ExprString(param))
+		}
+		if isExportedOrBuiltinType(param) == false {
+			return nil,fmt.Errorf("unsupported parameter type %s",types.ExprString(param))
+		}
+		collectImport(file,param,mapImport)
+	}
+
+	method := &rpcMethod{
+		Name:strings.TrimPrefix(funcDecl.Name.Name,"RPC_"),
+		MethodName:funcDecl.Name.Name,
+		ArgType:types.ExprString(params[0]),
+		ReplyType:types.ExprString(params[1]),
+		ReplyElem:types.ExprString(params[1].(*ast.StarExpr).X),
+	}
+	if method.Name == "" {
+		return nil,fmt.Errorf("method name is empty")
+	}
+
+	return method,nil
+}
+
+func fieldTypeList(fieldList *ast.FieldList) []ast.Expr {
+	if fieldList == nil {
+		return nil
+	}
+
+	var typeList []ast.Expr
+	for _,field := range fieldList.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0;i < n;i++ {
+			typeList = append(typeList,field.Type)
+		}
+	}
+
+	return typeList
+}
+
+//与RpcHandler.isExportedOrBuiltinType一致,未导出的具名类型不能作为参数
+func isExportedOrBuiltinType(expr ast.Expr) bool {
+	for {
+		star,ok := expr.(*ast.StarExpr)
+		if ok == false {
+			break
+		}
+		expr = star.X
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.IsExported() || types.Universe.Lookup(t.Name) != nil
+	case *ast.SelectorExpr:
+		return t.Sel.IsExported()
+	}
+
+	return true
+}
+
+//参数类型引用了其他包时,生成的文件需要同样的import
+func collectImport(file *ast.File,expr ast.Expr,mapImport map[string]bool) {
+	ast.Inspect(expr,func(node ast.Node) bool {
+		sel,ok := node.(*ast.SelectorExpr)
+		if ok == false {
+			return true
+		}
+		ident,ok := sel.X.(*ast.Ident)
+		if ok == false {
+			return true
+		}
+
+		for _,imp := range file.Imports {
+			importPath,_ := strconv.Unquote(imp.Path.Value)
+			name := path.Base(importPath)
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name != ident.Name || importPath == rpcPkgPath || importPath == "context" {
+				continue
+			}
+
+			if imp.Name != nil {
+				mapImport[imp.Name.Name+" "+imp.Path.Value] = true
+			}else{
+				mapImport[imp.Path.Value] = true
+			}
+		}
+		return false
+	})
+}
+
+//同步与异步方法名不能重复
+func (slf *rpcService) checkName() error {
+	mapName := map[string]string{}
+	for _,method := range slf.MethodList {
+		for _,name := range []string{method.Name,"Async"+method.Name} {
+			if other,ok := mapName[name];ok == true {
+				return fmt.Errorf("%s.%s and %s.%s generate the same method %s",slf.Name,method.MethodName,slf.Name,other,name)
+			}
+			mapName[name] = method.MethodName
+		}
+	}
+
+	return nil
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.PkgName}}
+
+import (
+	"context"
+	"github.com/duanhf2012/origin/rpc"
+{{- range .ImportList}}
+	{{.}}
+{{- end}}
+)
+{{range $service := .ServiceList}}
+//{{$service.Name}}的rpc客户端
+type {{$service.Name}}Client struct {
+	rpcHandler rpc.IRpcHandler
+	NodeId int         //指定调用的结点,为0时按负载均衡选择结点
+	ServiceName string //默认为{{$service.Name}},调用其他子网时为subnet/{{$service.Name}}
+}
+
+//rpcHandler为发起调用的服务,异步调用的回调在该服务的协程中执行
+func New{{$service.Name}}Client(rpcHandler rpc.IRpcHandler) *{{$service.Name}}Client {
+	return &{{$service.Name}}Client{rpcHandler:rpcHandler,ServiceName:"{{$service.Name}}"}
+}
+
+func (slf *{{$service.Name}}Client) call(ctx context.Context,method string,args interface{},reply interface{},opts []rpc.CallOption) error {
+	if ctx != nil {
+		opts = append([]rpc.CallOption{rpc.WithContext(ctx)},opts...)
+	}
+	if slf.NodeId > 0 {
+		return slf.rpcHandler.CallNode(slf.NodeId,slf.ServiceName+"."+method,args,reply,opts...)
+	}
+	return slf.rpcHandler.Call(slf.ServiceName+"."+method,args,reply,opts...)
+}
+
+func (slf *{{$service.Name}}Client) asyncCall(method string,args interface{},callback interface{},opts []rpc.CallOption) error {
+	if slf.NodeId > 0 {
+		return slf.rpcHandler.AsyncCallNode(slf.NodeId,slf.ServiceName+"."+method,args,callback,opts...)
+	}
+	return slf.rpcHandler.AsyncCall(slf.ServiceName+"."+method,args,callback,opts...)
+}
+{{range .MethodList}}
+//调用{{$service.Name}}.{{.MethodName}}
+func (slf *{{$service.Name}}Client) {{.Name}}(ctx context.Context,args {{.ArgType}},opts ...rpc.CallOption) ({{.ReplyType}},error) {
+	reply := new({{.ReplyElem}})
+	err := slf.call(ctx,"{{.MethodName}}",args,reply,opts)
+	if err != nil {
+		return nil,err
+	}
+	return reply,nil
+}
+
+//异步调用{{$service.Name}}.{{.MethodName}}
+func (slf *{{$service.Name}}Client) Async{{.Name}}(args {{.ArgType}},callback func({{.ReplyType}},error),opts ...rpc.CallOption) error {
+	return slf.asyncCall("{{.MethodName}}",args,callback,opts)
+}
+{{end}}{{end}}`))
+
+func generate(pkgInfo *packageInfo) ([]byte,error) {
+	var buff bytes.Buffer
+	err := clientTemplate.Execute(&buff,pkgInfo)
+	if err != nil {
+		return nil,err
+	}
+
+	return format.Source(buff.Bytes())
+}
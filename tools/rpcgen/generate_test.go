@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const serviceSrc = `package testservice
+
+import (
+	"github.com/duanhf2012/origin/rpc"
+	"github.com/duanhf2012/origin/service"
+	tm "time"
+)
+
+type Req struct {
+	A int
+}
+
+type Ret struct {
+	B int
+}
+
+type TestService struct {
+	service.Service
+}
+
+func (slf *TestService) RPC_Add(req *Req,ret *Ret) error {
+	return nil
+}
+
+func (slf *TestService) RPC_Sum(input *[]int,output *int) error {
+	return nil
+}
+
+func (slf *TestService) RPC_Now(req *Req,ret *tm.Time) error {
+	return nil
+}
+
+func (slf *TestService) RPC_Raw(addition rpc.IRawAdditionParam,req *Req) error {
+	return nil
+}
+
+func (slf *TestService) Add(req *Req,ret *Ret) error {
+	return nil
+}
+`
+
+func writeService(t *testing.T,src string) string {
+	dir,err := ioutil.TempDir("","rpcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir,"testservice.go"),[]byte(src),0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestGenerate(t *testing.T) {
+	dir := writeService(t,serviceSrc)
+	defer os.RemoveAll(dir)
+
+	pkgInfo,err := parsePackage(dir,nil,"rpcclient_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src,err := generate(pkgInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(src)
+	for _,expect := range []string{
+		`tm "time"`,
+		"func NewTestServiceClient(rpcHandler rpc.IRpcHandler) *TestServiceClient",
+		"func (slf *TestServiceClient) Add(ctx context.Context, args *Req, opts ...rpc.CallOption) (*Ret, error)",
+		"func (slf *TestServiceClient) AsyncAdd(args *Req, callback func(*Ret, error), opts ...rpc.CallOption) error",
+		"func (slf *TestServiceClient) Sum(ctx context.Context, args *[]int, opts ...rpc.CallOption) (*int, error)",
+		"func (slf *TestServiceClient) Now(ctx context.Context, args *Req, opts ...rpc.CallOption) (*tm.Time, error)",
+	} {
+		if strings.Contains(out,expect) == false {
+			t.Errorf("generated code missing %q:\n%s",expect,out)
+		}
+	}
+	if strings.Contains(out,"RPC_Raw") {
+		t.Errorf("raw method should be skipped:\n%s",out)
+	}
+}
+
+func TestGenerateInvalidMethod(t *testing.T) {
+	for _,src := range []string{
+		"package p\ntype S struct{}\nfunc (slf *S) RPC_A(a *int,b *int) int { return 0 }\n",
+		"package p\ntype S struct{}\nfunc (slf *S) RPC_A(a int,b *int) error { return nil }\n",
+		"package p\ntype S struct{}\ntype req struct{}\nfunc (slf *S) RPC_A(a *req,b *int) error { return nil }\n",
+		"package p\ntype S struct{}\nfunc (slf *S) RPC_A(a *int,b *int) error { return nil }\nfunc (slf *S) RPC_AsyncA(a *int,b *int) error { return nil }\n",
+		//运行时不能注册只有一个参数或没有参数的方法
+		"package p\ntype S struct{}\nfunc (slf *S) RPC_A(a *int) error { return nil }\n",
+		"package p\ntype S struct{}\nfunc (slf *S) RPC_A() error { return nil }\n",
+	} {
+		dir := writeService(t,src)
+		_,err := parsePackage(dir,nil,"rpcclient_gen.go")
+		os.RemoveAll(dir)
+		if err == nil {
+			t.Errorf("expect error for:\n%s",src)
+		}
+	}
+}
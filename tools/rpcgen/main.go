@@ -0,0 +1,52 @@
+//rpcgen为服务的RPC_方法生成带类型的客户端,在服务所在的包中添加:
+//
+//	//go:generate go run github.com/duanhf2012/origin/tools/rpcgen -type TestService
+//
+//执行go generate后生成TestServiceClient,可以这样调用:
+//
+//	ret,err := NewTestServiceClient(slf).Add(ctx,&req)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var typeNames = flag.String("type","","逗号分隔的服务类型名,为空时生成包中所有带RPC_方法的类型")
+var output = flag.String("output","rpcclient_gen.go","生成的文件名")
+
+func main() {
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	var typeList []string
+	if *typeNames != "" {
+		typeList = strings.Split(*typeNames,",")
+	}
+
+	outputFile := filepath.Join(dir,*output)
+	pkgInfo,err := parsePackage(dir,typeList,filepath.Base(outputFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr,"rpcgen: %+v\n",err)
+		os.Exit(1)
+	}
+
+	src,err := generate(pkgInfo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr,"rpcgen: %+v\n",err)
+		os.Exit(1)
+	}
+
+	err = ioutil.WriteFile(outputFile,src,0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr,"rpcgen: %+v\n",err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,245 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/duanhf2012/origin/log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var Default_SpanQueueLen = 10000              //等待导出的span数量上限,超过时丢弃
+var Default_ExportBatchSize = 512             //每次最多导出的span数量
+var Default_ExportInterval = time.Second      //导出间隔
+var Default_HttpExportTimeout = 5*time.Second
+
+//span导出器,data为OTLP/JSON格式的ExportTraceServiceRequest
+type IExporter interface {
+	Export(data []byte) error
+	Close()
+}
+
+//追加写入文件,每次导出的数据占一行
+type FileExporter struct {
+	file *os.File
+}
+
+//通过OTLP/HTTP发送到collector,如http://127.0.0.1:4318/v1/traces
+type HttpExporter struct {
+	url string
+	client *http.Client
+}
+
+func NewFileExporter(filePath string) (*FileExporter,error) {
+	file,err := os.OpenFile(filePath,os.O_WRONLY|os.O_APPEND|os.O_CREATE,0644)
+	if err != nil {
+		return nil,err
+	}
+
+	return &FileExporter{file:file},nil
+}
+
+func (slf *FileExporter) Export(data []byte) error {
+	_,err := slf.file.Write(append(data,'\n'))
+	return err
+}
+
+func (slf *FileExporter) Close() {
+	slf.file.Close()
+}
+
+func NewHttpExporter(url string) *HttpExporter {
+	return &HttpExporter{url:url,client:&http.Client{Timeout:Default_HttpExportTimeout}}
+}
+
+func (slf *HttpExporter) Export(data []byte) error {
+	resp,err := slf.client.Post(slf.url,"application/json",bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export span to %s fail,status %s",slf.url,resp.Status)
+	}
+	return nil
+}
+
+func (slf *HttpExporter) Close() {
+}
+
+//在单独的协程中批量导出span
+type batchExporter struct {
+	exporter IExporter
+	resource otlpResource
+	spanChan chan *Span
+	closeSig chan bool
+	closeOnce sync.Once
+	wg sync.WaitGroup
+}
+
+var spanExporter *batchExporter
+
+func newBatchExporter(serviceName string,nodeId int,exporter IExporter) *batchExporter {
+	slf := &batchExporter{exporter:exporter}
+	slf.resource.Attributes = []otlpAttribute{
+		newOtlpAttribute("service.name",serviceName),
+		newOtlpAttribute("origin.node_id",strconv.Itoa(nodeId)),
+	}
+	slf.spanChan = make(chan *Span,Default_SpanQueueLen)
+	slf.closeSig = make(chan bool)
+	slf.wg.Add(1)
+	go slf.run()
+
+	return slf
+}
+
+func (slf *batchExporter) push(span *Span) {
+	select {
+	case <-slf.closeSig:
+		return
+	default:
+	}
+
+	select {
+	case slf.spanChan <- span:
+	default:
+		log.Error("span queue is full,span %s is discarded.",span.Name)
+	}
+}
+
+func (slf *batchExporter) close() {
+	slf.closeOnce.Do(func() {
+		close(slf.closeSig)
+	})
+	slf.wg.Wait()
+}
+
+func (slf *batchExporter) run() {
+	defer slf.wg.Done()
+	ticker := time.NewTicker(Default_ExportInterval)
+	defer ticker.Stop()
+
+	spanList := make([]*Span,0,Default_ExportBatchSize)
+	for {
+		select {
+		case span := <-slf.spanChan:
+			spanList = append(spanList,span)
+			if len(spanList) >= Default_ExportBatchSize {
+				spanList = slf.export(spanList)
+			}
+		case <-ticker.C:
+			spanList = slf.export(spanList)
+		case <-slf.closeSig:
+			//导出剩余的span后退出
+			for len(slf.spanChan) > 0 {
+				spanList = append(spanList,<-slf.spanChan)
+				if len(spanList) >= Default_ExportBatchSize {
+					spanList = slf.export(spanList)
+				}
+			}
+			slf.export(spanList)
+			slf.exporter.Close()
+			return
+		}
+	}
+}
+
+func (slf *batchExporter) export(spanList []*Span) []*Span {
+	if len(spanList) == 0 {
+		return spanList
+	}
+
+	data,err := json.Marshal(slf.makeRequest(spanList))
+	if err == nil {
+		err = slf.exporter.Export(data)
+	}
+	if err != nil {
+		log.Error("export %d spans fail:%+v",len(spanList),err)
+	}
+
+	return spanList[:0]
+}
+
+//OTLP/JSON格式,trace id与span id为hex字符串,时间为字符串形式的纳秒
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource otlpResource `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId string `json:"traceId"`
+	SpanId string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind SpanKind `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano string `json:"endTimeUnixNano"`
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+	Status otlpStatus `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key string `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"` //0未设置,2错误
+	Message string `json:"message,omitempty"`
+}
+
+func newOtlpAttribute(key string,value string) otlpAttribute {
+	return otlpAttribute{Key:key,Value:otlpAnyValue{StringValue:value}}
+}
+
+func (slf *batchExporter) makeRequest(spanList []*Span) *otlpExportRequest {
+	scopeSpans := otlpScopeSpans{Scope:otlpScope{Name:"github.com/duanhf2012/origin"}}
+	for _,span := range spanList {
+		s := otlpSpan{
+			TraceId:span.TraceId.String(),
+			SpanId:span.SpanId.String(),
+			Name:span.Name,
+			Kind:span.Kind,
+			StartTimeUnixNano:strconv.FormatInt(span.StartTime.UnixNano(),10),
+			EndTimeUnixNano:strconv.FormatInt(span.EndTime.UnixNano(),10),
+		}
+		if span.ParentSpanId != (SpanId{}) {
+			s.ParentSpanId = span.ParentSpanId.String()
+		}
+		for key,value := range span.Attributes {
+			s.Attributes = append(s.Attributes,newOtlpAttribute(key,value))
+		}
+		if span.Err != nil {
+			s.Status = otlpStatus{Code:2,Message:span.Err.Error()}
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans,s)
+	}
+
+	return &otlpExportRequest{ResourceSpans:[]otlpResourceSpans{{Resource:slf.resource,ScopeSpans:[]otlpScopeSpans{scopeSpans}}}}
+}
@@ -0,0 +1,244 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//随rpc请求头传递的trace上下文,格式与W3C traceparent一致
+const HeaderTraceParent = "traceparent"
+
+type SpanKind int
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+//标识一个span,在结点间与协程间传递
+type SpanContext struct {
+	TraceId TraceId
+	SpanId SpanId
+}
+
+type Span struct {
+	Name string
+	Kind SpanKind
+	TraceId TraceId
+	SpanId SpanId
+	ParentSpanId SpanId
+	StartTime time.Time
+	EndTime time.Time
+	Attributes map[string]string
+	Err error
+
+	ctx *Context
+	prev SpanContext //Attach前ctx的上下文,End时恢复
+}
+
+//服务当前的trace上下文,由服务持有并只在服务协程中访问。多协程的服务不跟踪,所有方法返回空
+type Context struct {
+	current SpanContext
+	disabled bool
+}
+
+var isOpen int32
+
+func (slf TraceId) String() string {
+	return hex.EncodeToString(slf[:])
+}
+
+func (slf SpanId) String() string {
+	return hex.EncodeToString(slf[:])
+}
+
+func (slf SpanContext) IsValid() bool {
+	return slf.TraceId != TraceId{} && slf.SpanId != SpanId{}
+}
+
+//转换成traceparent格式:00-traceid-spanid-01
+func (slf SpanContext) TraceParent() string {
+	return "00-"+slf.TraceId.String()+"-"+slf.SpanId.String()+"-01"
+}
+
+//解析traceparent,格式错误时返回false
+func ParseTraceParent(traceParent string) (SpanContext,bool) {
+	var sc SpanContext
+	sParam := strings.Split(traceParent,"-")
+	if len(sParam) != 4 || len(sParam[1]) != 32 || len(sParam[2]) != 16 {
+		return sc,false
+	}
+	if _,err := hex.Decode(sc.TraceId[:],[]byte(sParam[1]));err != nil {
+		return sc,false
+	}
+	if _,err := hex.Decode(sc.SpanId[:],[]byte(sParam[2]));err != nil {
+		return sc,false
+	}
+
+	return sc,sc.IsValid()
+}
+
+//将上下文写入请求头
+func Inject(header map[string]string,sc SpanContext) {
+	if sc.IsValid() {
+		header[HeaderTraceParent] = sc.TraceParent()
+	}
+}
+
+//从请求头中读取上下文
+func Extract(header map[string]string) (SpanContext,bool) {
+	traceParent,ok := header[HeaderTraceParent]
+	if ok == false {
+		return SpanContext{},false
+	}
+
+	return ParseTraceParent(traceParent)
+}
+
+//开启trace,exporter为nil时只传递与打印trace id,不导出span
+func Open(serviceName string,nodeId int,exporter IExporter) {
+	if atomic.CompareAndSwapInt32(&isOpen,0,1) == false {
+		return
+	}
+
+	spanExporter = nil
+	if exporter != nil {
+		spanExporter = newBatchExporter(serviceName,nodeId,exporter)
+	}
+}
+
+//关闭trace,导出剩余的span
+func Close() {
+	if atomic.CompareAndSwapInt32(&isOpen,1,0) == false {
+		return
+	}
+
+	if spanExporter != nil {
+		spanExporter.close()
+	}
+}
+
+func IsOpen() bool {
+	return atomic.LoadInt32(&isOpen) == 1
+}
+
+//创建parent的子span,parent无效时开始新的trace,未开启trace时返回nil
+func NewSpan(parent SpanContext,name string,kind SpanKind) *Span {
+	if IsOpen() == false {
+		return nil
+	}
+
+	span := &Span{Name:name,Kind:kind,StartTime:time.Now()}
+	if parent.IsValid() {
+		span.TraceId = parent.TraceId
+		span.ParentSpanId = parent.SpanId
+	}else{
+		rand.Read(span.TraceId[:])
+	}
+	rand.Read(span.SpanId[:])
+
+	return span
+}
+
+func (slf *Span) Context() SpanContext {
+	if slf == nil {
+		return SpanContext{}
+	}
+
+	return SpanContext{TraceId:slf.TraceId,SpanId:slf.SpanId}
+}
+
+//设置为ctx的当前上下文,之后通过ctx打印的日志与发出的调用都属于该span
+func (slf *Span) Attach(ctx *Context) *Span {
+	if slf == nil || ctx == nil {
+		return slf
+	}
+
+	slf.ctx = ctx
+	slf.prev = ctx.SetCurrent(slf.Context())
+	return slf
+}
+
+func (slf *Span) SetAttribute(key string,value string) {
+	if slf == nil {
+		return
+	}
+
+	if slf.Attributes == nil {
+		slf.Attributes = map[string]string{}
+	}
+	slf.Attributes[key] = value
+}
+
+func (slf *Span) SetError(err error) {
+	if slf == nil {
+		return
+	}
+
+	slf.Err = err
+}
+
+//结束span并导出,已Attach的span恢复ctx原来的上下文
+func (slf *Span) End() {
+	if slf == nil {
+		return
+	}
+
+	slf.EndTime = time.Now()
+	if slf.ctx != nil {
+		slf.ctx.SetCurrent(slf.prev)
+	}
+	if exporter := spanExporter;exporter != nil {
+		exporter.push(slf)
+	}
+}
+
+//以当前上下文为parent创建span,并设置为当前上下文
+func (slf *Context) StartSpan(name string,kind SpanKind) *Span {
+	return NewSpan(slf.Current(),name,kind).Attach(slf)
+}
+
+//获取当前上下文
+func (slf *Context) Current() SpanContext {
+	if slf == nil || slf.disabled == true || IsOpen() == false {
+		return SpanContext{}
+	}
+
+	return slf.current
+}
+
+//设置当前上下文,sc无效时清除,返回原来的上下文
+func (slf *Context) SetCurrent(sc SpanContext) SpanContext {
+	if slf == nil || slf.disabled == true || IsOpen() == false {
+		return SpanContext{}
+	}
+
+	prev := slf.current
+	slf.current = sc
+	return prev
+}
+
+//不再跟踪,需要在服务协程启动前调用
+func (slf *Context) Disable() {
+	slf.disabled = true
+	slf.current = SpanContext{}
+}
+
+//日志前缀,没有上下文时返回空字符串
+func (slf *Context) LogPrefix() string {
+	sc := slf.Current()
+	if sc.IsValid() == false {
+		return ""
+	}
+
+	return fmt.Sprintf("[trace:%s span:%s] ",sc.TraceId.String(),sc.SpanId.String())
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type testExporter struct {
+	dataList [][]byte
+	closed bool
+}
+
+func (slf *testExporter) Export(data []byte) error {
+	slf.dataList = append(slf.dataList,data)
+	return nil
+}
+
+func (slf *testExporter) Close() {
+	slf.closed = true
+}
+
+func TestParseTraceParent(t *testing.T) {
+	sc,ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if ok == false {
+		t.Fatal("parse traceparent fail")
+	}
+	if sc.TraceId.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId.String() != "00f067aa0ba902b7" {
+		t.Fatalf("parse traceparent is %s",sc.TraceParent())
+	}
+	if sc.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("traceparent is %s",sc.TraceParent())
+	}
+
+	for _,traceParent := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _,ok := ParseTraceParent(traceParent);ok == true {
+			t.Fatalf("traceparent %s must be invalid",traceParent)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	exporter := &testExporter{}
+	Open("test",1,exporter)
+
+	//注入请求头后在另一个上下文中恢复
+	var callerCtx,calleeCtx Context
+	clientSpan := callerCtx.StartSpan("Test.RPC_Call",SpanKindClient)
+	header := map[string]string{}
+	Inject(header,callerCtx.Current())
+	sc,ok := Extract(header)
+	if ok == false || sc != clientSpan.Context() {
+		t.Fatalf("extract context %+v,expect %+v",sc,clientSpan.Context())
+	}
+	serverSpan := NewSpan(sc,"Test.RPC_Call",SpanKindServer).Attach(&calleeCtx)
+	if calleeCtx.Current().TraceId != clientSpan.TraceId || serverSpan.ParentSpanId != clientSpan.SpanId {
+		t.Fatal("server span is not the child of client span")
+	}
+	serverSpan.SetError(errors.New("call fail"))
+	serverSpan.End()
+	clientSpan.End()
+	if calleeCtx.Current().IsValid() == true || callerCtx.Current().IsValid() == true {
+		t.Fatal("context must be restored after span end")
+	}
+
+	//停用后不再记录上下文
+	var disabledCtx Context
+	disabledCtx.Disable()
+	disabledCtx.SetCurrent(sc)
+	if disabledCtx.Current().IsValid() == true || disabledCtx.LogPrefix() != "" {
+		t.Fatal("disabled context must be empty")
+	}
+
+	Close()
+	if exporter.closed == false || len(exporter.dataList) != 1 {
+		t.Fatalf("export %d times,closed %v",len(exporter.dataList),exporter.closed)
+	}
+
+	var req otlpExportRequest
+	if err := json.Unmarshal(exporter.dataList[0],&req);err != nil {
+		t.Fatalf("unmarshal export data fail:%+v",err)
+	}
+	spanList := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spanList) != 2 {
+		t.Fatalf("export %d spans",len(spanList))
+	}
+	if spanList[0].ParentSpanId != clientSpan.SpanId.String() || spanList[0].TraceId != clientSpan.TraceId.String() || spanList[0].Status.Code != 2 {
+		t.Fatalf("server span is %+v",spanList[0])
+	}
+	if spanList[1].SpanId != clientSpan.SpanId.String() || spanList[1].ParentSpanId != "" || spanList[1].Kind != SpanKindClient {
+		t.Fatalf("client span is %+v",spanList[1])
+	}
+
+	//关闭后不再创建span
+	if NewSpan(sc,"Test",SpanKindInternal) != nil {
+		t.Fatal("span must be nil after trace is closed")
+	}
+}
@@ -0,0 +1,353 @@
+package cfgbind
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+var durationType = reflect.TypeOf(time.Duration(0))
+
+//将解析后的配置(map[string]interface{})绑定到结构体指针out,支持以下tag:
+//json:"Name"      配置中的字段名,默认为结构体字段名
+//default:"3000"   配置中没有该字段时使用的值,数组、map与结构体使用json格式
+//validate:"required,min=1,max=65535,oneof=tcp udp"
+//  required:不能为零值
+//  min/max:数值的范围,字符串、数组与map为长度的范围
+//  oneof:只能为列出的值之一,以空格分隔
+//配置中没有并且没有default的字段保持原值,可以在绑定前设置好默认值。time.Duration使用"10s"格式的字符串
+func Bind(cfg interface{},out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("bind target must be a non-nil pointer")
+	}
+
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+
+	return bindValue(cfg,v.Elem(),"")
+}
+
+func fieldError(path string,format string,a ...interface{}) error {
+	if path == "" {
+		return fmt.Errorf(format,a...)
+	}
+
+	return fmt.Errorf("field %s:%s",path,fmt.Sprintf(format,a...))
+}
+
+func bindValue(src interface{},dst reflect.Value,path string) error {
+	if dst.Type() == durationType {
+		return bindDuration(src,dst,path)
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src == nil {
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return bindValue(src,dst.Elem(),path)
+	case reflect.Interface:
+		if src != nil {
+			dst.Set(reflect.ValueOf(src))
+		}
+		return nil
+	case reflect.Struct:
+		m,ok := src.(map[string]interface{})
+		if ok == false {
+			return fieldError(path,"expect object but got %s",typeName(src))
+		}
+		return bindStruct(m,dst,path)
+	case reflect.Map:
+		m,ok := src.(map[string]interface{})
+		if ok == false {
+			return fieldError(path,"expect object but got %s",typeName(src))
+		}
+		if dst.Type().Key().Kind() != reflect.String {
+			return fieldError(path,"map key must be string")
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for k,v := range m {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			err := bindValue(v,elem,joinPath(path,k))
+			if err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()),elem)
+		}
+		return nil
+	case reflect.Slice:
+		list,ok := src.([]interface{})
+		if ok == false {
+			return fieldError(path,"expect array but got %s",typeName(src))
+		}
+		slice := reflect.MakeSlice(dst.Type(),len(list),len(list))
+		for i,v := range list {
+			err := bindValue(v,slice.Index(i),fmt.Sprintf("%s[%d]",path,i))
+			if err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+	case reflect.String:
+		s,ok := src.(string)
+		if ok == false {
+			return fieldError(path,"expect string but got %s",typeName(src))
+		}
+		dst.SetString(s)
+		return nil
+	case reflect.Bool:
+		b,ok := src.(bool)
+		if ok == false {
+			return fieldError(path,"expect bool but got %s",typeName(src))
+		}
+		dst.SetBool(b)
+		return nil
+	case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64:
+		f,ok := toFloat(src)
+		if ok == false {
+			return fieldError(path,"expect integer but got %s",typeName(src))
+		}
+		if f != float64(int64(f)) || dst.OverflowInt(int64(f)) {
+			return fieldError(path,"%v is not a valid %s",src,dst.Type())
+		}
+		dst.SetInt(int64(f))
+		return nil
+	case reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64:
+		f,ok := toFloat(src)
+		if ok == false {
+			return fieldError(path,"expect integer but got %s",typeName(src))
+		}
+		if f < 0 || f != float64(uint64(f)) || dst.OverflowUint(uint64(f)) {
+			return fieldError(path,"%v is not a valid %s",src,dst.Type())
+		}
+		dst.SetUint(uint64(f))
+		return nil
+	case reflect.Float32,reflect.Float64:
+		f,ok := toFloat(src)
+		if ok == false {
+			return fieldError(path,"expect number but got %s",typeName(src))
+		}
+		dst.SetFloat(f)
+		return nil
+	}
+
+	return fieldError(path,"unsupported type %s",dst.Type())
+}
+
+func bindDuration(src interface{},dst reflect.Value,path string) error {
+	s,ok := src.(string)
+	if ok == false {
+		return fieldError(path,"expect duration string like \"10s\" but got %s",typeName(src))
+	}
+
+	d,err := time.ParseDuration(s)
+	if err != nil {
+		return fieldError(path,"%s is not a valid duration",s)
+	}
+	dst.SetInt(int64(d))
+	return nil
+}
+
+func bindStruct(m map[string]interface{},dst reflect.Value,path string) error {
+	t := dst.Type()
+	for i:=0;i<t.NumField();i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag := field.Tag.Get("json");tag != "" {
+			name = strings.Split(tag,",")[0]
+			if name == "-" {
+				continue
+			}
+		}
+
+		fv := dst.Field(i)
+		//匿名结构体的字段与外层在同一级
+		if field.Anonymous == true && field.Tag.Get("json") == "" && fv.Kind() == reflect.Struct {
+			err := bindStruct(m,fv,path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldPath := joinPath(path,name)
+		raw,ok := lookup(m,name)
+		if ok == false {
+			if def,hasDefault := field.Tag.Lookup("default");hasDefault == true {
+				raw,ok = parseDefault(def,fv.Type()),true
+			}
+		}
+		if ok == true {
+			err := bindValue(raw,fv,fieldPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := validate(fv,field.Tag.Get("validate"),fieldPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path string,name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path+"."+name
+}
+
+//字段名优先精确匹配,其次忽略大小写
+func lookup(m map[string]interface{},name string) (interface{},bool) {
+	if v,ok := m[name];ok == true {
+		return v,true
+	}
+
+	for k,v := range m {
+		if strings.EqualFold(k,name) {
+			return v,true
+		}
+	}
+
+	return nil,false
+}
+
+func parseDefault(def string,t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.String || t == durationType {
+		return def
+	}
+
+	var v interface{}
+	if json.Unmarshal([]byte(def),&v) != nil {
+		return def
+	}
+
+	return v
+}
+
+func toFloat(src interface{}) (float64,bool) {
+	v := reflect.ValueOf(src)
+	switch v.Kind() {
+	case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64:
+		return float64(v.Int()),true
+	case reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64:
+		return float64(v.Uint()),true
+	case reflect.Float32,reflect.Float64:
+		return v.Float(),true
+	}
+
+	return 0,false
+}
+
+func typeName(src interface{}) string {
+	switch src.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q",src)
+	case bool:
+		return fmt.Sprintf("bool %v",src)
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	if _,ok := toFloat(src);ok == true {
+		return fmt.Sprintf("number %v",src)
+	}
+
+	return fmt.Sprintf("%T",src)
+}
+
+func validate(v reflect.Value,rules string,path string) error {
+	if rules == "" {
+		return nil
+	}
+
+	for _,rule := range strings.Split(rules,",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name,param := rule,""
+		if idx := strings.Index(rule,"=");idx>=0 {
+			name,param = rule[:idx],rule[idx+1:]
+		}
+
+		if v.Kind() == reflect.Ptr && v.IsNil() && name != "required" {
+			continue
+		}
+
+		switch name {
+		case "required":
+			if v.IsZero() {
+				return fieldError(path,"is required")
+			}
+		case "min","max":
+			limit,err := strconv.ParseFloat(param,64)
+			if err != nil {
+				return fieldError(path,"invalid validate rule %s",rule)
+			}
+			value,isLen := measure(v)
+			if (name == "min" && value < limit) || (name == "max" && value > limit) {
+				if isLen == true {
+					return fieldError(path,"length %v should be %s %v",value,name,limit)
+				}
+				return fieldError(path,"%v should be %s %v",value,name,limit)
+			}
+		case "oneof":
+			value := fmt.Sprint(reflect.Indirect(v).Interface())
+			bFound := false
+			for _,s := range strings.Fields(param) {
+				if s == value {
+					bFound = true
+					break
+				}
+			}
+			if bFound == false {
+				return fieldError(path,"%s should be one of [%s]",value,param)
+			}
+		default:
+			return fieldError(path,"unknown validate rule %s",rule)
+		}
+	}
+
+	return nil
+}
+
+//数值返回值本身,字符串、数组与map返回长度
+func measure(v reflect.Value) (float64,bool) {
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.String,reflect.Slice,reflect.Map,reflect.Array:
+		return float64(v.Len()),true
+	}
+
+	f,_ := toFloat(v.Interface())
+	return f,false
+}
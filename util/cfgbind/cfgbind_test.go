@@ -0,0 +1,63 @@
+package cfgbind
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCAFile struct {
+	Certfile string `validate:"required"`
+	Keyfile  string
+}
+
+type testCfg struct {
+	ListenAddr string `validate:"required"`
+	MaxConnNum int    `default:"3000" validate:"min=1,max=10000"`
+	Mode       string `default:"tcp" validate:"oneof=tcp udp"`
+	Timeout    time.Duration `default:"10s"`
+	Ratio      float64
+	CAFile     []testCAFile
+	Tags       map[string]int
+}
+
+func TestBind(t *testing.T) {
+	var cfg testCfg
+	err := Bind(map[string]interface{}{
+		"ListenAddr":"0.0.0.0:9030",
+		"ratio":0.5,
+		"CAFile":[]interface{}{map[string]interface{}{"Certfile":"a.crt","Keyfile":"a.key"}},
+		"Tags":map[string]interface{}{"a":float64(1)},
+	},&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddr != "0.0.0.0:9030" || cfg.MaxConnNum != 3000 || cfg.Mode != "tcp" || cfg.Timeout != 10*time.Second ||
+		cfg.Ratio != 0.5 || len(cfg.CAFile) != 1 || cfg.CAFile[0].Keyfile != "a.key" || cfg.Tags["a"] != 1 {
+		t.Fatalf("bind result is error %+v",cfg)
+	}
+}
+
+func TestBindError(t *testing.T) {
+	testCases := []struct {
+		cfg map[string]interface{}
+		errField string
+	}{
+		{map[string]interface{}{},"field ListenAddr:"},
+		{map[string]interface{}{"ListenAddr":"a","MaxConnNum":"3000"},"field MaxConnNum:"},
+		{map[string]interface{}{"ListenAddr":"a","MaxConnNum":1.5},"field MaxConnNum:"},
+		{map[string]interface{}{"ListenAddr":"a","MaxConnNum":float64(20000)},"field MaxConnNum:"},
+		{map[string]interface{}{"ListenAddr":"a","Mode":"http"},"field Mode:"},
+		{map[string]interface{}{"ListenAddr":"a","Timeout":float64(10)},"field Timeout:"},
+		{map[string]interface{}{"ListenAddr":"a","CAFile":[]interface{}{map[string]interface{}{}}},"field CAFile[0].Certfile:"},
+	}
+
+	for _,c := range testCases {
+		var cfg testCfg
+		err := Bind(c.cfg,&cfg)
+		if err == nil || strings.HasPrefix(err.Error(),c.errField) == false {
+			t.Fatalf("config %+v expect error %s but got %v",c.cfg,c.errField,err)
+		}
+	}
+}